@@ -0,0 +1,59 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+//go:build cgo && cshared
+// +build cgo,cshared
+
+package main
+
+// #include <stdlib.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/urbanopt/modelica-fmt/format"
+)
+
+// ModelicaFormat formats the NUL terminated Modelica source. The options are a
+// JSON or YAML mapping with the keys of the configuration file, e.g.
+// {"line-endings": "crlf"}, or NULL for the defaults. It returns the formatted
+// source, or NULL when formatting failed in which case *error is set to the
+// error message if error is not NULL. Returned strings must be released with
+// ModelicaFree.
+//
+//export ModelicaFormat
+func ModelicaFormat(source, options *C.char, error **C.char) *C.char {
+	output, err := formatC(C.GoString(source), options)
+	if err != nil {
+		if error != nil {
+			*error = C.CString(err.Error())
+		}
+		return nil
+	}
+	return C.CString(output)
+}
+
+// ModelicaFree releases a string returned by ModelicaFormat
+//
+//export ModelicaFree
+func ModelicaFree(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// formatC formats source with the options encoded in the C string options.
+// A source with a skip-file directive is returned as it is.
+func formatC(source string, options *C.char) (string, error) {
+	opts := format.DefaultOptions()
+	if options != nil {
+		var err error
+		if opts, err = format.DecodeOptions([]byte(C.GoString(options))); err != nil {
+			return "", err
+		}
+	}
+	output, err := format.FormatString(source, opts)
+	if err == format.ErrSkipped {
+		return source, nil
+	}
+	return output, err
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/urbanopt/modelica-fmt/format"
+)
+
+// excerpt returns the line of source holding the problem of d followed by a
+// line with a caret under its column, or "" if the source has no such line
+func excerpt(d format.Diagnostic, source string) string {
+	lines := strings.Split(source, "\n")
+	if d.Line < 1 || d.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[d.Line-1], "\r")
+	if line == "" {
+		// such as the line after the final newline, at the end of file
+		return ""
+	}
+	var caret strings.Builder
+	for i, r := range []rune(line) {
+		if i >= d.Column-1 {
+			break
+		}
+		if r == '\t' {
+			// keep the caret under the column however tabs are displayed
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return line + "\n" + caret.String() + "\n"
+}
+
+const (
+	// diagnosticsText writes a `file:line:column: message` line per diagnostic
+	diagnosticsText = "text"
+	// diagnosticsJSON writes a JSON record per line, see diagnosticRecord
+	diagnosticsJSON = "json"
+)
+
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// diagnosticRecord is the JSON representation of a diagnostic about a file
+type diagnosticRecord struct {
+	File     string           `json:"file"`
+	Range    *diagnosticRange `json:"range,omitempty"` // nil if the problem is not at a position
+	Severity string           `json:"severity"`
+	Message  string           `json:"message"`
+	Rule     string           `json:"rule,omitempty"`
+}
+
+type diagnosticRange struct {
+	Start format.Position `json:"start"`
+	End   format.Position `json:"end"` // position following the offending text
+}
+
+// writeDiagnostics writes the diagnostics about a file in the given format.
+// Text diagnostics are followed by their excerpt of source, unless it is
+// empty.
+func writeDiagnostics(w io.Writer, diagnosticsFormat string, filename string, severity string, diagnostics []format.Diagnostic, source string) error {
+	encoder := json.NewEncoder(w)
+	for _, diagnostic := range diagnostics {
+		if diagnosticsFormat == diagnosticsText {
+			if _, err := fmt.Fprintf(w, "%s:%s\n%s", filename, diagnostic, excerpt(diagnostic, source)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		record := diagnosticRecord{File: filename, Severity: severity, Message: diagnostic.Message, Rule: diagnostic.Rule}
+		if diagnostic.Line > 0 {
+			record.Range = &diagnosticRange{
+				Start: format.Position{Line: diagnostic.Line, Column: diagnostic.Column},
+				End:   diagnostic.End,
+			}
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urbanopt/modelica-fmt/format"
+)
+
+func TestExcerpt(t *testing.T) {
+	a := require.New(t)
+	source := "model A\n\tReal x = $1;\nend A;\n"
+	a.Equal("\tReal x = $1;\n\t         ^\n", excerpt(format.Diagnostic{Line: 2, Column: 11}, source))
+	a.Equal("model A\n^\n", excerpt(format.Diagnostic{Line: 1, Column: 1}, source))
+	a.Equal("", excerpt(format.Diagnostic{Line: 4, Column: 1}, source))
+	a.Equal("", excerpt(format.Diagnostic{Line: 5, Column: 1}, source))
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	a := require.New(t)
+	source := "model A\n  Real x = );\nend A;\n"
+	diagnostics := format.Validate(strings.NewReader(source))
+
+	var b strings.Builder
+	a.NoError(writeDiagnostics(&b, diagnosticsText, "A.mo", severityError, diagnostics, source))
+	a.Equal("A.mo:2:12: unexpected token ')'\n  Real x = );\n           ^\n", b.String())
+
+	b.Reset()
+	a.NoError(writeDiagnostics(&b, diagnosticsJSON, "A.mo", severityError, diagnostics, source))
+	a.JSONEq(`{
+		"file": "A.mo",
+		"range": {"start": {"line": 2, "column": 12}, "end": {"line": 2, "column": 13}},
+		"severity": "error",
+		"message": "unexpected token ')'",
+		"rule": "expression"
+	}`, b.String())
+
+	b.Reset()
+	a.NoError(writeDiagnostics(&b, diagnosticsJSON, "A.mo", severityWarning, []format.Diagnostic{{Message: format.ErrSkipped.Error()}}, ""))
+	a.JSONEq(`{"file": "A.mo", "severity": "warning", "message": "skipped by a modelica-fmt: skip-file directive"}`, b.String())
+}
@@ -0,0 +1,528 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// alignElements computes the padding aligning the elements of the list, see
+// Options.AlignParameters, Options.AlignDescriptions and
+// Options.AlignDeclarations. It is called when entering the first element of
+// the list, whose indentation is that of all the elements.
+func (l *modelicaListener) alignElements(list *parser.Element_listContext) {
+	if l.alignedLists[list] {
+		return
+	}
+	l.alignedLists[list] = true
+
+	var elements []*parser.ElementContext
+	for _, element := range list.AllElement() {
+		elements = append(elements, element.(*parser.ElementContext))
+	}
+	if l.opts.AlignDeclarations {
+		// the columns of the table, in order
+		columns := []func(declaration *parser.Component_declarationContext) antlr.TerminalNode{
+			typeName, componentName, bindingEquals,
+		}
+		for _, column := range columns {
+			column := column
+			l.alignGroups(elements, singleLineDeclaration, func(element *parser.ElementContext) antlr.TerminalNode {
+				return column(singleLineDeclaration(element))
+			})
+		}
+		l.alignGroups(elements, singleLineDeclaration, l.alignedDescription)
+		return
+	}
+	if l.opts.AlignParameters {
+		l.alignGroups(elements, parameterDeclaration, func(element *parser.ElementContext) antlr.TerminalNode {
+			return bindingEquals(parameterDeclaration(element))
+		})
+	}
+	if l.opts.AlignDescriptions {
+		describedDeclaration := func(element *parser.ElementContext) *parser.Component_declarationContext {
+			if l.alignedDescription(element) == nil {
+				return nil
+			}
+			return singleLineDeclaration(element)
+		}
+		l.alignGroups(elements, describedDeclaration, l.alignedDescription)
+	}
+}
+
+// alignGroups aligns the terminals returned by target for the elements, which
+// may be nil for an element with nothing to align. Consecutive elements for
+// which declaration is not nil are aligned together unless a blank line or a
+// comment separates them.
+func (l *modelicaListener) alignGroups(elements []*parser.ElementContext,
+	declaration func(element *parser.ElementContext) *parser.Component_declarationContext,
+	target func(element *parser.ElementContext) antlr.TerminalNode) {
+	var previous *parser.ElementContext
+	var terminals []antlr.TerminalNode
+	var widths []int
+	align := func() {
+		l.alignColumn(terminals, widths)
+		terminals, widths = terminals[:0], widths[:0]
+	}
+
+	for _, element := range elements {
+		if declaration(element) == nil {
+			align()
+			previous = nil
+			continue
+		}
+		if previous != nil && !l.contiguous(previous, element) {
+			align()
+		}
+		previous = element
+		if terminal := target(element); terminal != nil {
+			terminals = append(terminals, terminal)
+			widths = append(widths, l.widthBefore(element, terminal))
+		}
+	}
+	align()
+}
+
+// alignColumn pads the terminals, preceded by widths on their lines, so that
+// they line up
+func (l *modelicaListener) alignColumn(terminals []antlr.TerminalNode, widths []int) {
+	max := 0
+	for _, width := range widths {
+		if width > max {
+			max = width
+		}
+	}
+	for i, terminal := range terminals {
+		l.alignments[terminal] = max - widths[i]
+	}
+}
+
+// alignArguments computes the padding aligning the '=' of the arguments of
+// the list which are written on their own line, see Options.AlignArguments.
+// It is called when entering the first argument of the list, once the list
+// is known to be wrapped.
+func (l *modelicaListener) alignArguments(list antlr.ParserRuleContext) {
+	if list == nil || l.alignedLists[list] {
+		return
+	}
+	l.alignedLists[list] = true
+
+	var terminals []antlr.TerminalNode
+	var widths []int
+	for _, argument := range listArguments(list) {
+		if !l.indentBefore(argument) {
+			continue
+		}
+		if equals := argumentEquals(argument); equals != nil {
+			terminals = append(terminals, equals)
+			widths = append(widths, l.widthBefore(argument, equals))
+		}
+	}
+	if len(terminals) > 1 {
+		l.alignColumn(terminals, widths)
+	}
+}
+
+// alignAssignments computes the padding aligning the ':=' of consecutive
+// assignments of the list of statements which are not separated by a blank
+// line or a comment, see Options.AlignAssignments. It is called when entering
+// the first statement of the list.
+func (l *modelicaListener) alignAssignments(list antlr.ParserRuleContext) {
+	if l.alignedLists[list] {
+		return
+	}
+	l.alignedLists[list] = true
+
+	var previous *parser.StatementContext
+	var terminals []antlr.TerminalNode
+	var widths []int
+	align := func() {
+		l.alignColumn(terminals, widths)
+		terminals, widths = terminals[:0], widths[:0]
+	}
+	for _, child := range list.GetChildren() {
+		statement, ok := child.(*parser.StatementContext)
+		if !ok {
+			continue
+		}
+		assign := assignment(statement)
+		if assign == nil {
+			align()
+			previous = nil
+			continue
+		}
+		if previous != nil && !l.contiguous(previous, statement) {
+			align()
+		}
+		previous = statement
+		terminals = append(terminals, assign)
+		widths = append(widths, l.widthBefore(statement, assign))
+	}
+	align()
+}
+
+// assignment returns the ':=' of a statement such as `x[i] := 1`, or nil if
+// the statement is not one
+func assignment(statement *parser.StatementContext) antlr.TerminalNode {
+	if statement.GetChildCount() < 2 {
+		return nil
+	}
+	if _, ok := statement.GetChild(0).(*parser.Component_referenceContext); !ok {
+		return nil
+	}
+	assign, ok := statement.GetChild(1).(antlr.TerminalNode)
+	if !ok || assign.GetText() != ":=" {
+		return nil
+	}
+	return assign
+}
+
+// listArguments returns the arguments of a modification list or the named
+// arguments of a function call, see wrappableList
+func listArguments(list antlr.ParserRuleContext) []antlr.ParserRuleContext {
+	var arguments []antlr.ParserRuleContext
+	var walk func(tree antlr.Tree)
+	walk = func(tree antlr.Tree) {
+		for _, child := range tree.GetChildren() {
+			switch child := child.(type) {
+			case *parser.ArgumentContext, *parser.Named_argumentContext:
+				arguments = append(arguments, child.(antlr.ParserRuleContext))
+			case *parser.Function_argumentsContext, *parser.Named_argumentsContext:
+				walk(child)
+			}
+		}
+	}
+	walk(list)
+	return arguments
+}
+
+// argumentEquals returns the '=' of an argument such as `k=1`,
+// `redeclare package Medium=Water` or `final k=1`, or nil if there is none.
+// The '=' following a class modification, as in `x(start=1)=2`, is left out,
+// the modification may span several lines.
+func argumentEquals(argument antlr.ParserRuleContext) antlr.TerminalNode {
+	var equals antlr.TerminalNode
+	var walk func(tree antlr.Tree) bool
+	walk = func(tree antlr.Tree) bool {
+		switch tree := tree.(type) {
+		case antlr.TerminalNode:
+			if tree.GetText() == "=" {
+				equals = tree
+				return true
+			}
+			return false
+		case *parser.Class_modificationContext, *parser.ExpressionContext, *parser.Function_argumentContext:
+			return true
+		}
+		for _, child := range tree.GetChildren() {
+			if walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	walk(argument)
+	return equals
+}
+
+// alignedDescription returns the first string of the description of a
+// single line declaration, if written at the end of the line of the
+// declaration fits within Options.MaxLineLength, or else nil
+func (l *modelicaListener) alignedDescription(element *parser.ElementContext) antlr.TerminalNode {
+	declaration := singleLineDeclaration(element)
+	if declaration == nil {
+		return nil
+	}
+	description, ok := declaration.String_comment().(*parser.String_commentContext)
+	if !ok || description.GetChildCount() == 0 {
+		return nil
+	}
+	first := description.GetChild(0).(antlr.TerminalNode)
+	if l.opts.MaxLineLength == 0 {
+		return first
+	}
+
+	width := l.opts.Indent*l.indentation() + l.widthBefore(element, first)
+	descriptionWidth, singleLine := l.flatWidth(description)
+	if !singleLine || width+descriptionWidth+trailingWidth(description) > l.opts.MaxLineLength {
+		return nil
+	}
+	return first
+}
+
+// alignmentPadding returns the number of spaces written before the terminal
+// to align it, see alignElements
+func (l *modelicaListener) alignmentPadding(node antlr.TerminalNode) int {
+	return l.alignments[node]
+}
+
+// trailsDeclaration returns true if the description is kept on the line of
+// its declaration, see Options.AlignDescriptions and
+// Options.AlignDeclarations
+func (l *modelicaListener) trailsDeclaration(description antlr.ParserRuleContext) bool {
+	if description.GetChildCount() == 0 {
+		return false
+	}
+	terminal, ok := description.GetChild(0).(antlr.TerminalNode)
+	if !ok {
+		return false
+	}
+	_, ok = l.alignments[terminal]
+	return ok && (l.opts.AlignDescriptions || l.opts.AlignDeclarations)
+}
+
+// contiguous returns true if the element, or statement, follows the previous
+// one on the next source line, without a comment between them
+func (l *modelicaListener) contiguous(previous, element antlr.ParserRuleContext) bool {
+	if element.GetStart().GetLine()-previous.GetStop().GetLine() > 1 {
+		return false
+	}
+	for _, comment := range l.commentTokens {
+		if comment.GetTokenIndex() > element.GetStart().GetTokenIndex() {
+			break
+		}
+		if comment.GetTokenIndex() > previous.GetStop().GetTokenIndex() {
+			return false
+		}
+	}
+	return true
+}
+
+// widthBefore returns the width of the tokens of the rule preceding the
+// terminal and of the space separating them from it, written on a single line
+// along with the padding aligning them
+func (l *modelicaListener) widthBefore(rule antlr.ParserRuleContext, terminal antlr.TerminalNode) int {
+	width := 0
+	previous, spaceAfterPrevious := "", false
+	done := false
+	var walk func(tree antlr.Tree)
+	walk = func(tree antlr.Tree) {
+		if done {
+			return
+		}
+		if node, ok := tree.(antlr.TerminalNode); ok {
+			text := node.GetText()
+			if previous != "" && l.spaceBetween(previous, spaceAfterPrevious, text, roleOf(node)) {
+				width++
+			}
+			if node == terminal {
+				done = true
+				return
+			}
+			width += l.alignments[node] + utf8.RuneCountInString(text)
+			previous, spaceAfterPrevious = text, l.insertSpaceAfterTerminal(node)
+			return
+		}
+		for _, child := range tree.GetChildren() {
+			walk(child)
+		}
+	}
+	walk(rule)
+	return width
+}
+
+// alignMatrix right-aligns the elements of each column of the matrix with the
+// rows, see Options.AlignMatrices. Matrices whose rows have different lengths
+// or hold comments or elements spanning several lines are left as they are.
+func (l *modelicaListener) alignMatrix(rows []antlr.ParserRuleContext) {
+	if len(rows) < 2 {
+		return
+	}
+	var elements [][]*parser.ExpressionContext
+	for _, row := range rows {
+		elements = append(elements, rowElements(row))
+		if len(elements[len(elements)-1]) != len(elements[0]) || l.commentWithin(row) {
+			return
+		}
+	}
+
+	widths := make([]int, len(rows))
+	for column := range elements[0] {
+		max := 0
+		for i, row := range rows {
+			element := elements[i][column]
+			width, singleLine := l.flatWidth(element)
+			if !singleLine {
+				return
+			}
+			widths[i] = l.widthBefore(row, firstTerminal(element)) + width
+			if widths[i] > max {
+				max = widths[i]
+			}
+		}
+		for i := range rows {
+			l.alignments[firstTerminal(elements[i][column])] = max - widths[i]
+		}
+	}
+}
+
+// matrixRow returns true if the expression is a row of a vector of vectors
+// written one row per line, see Options.AlignMatrices
+func (l *modelicaListener) matrixRow(rule antlr.ParserRuleContext) bool {
+	if !l.opts.AlignMatrices || l.inAnnotation > 0 {
+		return false
+	}
+	vector, ok := rule.GetParent().GetParent().(*parser.VectorContext)
+	return ok && vectorRows(vector) != nil
+}
+
+// matrixRows returns the rows of a matrix such as `[1, 2; 3, 4]`, or nil if
+// the primary is not one
+func matrixRows(primary *parser.PrimaryContext) []antlr.ParserRuleContext {
+	lists := primary.AllExpression_list()
+	if len(lists) < 2 {
+		return nil
+	}
+	var rows []antlr.ParserRuleContext
+	for _, list := range lists {
+		rows = append(rows, list.(*parser.Expression_listContext))
+	}
+	return rows
+}
+
+// vectorRows returns the rows of a vector of vectors such as
+// `{{1, 2}, {3, 4}}`, or nil if the vector is not one
+func vectorRows(vector *parser.VectorContext) []antlr.ParserRuleContext {
+	arguments, ok := vector.Array_arguments().(*parser.Array_argumentsContext)
+	if !ok || len(arguments.AllExpression()) < 2 {
+		return nil
+	}
+	var rows []antlr.ParserRuleContext
+	for _, expression := range arguments.AllExpression() {
+		row := vectorOf(expression)
+		if row == nil || row.Array_arguments() == nil {
+			return nil
+		}
+		rows = append(rows, expression.(*parser.ExpressionContext))
+	}
+	return rows
+}
+
+// rowElements returns the elements of a row of a matrix, see matrixRows and
+// vectorRows
+func rowElements(row antlr.ParserRuleContext) []*parser.ExpressionContext {
+	var expressions []parser.IExpressionContext
+	switch row := row.(type) {
+	case *parser.Expression_listContext:
+		expressions = row.AllExpression()
+	case *parser.ExpressionContext:
+		expressions = vectorOf(row).Array_arguments().(*parser.Array_argumentsContext).AllExpression()
+	}
+	var elements []*parser.ExpressionContext
+	for _, expression := range expressions {
+		elements = append(elements, expression.(*parser.ExpressionContext))
+	}
+	return elements
+}
+
+// vectorOf returns the vector which the expression consists of, or nil if it
+// is not a vector
+func vectorOf(expression antlr.Tree) *parser.VectorContext {
+	for tree := expression; tree.GetChildCount() == 1; tree = tree.GetChild(0) {
+		if vector, ok := tree.GetChild(0).(*parser.VectorContext); ok {
+			return vector
+		}
+	}
+	return nil
+}
+
+// singleDeclaration returns the declaration of a component clause declaring
+// a single component, or nil if the element is not one
+func singleDeclaration(element *parser.ElementContext) *parser.Component_declarationContext {
+	clause, ok := element.Component_clause().(*parser.Component_clauseContext)
+	if !ok || element.Constraining_clause() != nil {
+		return nil
+	}
+	declarations := clause.Component_list().(*parser.Component_listContext).AllComponent_declaration()
+	if len(declarations) != 1 {
+		return nil
+	}
+	return declarations[0].(*parser.Component_declarationContext)
+}
+
+// singleLineDeclaration returns the declaration of a component clause
+// declaring a single component which is written on a single line, or nil if
+// the element is not one. Declarations with modification lists or function
+// call arguments span several lines.
+func singleLineDeclaration(element *parser.ElementContext) *parser.Component_declarationContext {
+	declaration := singleDeclaration(element)
+	if declaration == nil {
+		return nil
+	}
+
+	singleLine := true
+	var walk func(tree antlr.Tree)
+	walk = func(tree antlr.Tree) {
+		switch tree.(type) {
+		case *parser.ArgumentContext, *parser.Named_argumentContext, *parser.Function_argumentContext:
+			singleLine = false
+			return
+		}
+		for _, child := range tree.GetChildren() {
+			walk(child)
+		}
+	}
+	walk(declaration.Declaration())
+	if !singleLine {
+		return nil
+	}
+	return declaration
+}
+
+// parameterDeclaration returns the declaration of a parameter with a binding
+// such as `parameter Real k=1`, or nil if the element is not one.
+// Declarations with a class modification, such as
+// `parameter Real k(unit="1")=1`, are left out as they span several lines.
+func parameterDeclaration(element *parser.ElementContext) *parser.Component_declarationContext {
+	declaration := singleDeclaration(element)
+	if declaration == nil || bindingEquals(declaration) == nil ||
+		!strings.Contains(element.Component_clause().(*parser.Component_clauseContext).Type_prefix().GetText(), "parameter") {
+		return nil
+	}
+	return declaration
+}
+
+// typeName returns the first token of the type of the component
+func typeName(declaration *parser.Component_declarationContext) antlr.TerminalNode {
+	clause := declaration.GetParent().GetParent().(*parser.Component_clauseContext)
+	return firstTerminal(clause.Type_specifier())
+}
+
+// componentName returns the name of the component
+func componentName(declaration *parser.Component_declarationContext) antlr.TerminalNode {
+	return declaration.Declaration().(*parser.DeclarationContext).IDENT()
+}
+
+// bindingEquals returns the '=' of the modification of a declaration such as
+// `Real k=1`, or nil if there is none. The '=' following a class modification
+// is left out, the modification spans several lines.
+func bindingEquals(declaration *parser.Component_declarationContext) antlr.TerminalNode {
+	modification, ok := declaration.Declaration().(*parser.DeclarationContext).Modification().(*parser.ModificationContext)
+	if !ok {
+		return nil
+	}
+	equals, ok := modification.GetChild(0).(antlr.TerminalNode)
+	if !ok || equals.GetText() != "=" {
+		return nil
+	}
+	return equals
+}
+
+// firstTerminal returns the first terminal of the rule
+func firstTerminal(tree antlr.Tree) antlr.TerminalNode {
+	for tree != nil {
+		if terminal, ok := tree.(antlr.TerminalNode); ok {
+			return terminal
+		}
+		if tree.GetChildCount() == 0 {
+			return nil
+		}
+		tree = tree.GetChild(0)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlignParameters(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignParameters = true
+
+	out, err := FormatString(`model A
+  parameter Real k=1 "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5 "Integrator time constant";
+  parameter Real yMax[2]={1, 2};
+  Real x;
+  parameter Real a=1;
+  parameter Integer nSeg=3;
+
+  parameter Real b=2;
+  // comment
+  parameter Boolean use_x=false;
+  parameter Real c(unit="1")=2;
+  parameter Real dd=2;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k                  =1
+    "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5
+    "Integrator time constant";
+  parameter Real yMax[2]            ={1, 2};
+  Real x;
+  parameter Real a      =1;
+  parameter Integer nSeg=3;
+
+  parameter Real b=2;
+  // comment
+  parameter Boolean use_x=false;
+  parameter Real c(
+    unit="1")=2;
+  parameter Real dd=2;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestAlignDescriptions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignParameters = true
+	opts.AlignDescriptions = true
+
+	out, err := FormatString(`model A
+  parameter Real k=1 "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5 "Integrator time constant" annotation (Dialog(group="Integrator"));
+  parameter Real yMax[2]={1, 2};
+  Real x "State";
+  parameter Real c(unit="1")=2 "With modification";
+  Real yy "Output" + " signal";
+
+  Modelica.Blocks.Interfaces.RealInput uuuuuuuuuuuuuuuuuuuuuuuuuuuuuuuuu "Input with a description which is too long to trail";
+  Real z "Z";
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k                  =1   "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5 "Integrator time constant"
+    annotation (Dialog(group="Integrator"));
+  parameter Real yMax[2]            ={1, 2};
+  Real x "State";
+  parameter Real c(
+    unit="1")=2
+    "With modification";
+  Real yy "Output"+" signal";
+
+  Modelica.Blocks.Interfaces.RealInput uuuuuuuuuuuuuuuuuuuuuuuuuuuuuuuuu
+    "Input with a description which is too long to trail";
+  Real z "Z";
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestAlignDeclarations(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignDeclarations = true
+
+	out, err := FormatString(`model A
+  parameter Real k=1 "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5 "Integrator time constant" annotation (Dialog(group="Integrator"));
+  input Real u "Input";
+  Real x[2] "State";
+  output Real y=k*u "Output";
+  Real z(start=0) "Not aligned";
+  Real a "A";
+  Integer n=2;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real                  k =1   "Gain";
+  parameter Modelica.SIunits.Time Ti=0.5 "Integrator time constant"
+    annotation (Dialog(group="Integrator"));
+  input     Real                  u      "Input";
+            Real                  x[2]   "State";
+  output    Real                  y =k*u "Output";
+  Real z(
+    start=0)
+    "Not aligned";
+  Real    a "A";
+  Integer n=2;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestAlignMatrices(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignMatrices = true
+
+	out, err := FormatString(`model A
+  parameter Real table[:,2]=[0,0;10,1000;20,-2];
+  parameter Real B[2,3]={{1,2,3},{40,-5,6.5}};
+  parameter Real v[3]={1,2,3};
+  Real x annotation (Placement(transformation(extent={{-10,-10},{10,10}})));
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real table[:, 2]=[
+     0,    0;
+    10, 1000;
+    20,   -2];
+  parameter Real B[2, 3]={
+    { 1,  2,   3},
+    {40, -5, 6.5}};
+  parameter Real v[3]={1, 2, 3};
+  Real x
+    annotation (Placement(transformation(extent={{-10, -10}, {10, 10}})));
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestAlignArguments(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignArguments = true
+
+	out, err := FormatString(`model A
+  Fan fan(redeclare package Medium = Medium, m_flow_nominal = 1, final dp_nominal = 100, x(start=1)=2);
+equation
+  y=f(first=1, second=2, third=g(a=1, bbb=2));
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  Fan fan(
+    redeclare package Medium=Medium,
+    m_flow_nominal          =1,
+    final dp_nominal        =100,
+    x(
+      start=1)=2);
+
+equation
+  y=f(
+    first =1,
+    second=2,
+    third =g(
+      a  =1,
+      bbb=2));
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestAlignAssignments(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.AlignAssignments = true
+
+	out, err := FormatString(`model A
+algorithm
+  x:=1;
+  someLongName[i]:=2;
+  a:=3;
+
+  c:=5;
+  // comment
+  ddd:=6;
+  if a then
+    y:=1;
+    zzz:=2;
+  end if;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+algorithm
+  x               := 1;
+  someLongName[i] := 2;
+  a               := 3;
+
+  c := 5;
+  // comment
+  ddd := 6;
+  if a then
+    y   := 1;
+    zzz := 2;
+  end if;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
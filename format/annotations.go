@@ -0,0 +1,142 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// dropEmptyAnnotations removes from the tree the annotations without
+// arguments, such as `annotation ()`, and the empty arguments of annotations,
+// such as `Icon()`, see Options.DropEmptyAnnotations. The tree is pruned
+// before it is walked, so that the layout is decided on what is written.
+// Annotations and arguments holding comments are kept.
+func (l *modelicaListener) dropEmptyAnnotations(rule antlr.ParserRuleContext, inAnnotation bool) {
+	if _, ok := rule.(*parser.AnnotationContext); ok {
+		inAnnotation = true
+	}
+	children := rule.GetChildren()
+	for _, child := range children {
+		if child, ok := child.(antlr.ParserRuleContext); ok {
+			l.dropEmptyAnnotations(child, inAnnotation)
+		}
+	}
+
+	drop := make([]bool, len(children))
+	switch rule.(type) {
+	case *parser.Argument_listContext:
+		if !inAnnotation {
+			return
+		}
+		// an argument is dropped with the comma preceding it, or following it
+		// for the first arguments
+		kept := 0
+		for i := 0; i < len(children); i += 2 {
+			if l.emptyArgument(children[i].(*parser.ArgumentContext)) {
+				drop[i] = true
+				if i > 0 && kept > 0 {
+					drop[i-1] = true
+				} else if i+1 < len(children) {
+					drop[i+1] = true
+				}
+				continue
+			}
+			kept++
+		}
+	case *parser.Class_modificationContext:
+		if list, ok := rule.(*parser.Class_modificationContext).Argument_list().(antlr.ParserRuleContext); ok && list.GetChildCount() == 0 {
+			drop[1] = true
+		}
+	default:
+		for i, child := range children {
+			switch child := child.(type) {
+			case *parser.AnnotationContext:
+				drop[i] = l.emptyAnnotation(child)
+			case *parser.Model_annotationContext:
+				// its annotation was dropped, the `;` following it goes too
+				drop[i] = child.GetChildCount() == 0
+				drop[i+1] = drop[i]
+			}
+		}
+	}
+	removeChildren(rule, drop)
+}
+
+// emptyAnnotation returns true if the annotation has no arguments left and
+// holds no comment
+func (l *modelicaListener) emptyAnnotation(annotation *parser.AnnotationContext) bool {
+	return annotation.Class_modification().GetChildCount() == 2 && !l.commentWithin(annotation)
+}
+
+// emptyArgument returns true if the argument of an annotation only modifies a
+// name with empty brackets, such as `Icon()`, and holds no comment
+func (l *modelicaListener) emptyArgument(argument *parser.ArgumentContext) bool {
+	wrapper, ok := argument.Element_modification_or_replaceable().(*parser.Element_modification_or_replaceableContext)
+	if !ok || wrapper.GetChildCount() != 1 {
+		// a redeclaration, or a modification with each or final
+		return false
+	}
+	element, ok := wrapper.Element_modification().(*parser.Element_modificationContext)
+	if !ok || element.String_comment() != nil {
+		return false
+	}
+	modification, ok := element.Modification().(*parser.ModificationContext)
+	if !ok || modification.GetChildCount() != 1 {
+		return false
+	}
+	classModification, ok := modification.Class_modification().(*parser.Class_modificationContext)
+	return ok && classModification.GetChildCount() == 2 && !l.commentWithin(argument)
+}
+
+// removeChildren removes the children of the rule whose drop flag is set
+func removeChildren(rule antlr.ParserRuleContext, drop []bool) {
+	children := rule.GetChildren()
+	n := 0
+	for i, child := range children {
+		if !drop[i] {
+			children[n] = child
+			n++
+		}
+	}
+	for i := n; i < len(children); i++ {
+		rule.RemoveLastChild()
+	}
+}
+
+// annotationVectorComma returns true if the terminal is a comma separating
+// the elements of a vector within an annotation, see
+// Options.SpaceInAnnotationVectors
+func annotationVectorComma(node antlr.TerminalNode) bool {
+	if node.GetText() != "," || terminalRuleIndex(node) != parser.ModelicaParserRULE_array_arguments {
+		return false
+	}
+	for parent := node.GetParent(); parent != nil; parent = parent.GetParent() {
+		if _, ok := parent.(*parser.AnnotationContext); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inPlacement returns true if the rule is within the Placement annotation of
+// a component, such as `transformation(extent={{-120,-10},{-100,10}})`
+func inPlacement(rule antlr.Tree) bool {
+	for node := rule; node != nil; node = node.GetParent() {
+		argument, ok := node.(*parser.ArgumentContext)
+		if !ok {
+			continue
+		}
+		if _, ok := argument.GetParent().GetParent().GetParent().(*parser.AnnotationContext); ok {
+			return argumentName(argument) == "Placement"
+		}
+	}
+	return false
+}
+
+// singleLinePlacement returns true if the list is within a Placement
+// annotation kept on one line, see Options.Placement
+func (l *modelicaListener) singleLinePlacement(list antlr.ParserRuleContext) bool {
+	return l.opts.Placement == placementSingleLine && inPlacement(list)
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// astNode is the JSON representation of a node of the parse tree. Rules have
+// a rule name and children, tokens have a kind and text.
+type astNode struct {
+	Rule     string     `json:"rule,omitempty"`
+	Kind     string     `json:"kind,omitempty"`
+	Text     string     `json:"text,omitempty"`
+	Start    Position   `json:"start"`
+	End      Position   `json:"end"` // position following the last character
+	Children []*astNode `json:"children,omitempty"`
+}
+
+// MarshalJSON encodes the parse tree as nested objects. Rule nodes hold the
+// name of their grammar rule and their children, token nodes the kind and text
+// of their token. Every node holds the source span it covers.
+func (d *StoredDefinition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newASTNode(d.node))
+}
+
+// newASTNode converts a parse tree node and its descendants
+func newASTNode(tree antlr.Tree) *astNode {
+	switch node := tree.(type) {
+	case antlr.TerminalNode:
+		token := node.GetSymbol()
+		start := Position{Line: token.GetLine(), Column: token.GetColumn() + 1}
+		return &astNode{
+			Kind:  tokenKind(token).String(),
+			Text:  token.GetText(),
+			Start: start,
+			End:   advancePosition(start, token.GetText()),
+		}
+	case antlr.ParserRuleContext:
+		result := &astNode{Rule: RuleNode{node}.Name()}
+		for _, child := range node.GetChildren() {
+			result.Children = append(result.Children, newASTNode(child))
+		}
+		if len(result.Children) > 0 {
+			result.Start = result.Children[0].Start
+			result.End = result.Children[len(result.Children)-1].End
+		}
+		return result
+	}
+	return &astNode{}
+}
+
+const (
+	// TreeIndented prints one node per line, children indented below their parent
+	TreeIndented = "indented"
+	// TreeSExpression prints the tree as a single s-expression
+	TreeSExpression = "sexpr"
+)
+
+// WriteTree prints the parse tree in the given style for debugging. Rules are
+// printed by name and tokens as their index in the token stream followed by
+// their quoted text, e.g. [3]"model".
+func (d *StoredDefinition) WriteTree(w io.Writer, style string) {
+	if style == TreeSExpression {
+		fmt.Fprintln(w, sExpression(d.node))
+		return
+	}
+	writeIndentedTree(w, d.node, 0)
+}
+
+// treeLabel returns the label of a parse tree node
+func treeLabel(tree antlr.Tree) string {
+	switch node := tree.(type) {
+	case antlr.TerminalNode:
+		return fmt.Sprintf("[%d]%s", node.GetSymbol().GetTokenIndex(), strconv.Quote(node.GetText()))
+	case antlr.ParserRuleContext:
+		return RuleNode{node}.Name()
+	}
+	return "?"
+}
+
+func writeIndentedTree(w io.Writer, tree antlr.Tree, depth int) {
+	fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", depth), treeLabel(tree))
+	for _, child := range tree.GetChildren() {
+		writeIndentedTree(w, child, depth+1)
+	}
+}
+
+func sExpression(tree antlr.Tree) string {
+	if tree.GetChildCount() == 0 {
+		if _, ok := tree.(antlr.TerminalNode); ok {
+			return treeLabel(tree)
+		}
+		return "(" + treeLabel(tree) + ")"
+	}
+	parts := []string{treeLabel(tree)}
+	for _, child := range tree.GetChildren() {
+		parts = append(parts, sExpression(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
@@ -0,0 +1,44 @@
+package format
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoredDefinitionJSON(t *testing.T) {
+	a := require.New(t)
+	tree, diagnostics := Parse(strings.NewReader("within;\nmodel A end A;"))
+	a.Empty(diagnostics)
+
+	out, err := json.Marshal(tree)
+
+	a.NoError(err)
+	var root astNode
+	a.NoError(json.Unmarshal(out, &root))
+	a.Equal("stored_definition", root.Rule)
+	a.Equal(Position{1, 1}, root.Start)
+	a.Equal(Position{2, 15}, root.End)
+	a.Equal("keyword", root.Children[0].Kind)
+	a.Equal("within", root.Children[0].Text)
+	a.Equal(Position{1, 7}, root.Children[0].End)
+	a.Equal("class_definition", root.Children[2].Rule)
+}
+
+func TestWriteTree(t *testing.T) {
+	a := require.New(t)
+	tree, diagnostics := Parse(strings.NewReader("model A end A;"))
+	a.Empty(diagnostics)
+
+	var out strings.Builder
+	tree.WriteTree(&out, TreeSExpression)
+
+	a.Equal(`(stored_definition (class_definition (class_prefixes [0]"model") (class_specifier (long_class_specifier [2]"A" (composition (element_list)) [4]"end" [6]"A"))) [7]";")`+"\n", out.String())
+
+	out.Reset()
+	tree.WriteTree(&out, TreeIndented)
+
+	a.True(strings.HasPrefix(out.String(), "stored_definition\n  class_definition\n    class_prefixes\n      [0]\"model\"\n"))
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// reflowComment writes the paragraph of line comments starting with the
+// comment re-wrapped to fit within Options.MaxLineLength, and returns true,
+// if one of its lines does not fit, see Options.ReflowComments. The paragraph
+// goes on with the line comments of the following source lines, up to an
+// empty comment such as `//`, which separates paragraphs, or up to a comment
+// which is not prose, such as a banner.
+func (l *modelicaListener) reflowComment(comment antlr.Token) bool {
+	if !l.opts.ReflowComments || l.opts.MaxLineLength == 0 || !l.onNewLine || !proseComment(comment) {
+		return false
+	}
+	paragraph := []antlr.Token{comment}
+	for _, next := range l.commentTokens {
+		last := paragraph[len(paragraph)-1]
+		// only whitespace separates comments on consecutive lines
+		if next.GetTokenIndex() != last.GetTokenIndex()+2 || next.GetLine() != last.GetLine()+1 || !proseComment(next) {
+			break
+		}
+		paragraph = append(paragraph, next)
+	}
+
+	width := l.opts.MaxLineLength - l.indentation()*l.opts.Indent
+	long := false
+	var words []string
+	for _, token := range paragraph {
+		text := trimTrailingSpace(token.GetText())
+		long = long || utf8.RuneCountInString(text) > width
+		words = append(words, strings.Fields(strings.TrimPrefix(text, "//"))...)
+	}
+	if !long {
+		return false
+	}
+	l.commentTokens = l.commentTokens[len(paragraph)-1:]
+
+	l.recordPosition(comment)
+	line := "//"
+	for _, word := range words {
+		if line != "//" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			l.writeCommentLine(comment, line)
+			line = "//"
+		}
+		line += " " + word
+	}
+	l.writeCommentLine(comment, line)
+	l.followSource(paragraph[len(paragraph)-1])
+	return true
+}
+
+// writeCommentLine writes a line of the re-wrapped comment
+func (l *modelicaListener) writeCommentLine(comment antlr.Token, line string) {
+	l.writeSpaceBefore(comment, noOperator)
+	l.writeToken(Comment, line)
+	l.followSource(comment)
+	l.writeNewline()
+}
+
+// proseComment returns true if the token is a line comment whose text starts
+// with a letter or a digit, unlike banners such as `//----`, empty comments
+// and directives
+func proseComment(token antlr.Token) bool {
+	if token.GetTokenType() != parser.ModelicaLexerLINE_COMMENT || directive(token) != "" {
+		return false
+	}
+	text := strings.TrimLeft(strings.TrimPrefix(token.GetText(), "//"), " \t")
+	first, _ := utf8.DecodeRuneInString(text)
+	return unicode.IsLetter(first) || unicode.IsDigit(first)
+}
+
+// reindentBlockComment shifts the lines following the first one of a block
+// comment by shift columns, the distance the comment moves from its source
+// column, so that they keep their place relative to its start. Lines are only
+// shifted left by as many spaces as they start with.
+func reindentBlockComment(text string, shift int) string {
+	if shift == 0 || !strings.Contains(text, "\n") {
+		return text
+	}
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		switch {
+		case lines[i] == "":
+		case shift > 0:
+			lines[i] = strings.Repeat(" ", shift) + lines[i]
+		default:
+			spaces := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+			if spaces > -shift {
+				spaces = -shift
+			}
+			lines[i] = lines[i][spaces:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// recordLines records where each line of the block comment is written, the
+// text of its lines being shifted, see reindentBlockComment
+func (l *modelicaListener) recordLines(comment antlr.Token, text string) {
+	if l.positions == nil || l.muted {
+		return
+	}
+	source := strings.Split(comment.GetText(), "\n")
+	input := Position{Line: comment.GetLine(), Column: comment.GetColumn() + 1}
+	output := l.outputPosition
+	for i, line := range strings.Split(text, "\n") {
+		content := strings.TrimLeft(line, " \t")
+		if i > 0 {
+			input = Position{Line: input.Line + 1, Column: len(source[i]) - len(strings.TrimLeft(source[i], " \t")) + 1}
+			output = Position{Line: output.Line + 1, Column: len(line) - len(content) + 1}
+		}
+		l.positions.add(input, output, content)
+	}
+}
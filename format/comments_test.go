@@ -0,0 +1,211 @@
+package format
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+func TestReflowComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  // This is a very long comment which goes on and on beyond the maximum line length
+  // and continues here.
+  //
+  // Second paragraph stays.
+  //----------------------------------------------------------------------
+  Real x;
+end A;`
+
+	opts := DefaultOptions()
+	opts.ReflowComments = true
+	opts.MaxLineLength = 60
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  // This is a very long comment which goes on and on beyond
+  // the maximum line length and continues here.
+  //
+  // Second paragraph stays.
+  //----------------------------------------------------------------------
+  Real x;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestBlockComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+      /* a comment
+         spanning lines
+      */
+      Real x;
+equation
+/* at the margin
+   continued
+*/
+  x = 1;
+end A;`
+
+	var out strings.Builder
+	positions, err := FormatWithPositions(context.Background(), strings.NewReader(source), &out, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  /* a comment
+     spanning lines
+  */ Real x;
+
+equation
+  /* at the margin
+     continued
+  */ x=1;
+end A;
+`, out.String())
+	a.Equal(Position{3, 6}, positions.OutputPosition(Position{3, 10})) // spanning
+	a.Equal(Position{8, 4}, positions.InputPosition(Position{8, 6}))   // continued
+	a.Equal(Position{4, 3}, positions.OutputPosition(Position{4, 7}))  // */
+	a.Equal(Position{9, 2}, positions.InputPosition(Position{9, 4}))   // */
+}
+
+func TestCommentsBeforeEnd(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Real x;
+equation
+  if true then
+    x = 1;
+// c
+  end if;
+/* d
+   e */
+end M;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model M
+  Real x;
+
+equation
+  if true then
+    x=1;
+    // c
+  end if;
+  /* d
+     e */
+end M;
+`, out)
+}
+
+func TestTrailingComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x;   // first
+  Real y; /* second */ // third
+  Real z; Real w; // last on the line
+equation
+  x = 1; // assignment
+  if x > 0 then
+    y = 1; // in a branch
+  end if;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	expected := `model A
+  Real x; // first
+  Real y; /* second */ // third
+  Real z;
+  Real w; // last on the line
+
+equation
+  x=1; // assignment
+  if x > 0 then
+    y=1; // in a branch
+  end if;
+end A;
+`
+	a.Equal(expected, out)
+
+	var b strings.Builder
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, b.String())
+}
+
+func TestAnnotationComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x annotation (
+    // the dialog
+    Dialog(group="G"));
+equation
+  connect(a, b) annotation (
+    // the line
+    Line(points={{0,0},{1,1}},
+      // color
+      color={0,0,255}));
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x
+    annotation (
+      // the dialog
+      Dialog(group="G"));
+
+equation
+  connect(a, b)
+    annotation (
+      // the line
+      Line(
+        points={{0, 0}, {1, 1}},
+        // color
+        color={0, 0, 255}));
+end A;
+`, out)
+}
+
+func TestFinalComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x;
+end A; // A
+/* license
+   trailer */
+
+// vim: fold
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(source, out)
+
+	var b strings.Builder
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(source, b.String())
+}
+
+func TestCommentTokenTypes(t *testing.T) {
+	a := require.New(t)
+	// comments are recognized by the token types generated with the lexer,
+	// which must still name the comment rules of the grammar
+	lexer := parser.NewModelicaLexer(nil)
+	a.Equal("COMMENT", lexer.SymbolicNames[parser.ModelicaLexerCOMMENT])
+	a.Equal("LINE_COMMENT", lexer.SymbolicNames[parser.ModelicaLexerLINE_COMMENT])
+	a.Equal("WS", lexer.SymbolicNames[parser.ModelicaLexerWS])
+	a.Equal(parser.ModelicaLexerCOMMENT, parser.ModelicaParserCOMMENT, "The lexer and the parser should be generated together")
+	a.Equal(parser.ModelicaLexerLINE_COMMENT, parser.ModelicaParserLINE_COMMENT, "The lexer and the parser should be generated together")
+}
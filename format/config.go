@@ -0,0 +1,221 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultConfigFile is the configuration file used when none is given explicitly
+const DefaultConfigFile = ".modelicafmt.yml"
+
+// Config holds the options read from a configuration file. The options at the
+// top level of the file apply to every file. Profiles override some of those
+// options for the files matching their path patterns, for example:
+//
+//	line-endings: lf
+//	profiles:
+//	  - paths: ["export/**"]
+//	    line-endings: crlf
+//	    encoding: windows-1252
+type Config struct {
+	dir      string // directory which profile path patterns are relative to
+	options  Options
+	profiles []profile
+}
+
+// profile is a set of option overrides applied to the files matching paths
+type profile struct {
+	paths     []string
+	overrides *yaml.Node // mapping of the overridden options
+}
+
+// NewConfig returns a configuration which applies opts to every file
+func NewConfig(opts Options) *Config {
+	return &Config{
+		dir:     ".",
+		options: opts,
+	}
+}
+
+// LoadConfig reads the configuration file at filename. Its options override
+// those of the style preset given by style, or else by its style key.
+func LoadConfig(filename string, style string) (*Config, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Options  `yaml:",inline"`
+		Style    string      `yaml:"style"`
+		Profiles []yaml.Node `yaml:"profiles"`
+	}
+	if file.Options, err = presetOptions(content, style); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := decodeStrict(content, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	cfg := NewConfig(file.Options)
+	cfg.dir = filepath.Dir(filename)
+	for i := range file.Profiles {
+		p, err := newProfile(&file.Profiles[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s: profile %d: %v", filename, i+1, err)
+		}
+		cfg.profiles = append(cfg.profiles, p)
+	}
+	if err := cfg.options.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return cfg, nil
+}
+
+// newProfile splits a profile mapping into its path patterns and its overrides
+func newProfile(node *yaml.Node) (profile, error) {
+	if node.Kind != yaml.MappingNode {
+		return profile{}, fmt.Errorf("line %d: profile must be a mapping", node.Line)
+	}
+
+	p := profile{overrides: &yaml.Node{Kind: yaml.MappingNode}}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "paths" {
+			if err := value.Decode(&p.paths); err != nil {
+				return profile{}, err
+			}
+			continue
+		}
+		p.overrides.Content = append(p.overrides.Content, key, value)
+	}
+	if len(p.paths) == 0 {
+		return profile{}, fmt.Errorf("line %d: profile must have paths", node.Line)
+	}
+
+	// check the overrides are known options with valid values
+	opts := DefaultOptions()
+	if err := p.apply(&opts); err != nil {
+		return profile{}, err
+	}
+
+	return p, opts.validate()
+}
+
+// apply overrides the options with the ones set by the profile
+func (p profile) apply(opts *Options) error {
+	content, err := yaml.Marshal(p.overrides)
+	if err != nil {
+		return err
+	}
+	return decodeStrict(content, opts)
+}
+
+// matches returns true if the slash separated path matches one of the patterns
+func (p profile) matches(name string) bool {
+	for _, pattern := range p.paths {
+		if matchPath(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionsFor returns the options to use when formatting filename
+func (c *Config) OptionsFor(filename string) (Options, error) {
+	opts := c.options
+	name, err := filepath.Rel(c.dir, filename)
+	if err != nil {
+		name = filename
+	}
+	name = filepath.ToSlash(name)
+
+	for _, p := range c.profiles {
+		if !p.matches(name) {
+			continue
+		}
+		if err := p.apply(&opts); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// matchPath matches path segments against pattern segments. Segments are
+// matched with path.Match, except for "**" which matches any number of segments.
+func matchPath(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchPath(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// presetOptions returns the options of the style preset named by style or,
+// if it is empty, by the style key of the YAML content
+func presetOptions(content []byte, style string) (Options, error) {
+	if style == "" {
+		var preset struct {
+			Style string `yaml:"style"`
+		}
+		if err := yaml.Unmarshal(content, &preset); err != nil {
+			return Options{}, err
+		}
+		style = preset.Style
+	}
+	return StyleOptions(style)
+}
+
+// DecodeOptions decodes YAML content holding the options of a configuration
+// file, without profiles, over the style preset it names
+func DecodeOptions(content []byte) (Options, error) {
+	var options struct {
+		Options `yaml:",inline"`
+		Style   string `yaml:"style"`
+	}
+	var err error
+	if options.Options, err = presetOptions(content, ""); err != nil {
+		return Options{}, err
+	}
+	if err := decodeStrict(content, &options); err != nil {
+		return Options{}, err
+	}
+	return options.Options, nil
+}
+
+// decodeStrict decodes YAML content into v, rejecting unknown keys
+func decodeStrict(content []byte, v interface{}) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	err := decoder.Decode(v)
+	if err == io.EOF {
+		// empty document
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,103 @@
+package format
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	filename := filepath.Join(outputDir, DefaultConfigFile)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0644))
+	return filename
+}
+
+func TestConfigProfiles(t *testing.T) {
+	a := require.New(t)
+	filename := writeConfig(t, `
+line-endings: lf
+profiles:
+  - paths: ["export/**"]
+    line-endings: crlf
+    encoding: windows-1252
+`)
+	cfg, err := LoadConfig(filename, "")
+	a.NoError(err)
+	dir := filepath.Dir(filename)
+
+	opts, err := cfg.OptionsFor(filepath.Join(dir, "Package", "Model.mo"))
+	a.NoError(err)
+	a.Equal(DefaultOptions(), opts)
+
+	opts, err = cfg.OptionsFor(filepath.Join(dir, "export", "Package", "Model.mo"))
+	a.NoError(err)
+	a.Equal("crlf", opts.LineEndings)
+	a.Equal("windows-1252", opts.Encoding)
+}
+
+func TestConfigErrors(t *testing.T) {
+	a := require.New(t)
+
+	_, err := LoadConfig(writeConfig(t, "line-endings: cr\n"), "")
+	a.Error(err)
+
+	_, err = LoadConfig(writeConfig(t, "unknown-option: 1\n"), "")
+	a.Error(err)
+
+	_, err = LoadConfig(writeConfig(t, "profiles:\n  - encoding: utf-8\n"), "")
+	a.Error(err, "Profiles without paths should be rejected")
+}
+
+func TestConfigStyle(t *testing.T) {
+	a := require.New(t)
+	filename := writeConfig(t, `
+style: compact
+max-blank-lines: 2
+`)
+	cfg, err := LoadConfig(filename, "")
+	a.NoError(err)
+	expected, err := StyleOptions(StyleCompact)
+	a.NoError(err)
+	expected.MaxBlankLines = 2
+	a.Equal(expected, cfg.options, "Options should override those of the style")
+
+	cfg, err = LoadConfig(filename, StyleExpanded)
+	a.NoError(err)
+	a.True(cfg.options.AlignArguments, "The given style should replace the one of the file")
+	a.Equal(argumentsExpand, cfg.options.Arguments)
+	a.Equal(2, cfg.options.MaxBlankLines)
+
+	_, err = LoadConfig(writeConfig(t, "style: unknown\n"), "")
+	a.Error(err)
+
+	opts, err := DecodeOptions([]byte(`{"style": "msl", "indent": 3}`))
+	a.NoError(err)
+	a.Equal(argumentsCompact, opts.Arguments)
+	a.Equal(3, opts.Indent)
+}
+
+func TestStyleOptions(t *testing.T) {
+	a := require.New(t)
+	for _, style := range []string{"", StyleDefault, StyleMSL, StyleBuildings, StyleCompact, StyleExpanded} {
+		opts, err := StyleOptions(style)
+		a.NoError(err)
+		a.NoError(opts.validate(), style)
+	}
+	opts, err := StyleOptions("")
+	a.NoError(err)
+	a.Equal(DefaultOptions(), opts)
+
+	_, err = StyleOptions("gnu")
+	a.EqualError(err, `unsupported style "gnu"`)
+}
+
+func TestMatchPath(t *testing.T) {
+	a := require.New(t)
+	a.True(matchPath([]string{"export", "**"}, []string{"export", "a", "b.mo"}))
+	a.True(matchPath([]string{"**", "*.mo"}, []string{"b.mo"}))
+	a.True(matchPath([]string{"**", "*.mo"}, []string{"a", "b.mo"}))
+	a.False(matchPath([]string{"export", "*.mo"}, []string{"export", "a", "b.mo"}))
+	a.False(matchPath([]string{"export", "**"}, []string{"other", "b.mo"}))
+}
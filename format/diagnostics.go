@@ -0,0 +1,148 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// Diagnostic describes a problem found while lexing or parsing Modelica source
+type Diagnostic struct {
+	Line    int      // 1-based line of the problem
+	Column  int      // 1-based column of the problem
+	End     Position // position following the offending text, if known
+	Rule    string   // grammar rule being parsed, empty for lexing errors
+	Message string   // description of the problem
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// SyntaxError is returned without writing anything when formatting a source
+// which does not parse
+type SyntaxError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	message := "syntax error at " + e.Diagnostics[0].String()
+	if len(e.Diagnostics) > 1 {
+		message += fmt.Sprintf(" (and %d more)", len(e.Diagnostics)-1)
+	}
+	return message
+}
+
+// diagnosticCollector is an ANTLR error listener which records syntax errors
+// as diagnostics instead of printing them to the console
+type diagnosticCollector struct {
+	*antlr.DefaultErrorListener
+	diagnostics []Diagnostic
+}
+
+func newDiagnosticCollector() *diagnosticCollector {
+	return &diagnosticCollector{
+		DefaultErrorListener: antlr.NewDefaultErrorListener(),
+	}
+}
+
+// SyntaxError records a syntax error reported by the lexer or the parser
+func (c *diagnosticCollector) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
+	start := Position{Line: line, Column: column + 1}
+	diagnostic := Diagnostic{
+		Line:    start.Line,
+		Column:  start.Column,
+		End:     Position{Line: start.Line, Column: start.Column + 1},
+		Message: syntaxErrorMessage(offendingSymbol, msg),
+	}
+	if token, ok := offendingSymbol.(antlr.Token); ok {
+		diagnostic.End = start
+		if token.GetTokenType() != antlr.TokenEOF {
+			diagnostic.End = advancePosition(start, token.GetText())
+		}
+	}
+	if p, ok := recognizer.(antlr.Parser); ok && p.GetParserRuleContext() != nil {
+		diagnostic.Rule = RuleNode{p.GetParserRuleContext()}.Name()
+	}
+	c.diagnostics = append(c.diagnostics, diagnostic)
+}
+
+// sorted returns the diagnostics in the order of the source, keeping the
+// first one reported at each position. The lexer reports its errors when the
+// parser looks ahead, before the errors of the parser on preceding tokens, and
+// the parser may report again a token it failed to recover from.
+func (c *diagnosticCollector) sorted() []Diagnostic {
+	sort.SliceStable(c.diagnostics, func(i, j int) bool {
+		a, b := c.diagnostics[i], c.diagnostics[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	var diagnostics []Diagnostic
+	for _, diagnostic := range c.diagnostics {
+		if n := len(diagnostics); n > 0 && diagnostics[n-1].Line == diagnostic.Line && diagnostics[n-1].Column == diagnostic.Column {
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// errorNodes returns a diagnostic for each error node of the tree, which holds
+// a token the parser skipped or made up to recover from a syntax error. Such
+// a tree misses tokens of the source or holds tokens which are not in it.
+func errorNodes(tree antlr.Tree) []Diagnostic {
+	if node, ok := tree.(antlr.ErrorNode); ok {
+		token := node.GetSymbol()
+		start := Position{Line: token.GetLine(), Column: token.GetColumn() + 1}
+		if token.GetTokenIndex() < 0 {
+			// a made up token, whose text is such as <missing ';'>
+			return []Diagnostic{{Line: start.Line, Column: start.Column, End: start, Message: strings.Trim(token.GetText(), "<>")}}
+		}
+		return []Diagnostic{{
+			Line:    start.Line,
+			Column:  start.Column,
+			End:     advancePosition(start, token.GetText()),
+			Message: fmt.Sprintf("unexpected token '%s'", token.GetText()),
+		}}
+	}
+	var diagnostics []Diagnostic
+	for _, child := range tree.GetChildren() {
+		diagnostics = append(diagnostics, errorNodes(child)...)
+	}
+	return diagnostics
+}
+
+// syntaxErrorMessage rewords the message of an ANTLR syntax error in terms of
+// the offending token, such as `unexpected token 'equation'` for the
+// `mismatched input 'equation' expecting ...` reported by the parser
+func syntaxErrorMessage(offendingSymbol interface{}, msg string) string {
+	if strings.HasPrefix(msg, "token recognition error at: ") {
+		return "unexpected character " + strings.TrimPrefix(msg, "token recognition error at: ")
+	}
+	token, ok := offendingSymbol.(antlr.Token)
+	if !ok || strings.HasPrefix(msg, "missing ") {
+		return msg
+	}
+	message := fmt.Sprintf("unexpected token '%s'", token.GetText())
+	if token.GetTokenType() == antlr.TokenEOF {
+		message = "unexpected end of file"
+	}
+	// sets of expected tokens are too long to help
+	if i := strings.Index(msg, " expecting "); i >= 0 && !strings.Contains(msg[i:], "{") {
+		message += "," + msg[i:]
+	}
+	return message
+}
+
+// Validate lexes and parses the Modelica source read from r without formatting
+// it, returning every syntax error found. The source is valid if no diagnostics
+// are returned.
+func Validate(r io.Reader) []Diagnostic {
+	_, diagnostics := Parse(r)
+	return diagnostics
+}
@@ -0,0 +1,104 @@
+package format
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	a := require.New(t)
+
+	diagnostics := Validate(strings.NewReader("model A\n  Real x;\nend A;\n"))
+	a.Empty(diagnostics)
+
+	diagnostics = Validate(strings.NewReader("model A\n  Real x\nequation\n  x = 1;\nend A;\n"))
+	a.Len(diagnostics, 1)
+	a.Equal(3, diagnostics[0].Line)
+	a.Equal(1, diagnostics[0].Column)
+	a.Equal("3:1: missing ';' at 'equation'", diagnostics[0].String())
+}
+
+func TestSyntaxErrorMessages(t *testing.T) {
+	a := require.New(t)
+	messages := func(source string) []string {
+		var messages []string
+		for _, diagnostic := range Validate(strings.NewReader(source)) {
+			messages = append(messages, diagnostic.String())
+		}
+		return messages
+	}
+	a.Equal([]string{
+		"2:12: unexpected character '$'",
+		"4:9: unexpected token '1', expecting ';'",
+	}, messages("model A\n  Real x = $1;\nequation\n  x = 1 1;\nend A;\n"))
+	a.Equal([]string{"2:12: unexpected token ')'"}, messages("model A\n  Real x = );\nend A;\n"))
+	a.Equal([]string{"3:1: unexpected end of file, expecting 'end'"}, messages("model A\n  Real x;\n"))
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	a := require.New(t)
+	var b strings.Builder
+	err := Format(context.Background(), strings.NewReader("model A\n  Real x\nequation\n  x = 1 1;\nend A;\n"), &b, DefaultOptions())
+	a.IsType(&SyntaxError{}, err)
+	a.EqualError(err, "syntax error at 3:1: missing ';' at 'equation' (and 1 more)")
+	a.Empty(b.String(), "Nothing should be written")
+
+	err = FormatStream(context.Background(), strings.NewReader("model A\n  Real x\nend A;\n"), &b, DefaultOptions())
+	a.IsType(&SyntaxError{}, err)
+	a.EqualError(err, "syntax error at 3:1: missing ';' at 'end'")
+}
+
+func TestAllSyntaxErrors(t *testing.T) {
+	a := require.New(t)
+	source := "model A\n  Real x\n  Real y = $1;\nequation\n  x = 1 1;\nend A;\n"
+	expected := []Diagnostic{
+		{Line: 3, Column: 3, End: Position{3, 7}, Rule: "element_list", Message: "missing ';' at 'Real'"},
+		{Line: 3, Column: 12, End: Position{3, 13}, Message: "unexpected character '$'"},
+		{Line: 5, Column: 9, End: Position{5, 10}, Rule: "equations", Message: "unexpected token '1', expecting ';'"},
+	}
+	a.Equal(expected, Validate(strings.NewReader(source)), "Errors should be sorted by position")
+
+	err := Format(context.Background(), strings.NewReader(source), ioutil.Discard, DefaultOptions())
+	a.Equal(&SyntaxError{Diagnostics: expected}, err)
+
+	err = FormatStream(context.Background(), strings.NewReader(source), ioutil.Discard, DefaultOptions())
+	a.Equal(&SyntaxError{Diagnostics: expected}, err, "Statements following an error should be parsed")
+}
+
+func TestSyntaxErrorsPassthrough(t *testing.T) {
+	a := require.New(t)
+	source := "model A\r\n  Real  x\r\nequation\r\n  x = 1;\r\nend A;"
+	opts := DefaultOptions()
+	opts.SyntaxErrors = SyntaxErrorsPassthrough
+
+	var b strings.Builder
+	err := Format(context.Background(), strings.NewReader(source), &b, opts)
+	a.IsType(&SyntaxError{}, err)
+	a.Equal(source, b.String(), "The source should be written unchanged")
+
+	b.Reset()
+	err = Format(context.Background(), strings.NewReader("// modelica-fmt: syntax-errors=passthrough\n"+source), &b, DefaultOptions())
+	a.IsType(&SyntaxError{}, err)
+	a.NotEmpty(b.String(), "The option should be set by a directive")
+
+	b.Reset()
+	err = Format(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.IsType(&SyntaxError{}, err)
+	a.Empty(b.String())
+}
+
+func TestErrorNodes(t *testing.T) {
+	a := require.New(t)
+	sd, _ := Parse(strings.NewReader("model A\n  Real x\n  Real y;\nequation\n  x = 1 1;\nend A;\n"))
+	a.Equal([]Diagnostic{
+		{Line: 3, Column: 3, End: Position{3, 3}, Message: "missing ';'"},
+		{Line: 5, Column: 9, End: Position{5, 10}, Message: "unexpected token '1'"},
+	}, errorNodes(sd.node))
+
+	sd, _ = Parse(strings.NewReader("model A\n  Real x;\nend A;\n"))
+	a.Empty(errorNodes(sd.node))
+}
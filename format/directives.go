@@ -0,0 +1,277 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// directivePrefix starts the line comments holding a directive to the
+// formatter, such as `// modelica-fmt: off`
+const directivePrefix = "modelica-fmt:"
+
+const (
+	// directiveOff starts a region of the source which is copied as it is
+	directiveOff = "off"
+	// directiveOn ends a region started by directiveOff
+	directiveOn = "on"
+	// directiveIgnore precedes a declaration, an equation or a statement
+	// which is copied as it is
+	directiveIgnore = "ignore"
+	// directiveSkipFile precedes the code of a file which is left as it is
+	directiveSkipFile = "skip-file"
+)
+
+// ErrSkipped is returned without writing anything when formatting a source
+// whose code is preceded by a `// modelica-fmt: skip-file` comment, such as a
+// model which must stay identical to the output of its generator
+var ErrSkipped = errors.New("skipped by a modelica-fmt: skip-file directive")
+
+// directive returns the directive held by the comment, e.g. "off" for
+// `// modelica-fmt: off`, or "" if it holds none
+func directive(comment antlr.Token) string {
+	if comment.GetTokenType() != parser.ModelicaLexerLINE_COMMENT {
+		return ""
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(comment.GetText(), "//"))
+	if !strings.HasPrefix(text, directivePrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(text, directivePrefix))
+}
+
+// codeStart returns the index of the token which the directives of a file
+// precede, the first one of its first class definition, so that they may
+// follow a within clause
+func codeStart(sd antlr.ParserRuleContext) int {
+	for _, child := range sd.GetChildren() {
+		if class, ok := child.(*parser.Class_definitionContext); ok {
+			return class.GetStart().GetTokenIndex()
+		}
+	}
+	if sd.GetStop() != nil {
+		return sd.GetStop().GetTokenIndex()
+	}
+	return sd.GetStart().GetTokenIndex()
+}
+
+// leadingComments returns the comments preceding the token at start
+func leadingComments(comments []antlr.Token, start int) []antlr.Token {
+	for i, comment := range comments {
+		if comment.GetTokenIndex() > start {
+			return comments[:i]
+		}
+	}
+	return comments
+}
+
+// skipFile returns true if a `// modelica-fmt: skip-file` comment is among the
+// comments preceding the code of a file, see codeStart
+func skipFile(comments []antlr.Token) bool {
+	for _, comment := range comments {
+		if directive(comment) == directiveSkipFile {
+			return true
+		}
+	}
+	return false
+}
+
+// fileOptions returns opts overridden by the directives of the comments
+// preceding the code of a file, see codeStart, such as
+// `// modelica-fmt: indent=4 max-line-length=120`. The keys are those of the
+// configuration file, and the values may not hold spaces.
+func fileOptions(opts Options, comments []antlr.Token) (Options, error) {
+	overridden := false
+	for _, comment := range comments {
+		text := directive(comment)
+		if !strings.Contains(text, "=") {
+			continue
+		}
+		var overrides []string
+		for _, pair := range strings.Fields(text) {
+			i := strings.Index(pair, "=")
+			if i <= 0 {
+				return opts, fmt.Errorf("line %d: expected key=value in directive, got %q", comment.GetLine(), pair)
+			}
+			overrides = append(overrides, pair[:i]+": "+pair[i+1:])
+		}
+		if err := decodeStrict([]byte(strings.Join(overrides, "\n")), &opts); err != nil {
+			return opts, fmt.Errorf("line %d: invalid directive: %v", comment.GetLine(), err)
+		}
+		overridden = true
+	}
+	if !overridden {
+		return opts, nil
+	}
+	return opts, opts.validate()
+}
+
+// offRegion returns true if the last directive among the tokens starts a
+// region copied as it is, which has yet to be ended
+func offRegion(tokens []antlr.Token) bool {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if isComment(tokens[i]) {
+			switch directive(tokens[i]) {
+			case directiveOff:
+				return true
+			case directiveOn:
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// writeDirective acts on the directive held by the comment just written
+func (l *modelicaListener) writeDirective(comment antlr.Token) {
+	switch directive(comment) {
+	case directiveOff:
+		l.writeRegion(comment, l.offStop(comment))
+	case directiveIgnore:
+		if l.ignoreStop > comment.GetTokenIndex() {
+			l.writeRegion(comment, l.ignoreStop)
+		}
+	}
+}
+
+// offStop returns the index of the last token of the region started by the
+// `// modelica-fmt: off` comment, which is the token preceding the matching
+// `// modelica-fmt: on` comment or the last token of the source
+func (l *modelicaListener) offStop(off antlr.Token) int {
+	stop := l.tokens.Size() - 1
+	if l.tokens.Get(stop).GetTokenType() == antlr.TokenEOF {
+		stop--
+	}
+	if stop > l.emitRange.Stop {
+		// the end clauses closing a statement, see FormatStream
+		stop = l.emitRange.Stop
+	}
+	for _, comment := range l.commentTokens {
+		if comment.GetTokenIndex() > off.GetTokenIndex() && directive(comment) == directiveOn {
+			return comment.GetTokenIndex() - 1
+		}
+	}
+	return stop
+}
+
+// ignoreStatement prepares the declaration, equation or statement to be
+// copied as it is if a `// modelica-fmt: ignore` comment directly precedes
+// it. The region written then goes from the comment to the `;` ending the
+// statement and the comments following it on its line.
+func (l *modelicaListener) ignoreStatement(rule antlr.ParserRuleContext) {
+	var last antlr.Token
+	for _, comment := range l.commentTokens {
+		if comment.GetTokenIndex() > rule.GetStart().GetTokenIndex() {
+			break
+		}
+		last = comment
+	}
+	if last == nil || directive(last) != directiveIgnore {
+		return
+	}
+
+	stop := rule.GetStop().GetTokenIndex()
+	for i := stop + 1; i < l.tokens.Size(); i++ {
+		if token := l.tokens.Get(i); token.GetChannel() == antlr.TokenDefaultChannel {
+			if token.GetText() == ";" {
+				stop = i
+			}
+			break
+		}
+	}
+	line := l.tokens.Get(stop).GetLine()
+	for i := stop + 1; i < l.tokens.Size(); i++ {
+		token := l.tokens.Get(i)
+		if token.GetChannel() == antlr.TokenDefaultChannel || token.GetLine() != line {
+			break
+		}
+		if isComment(token) {
+			stop = i
+		}
+	}
+	l.ignoreStop = stop
+}
+
+// writeRegion writes the source following the directive comment just written
+// as it is, up to the token at stop, such as the one preceding a
+// `// modelica-fmt: on` comment, which is then written as any other comment.
+// The tokens and comments of the region are skipped while walking, see
+// inRegion.
+func (l *modelicaListener) writeRegion(off antlr.Token, stop int) {
+	// the newline ending the line of the `off` comment and the whitespace
+	// preceding the `on` comment are written by the formatter
+	start, last := off.GetTokenIndex()+1, stop
+	for last >= start && l.tokens.Get(last).GetTokenType() == parser.ModelicaLexerWS {
+		last--
+	}
+	if start <= last && l.tokens.Get(start).GetTokenType() == parser.ModelicaLexerWS {
+		text := l.tokens.Get(start).GetText()
+		l.writeWhitespace(text[strings.Index(text, "\n")+1:])
+		start++
+	}
+	for i := start; i <= last; i++ {
+		token := l.tokens.Get(i)
+		if token.GetTokenType() == parser.ModelicaLexerWS {
+			l.writeWhitespace(token.GetText())
+			continue
+		}
+		l.recordPosition(token)
+		l.writeToken(tokenKind(token), token.GetText())
+		l.onNewLine = false
+	}
+	if start <= last {
+		token := l.tokens.Get(last)
+		l.followSource(token)
+		l.previousTokenText = token.GetText()
+		l.previousTokenIdx = last
+		l.spaceAfterPrevious = false
+		l.writeNewline()
+	}
+
+	for len(l.commentTokens) > 0 && l.commentTokens[0].GetTokenIndex() <= stop {
+		l.commentTokens = l.commentTokens[1:]
+	}
+	// the rules starting at the first token of the region on the default
+	// channel may have been entered already
+	l.region = antlr.Interval{Start: stop + 1, Stop: stop}
+	for i := off.GetTokenIndex() + 1; i <= stop; i++ {
+		if l.tokens.Get(i).GetChannel() == antlr.TokenDefaultChannel {
+			l.region.Start = i
+			break
+		}
+	}
+}
+
+// inRegion returns true if the token at tokenIdx was written by writeRegion
+func (l *modelicaListener) inRegion(tokenIdx int) bool {
+	return tokenIdx >= l.region.Start && tokenIdx <= l.region.Stop
+}
+
+// regionRule returns true if the rule is within a region written by
+// writeRegion and starts after its first token, so that it is neither entered
+// nor exited
+func (l *modelicaListener) regionRule(rule antlr.ParserRuleContext) bool {
+	return rule.GetStart().GetTokenIndex() > l.region.Start && rule.GetStop().GetTokenIndex() <= l.region.Stop
+}
+
+// writeWhitespace writes the whitespace separating the tokens of a region
+// copied as it is, without the spaces ending its lines
+func (l *modelicaListener) writeWhitespace(text string) {
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		if i > 0 {
+			l.writeNewline()
+		}
+		if i == strings.Count(text, "\n") && line != "" && !l.muted {
+			// the indentation of the next token, or the spaces separating it
+			l.renderer.Indent(line)
+			l.outputPosition = advancePosition(l.outputPosition, line)
+			l.onNewLine = false
+		}
+	}
+}
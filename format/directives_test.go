@@ -0,0 +1,148 @@
+package format
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffDirective(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real   x;
+  // modelica-fmt: off
+  parameter Real table[3, 2] = [0,   1;
+                                10,  2;
+                                100, 3];
+  //   a --> b
+  // modelica-fmt: on
+  Real   y;
+equation
+  x = 1;
+  //modelica-fmt: off
+  y   =   2 *   x;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	expected := `model A
+  Real x;
+  // modelica-fmt: off
+  parameter Real table[3, 2] = [0,   1;
+                                10,  2;
+                                100, 3];
+  //   a --> b
+  // modelica-fmt: on
+  Real y;
+
+equation
+  x=1;
+  // modelica-fmt: off
+  y   =   2 *   x;
+end A;
+`
+	a.Equal(expected, out)
+
+	var b strings.Builder
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, b.String())
+}
+
+func TestIgnoreDirective(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  // modelica-fmt: ignore
+  parameter Real k[3] = {1,  10,   100};   // aligned
+  Real   x;
+algorithm
+  // modelica-fmt: ignore
+  x  :=  3;
+  x  :=  4;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	expected := `model A
+  // modelica-fmt: ignore
+  parameter Real k[3] = {1,  10,   100};   // aligned
+  Real x;
+
+algorithm
+  // modelica-fmt: ignore
+  x  :=  3;
+  x := 4;
+end A;
+`
+	a.Equal(expected, out)
+
+	var b strings.Builder
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, b.String())
+}
+
+func TestSkipFile(t *testing.T) {
+	a := require.New(t)
+	source := "// generated\r\n// modelica-fmt: skip-file\r\nmodel   A\r\nend A;"
+
+	var b strings.Builder
+	err := Format(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.Equal(ErrSkipped, err)
+	a.Empty(b.String())
+
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.Equal(ErrSkipped, err)
+	a.Empty(b.String())
+
+	// after the within clause
+	source = "within P;\n// modelica-fmt: skip-file\nmodel   A\nend A;"
+	b.Reset()
+	err = Format(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.Equal(ErrSkipped, err)
+	a.Empty(b.String())
+
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.Equal(ErrSkipped, err)
+	a.Empty(b.String())
+
+	// only before the code
+	out, err := FormatString("model A\n  // modelica-fmt: skip-file\n  Real   x;\nend A;", DefaultOptions())
+	a.NoError(err)
+	a.Equal("model A\n  // modelica-fmt: skip-file\n  Real x;\nend A;\n", out)
+}
+
+func TestFileOptions(t *testing.T) {
+	a := require.New(t)
+	source := "// modelica-fmt: indent=4 line-endings=crlf\nmodel A\nReal x;\nend A;\n"
+	expected := "// modelica-fmt: indent=4 line-endings=crlf\r\nmodel A\r\n    Real x;\r\nend A;\r\n"
+
+	out, err := FormatString(source, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, out)
+
+	var b strings.Builder
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, b.String())
+
+	source = "within P;\n// modelica-fmt: indent=4\nmodel A\nReal x;\nend A;\n"
+	expected = "within P;\n\n// modelica-fmt: indent=4\nmodel A\n    Real x;\nend A;\n"
+	out, err = FormatString(source, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, out, "Directives should follow the within clause")
+
+	b.Reset()
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(expected, b.String())
+
+	_, err = FormatString("// modelica-fmt: indent=-1\nmodel A\nend A;\n", DefaultOptions())
+	a.Error(err)
+	_, err = FormatString("// modelica-fmt: unknown=1\nmodel A\nend A;\n", DefaultOptions())
+	a.EqualError(err, "line 1: invalid directive: yaml: unmarshal errors:\n  line 1: field unknown not found in type format.Options")
+}
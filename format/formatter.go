@@ -0,0 +1,178 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// Formatter formats Modelica source with a fixed set of options. It reuses its
+// listeners and buffers from one call to the next, which makes it suitable for
+// long running processes. A Formatter is safe for concurrent use by multiple
+// goroutines, provided the rules of its options are.
+type Formatter struct {
+	opts      Options
+	listeners sync.Pool // *modelicaListener
+	sources   sync.Pool // *bytes.Buffer holding the source being formatted
+	writers   sync.Pool // *bufio.Writer buffering the output
+}
+
+// NewFormatter returns a formatter using opts, or an error if an option is
+// invalid
+func NewFormatter(opts Options) (*Formatter, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	return &Formatter{opts: opts}, nil
+}
+
+// Format formats the Modelica source read from in and writes the result to
+// out, see the Format function
+func (f *Formatter) Format(ctx context.Context, in io.Reader, out io.Writer) error {
+	decoder := newInputDecoder(in)
+	renderer, output := f.textRenderer(ctx, out, decoder)
+	defer f.releaseTextRenderer(renderer)
+	return f.format(ctx, decoder, renderer, output, nil)
+}
+
+// Render formats the Modelica source read from in and passes the result to
+// renderer, see the Render function
+func (f *Formatter) Render(ctx context.Context, in io.Reader, renderer Renderer) error {
+	return f.format(ctx, newInputDecoder(in), renderer, nil, nil)
+}
+
+// FormatWithPositions formats like Format and also returns a map between the
+// positions of the source and the positions of the formatted output
+func (f *Formatter) FormatWithPositions(ctx context.Context, in io.Reader, out io.Writer) (*PositionMap, error) {
+	decoder := newInputDecoder(in)
+	renderer, output := f.textRenderer(ctx, out, decoder)
+	defer f.releaseTextRenderer(renderer)
+
+	positions := &PositionMap{}
+	if err := f.format(ctx, decoder, renderer, output, positions); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+// FormatString formats Modelica source held in a string
+func (f *Formatter) FormatString(src string) (string, error) {
+	var b strings.Builder
+	err := f.Format(context.Background(), strings.NewReader(src), &b)
+	return b.String(), err
+}
+
+// FormatBytes formats Modelica source held in a byte slice
+func (f *Formatter) FormatBytes(src []byte) ([]byte, error) {
+	var b bytes.Buffer
+	err := f.Format(context.Background(), bytes.NewReader(src), &b)
+	return b.Bytes(), err
+}
+
+// textRenderer returns a renderer writing the formatted source to out
+// according to the options, using a pooled buffer, along with the writer it
+// writes through. Automatic line endings are the ones detected in the source
+// read through decoder.
+func (f *Formatter) textRenderer(ctx context.Context, out io.Writer, decoder *inputDecoder) (*textRenderer, *outputWriter) {
+	writer, _ := f.writers.Get().(*bufio.Writer)
+	if writer == nil {
+		writer = bufio.NewWriter(nil)
+	}
+	output := newOutputWriter(contextWriter{ctx, out}, f.opts, decoder)
+	writer.Reset(output)
+	return &textRenderer{writer}, output
+}
+
+// releaseTextRenderer returns the buffer of a renderer to the pool
+func (f *Formatter) releaseTextRenderer(renderer *textRenderer) {
+	renderer.writer.Reset(nil)
+	f.writers.Put(renderer.writer)
+}
+
+// format implements Format and Render, recording the positions of the written
+// tokens in positions unless it is nil. The output options overridden by the
+// source, see fileOptions, are applied to output unless it is nil.
+func (f *Formatter) format(ctx context.Context, in io.Reader, renderer Renderer, output *outputWriter, positions *PositionMap) (err error) {
+	var position Position
+	defer recoverPanic(&err, &position)
+
+	checkCanceled(ctx)
+	source, _ := f.sources.Get().(*bytes.Buffer)
+	if source == nil {
+		source = &bytes.Buffer{}
+	}
+	defer f.sources.Put(source)
+	source.Reset()
+	if _, err := source.ReadFrom(in); err != nil {
+		return err
+	}
+
+	dfas := getAutomata()
+	defer automataPool.Put(dfas)
+	collector := newDiagnosticCollector()
+	p, tokenSource := newParser(ctx, source.String(), collector, dfas)
+	sd := p.Stored_definition()
+	directives := leadingComments(tokenSource.commentTokens, codeStart(sd))
+	if skipFile(directives) {
+		return ErrSkipped
+	}
+	opts, err := fileOptions(f.opts, directives)
+	if err != nil {
+		return err
+	}
+	diagnostics := collector.sorted()
+	if len(diagnostics) == 0 {
+		diagnostics = errorNodes(sd)
+	}
+	var syntaxErr error
+	if len(diagnostics) > 0 {
+		// the tree recovered from the errors would be written without the
+		// tokens the parser skipped, or with those it made up
+		syntaxErr = &SyntaxError{Diagnostics: diagnostics}
+		if opts.SyntaxErrors == SyntaxErrorsPassthrough && output != nil {
+			if _, err := output.out.Write(output.input.original(source.Bytes())); err != nil {
+				return err
+			}
+		}
+		if opts.SyntaxErrors != SyntaxErrorsPartial {
+			return syntaxErr
+		}
+	}
+	if output != nil {
+		output.configure(opts)
+	}
+
+	listener, _ := f.listeners.Get().(*modelicaListener)
+	if listener == nil {
+		listener = newListener(ctx, renderer, tokenSource.commentTokens, opts)
+	} else {
+		listener.reset(ctx, renderer, tokenSource.commentTokens, opts)
+	}
+	defer func() {
+		// drop the references to this call's tree and output
+		listener.reset(nil, nil, nil, Options{})
+		f.listeners.Put(listener)
+	}()
+	listener.positions = positions
+	listener.sourcePosition = &position
+	listener.indents = scanIndents(source.String())
+	if syntaxErr != nil {
+		listener.broken = brokenRules(sd, diagnostics)
+	}
+
+	antlr.ParseTreeWalkerDefault.Walk(listener, sd)
+	listener.finish()
+
+	if err := renderer.Flush(); err != nil {
+		return err
+	}
+	return syntaxErr
+}
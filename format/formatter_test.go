@@ -0,0 +1,45 @@
+package format
+
+import (
+	"io/ioutil"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatterConcurrent(t *testing.T) {
+	a := require.New(t)
+	f, err := NewFormatter(DefaultOptions())
+	a.NoError(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		for _, testCase := range exampleFileTests {
+			source, err := ioutil.ReadFile(path.Join("..", "examples", testCase.sourceFile))
+			a.NoError(err)
+			expected, err := ioutil.ReadFile(path.Join("..", "examples", testCase.outFile))
+			a.NoError(err)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				out, err := f.FormatBytes(source)
+				a.NoError(err)
+				a.Equal(string(expected), string(out))
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+func TestNewFormatterInvalidOptions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Encoding = "ebcdic"
+
+	_, err := NewFormatter(opts)
+
+	a.Error(err)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// sortImports sorts the import clauses of the list alphabetically within each
+// group of contiguous imports, see Options.SortImports. The elements are
+// reordered in the parse tree before they are walked, and the blank lines
+// before each one are counted from the line of the element it replaces, see
+// enterImport.
+func (l *modelicaListener) sortImports(list *parser.Element_listContext) {
+	children := list.GetChildren()
+	var slots []int // indexes of the children of the current group
+	var previous *parser.ElementContext
+	sortGroup := func() {
+		if len(slots) > 1 {
+			group := make([]*parser.ElementContext, len(slots))
+			for i, slot := range slots {
+				group[i] = children[slot].(*parser.ElementContext)
+			}
+			sorted := append([]*parser.ElementContext{}, group...)
+			sort.SliceStable(sorted, func(i, j int) bool {
+				return strings.ToLower(sorted[i].GetText()) < strings.ToLower(sorted[j].GetText())
+			})
+			for i, slot := range slots {
+				children[slot] = sorted[i]
+				l.importSlots[sorted[i]] = group[i]
+			}
+		}
+		slots = slots[:0]
+		previous = nil
+	}
+
+	for i, child := range children {
+		element, ok := child.(*parser.ElementContext)
+		if !ok {
+			continue
+		}
+		if element.Import_clause() == nil || previous != nil && !l.contiguous(previous, element) {
+			sortGroup()
+		}
+		if element.Import_clause() == nil {
+			continue
+		}
+		if l.trailingComment(element) {
+			// the import stays in place with its comment
+			sortGroup()
+			continue
+		}
+		slots = append(slots, i)
+		previous = element
+	}
+	sortGroup()
+}
+
+// trailingComment returns true if a comment follows the element on its last
+// line
+func (l *modelicaListener) trailingComment(element antlr.ParserRuleContext) bool {
+	stop := element.GetStop()
+	for _, comment := range l.commentTokens {
+		if comment.GetTokenIndex() > stop.GetTokenIndex() {
+			return comment.GetLine() == stop.GetLine()
+		}
+	}
+	return false
+}
+
+// enterImport shifts the line of the first token of a sorted import to that
+// of the element it replaces, so that the blank lines before it are those
+// before that element. The `;` following the import is at its place already.
+func (l *modelicaListener) enterImport(element *parser.ElementContext) {
+	if slot, ok := l.importSlots[element]; ok {
+		l.importShift = element.GetStart().GetLine() - slot.GetStart().GetLine()
+	}
+}
+
+// continuesName returns true if the terminal is the `.*` or `.{` following
+// the name of an import clause, which is written against it
+func continuesName(node antlr.TerminalNode) bool {
+	text := node.GetText()
+	return (text == ".*" || text == ".{") && terminalRuleIndex(node) == parser.ModelicaParserRULE_import_clause
+}
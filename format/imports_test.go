@@ -0,0 +1,71 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImports(t *testing.T) {
+	a := require.New(t)
+	source := `package P
+  // imports
+  import D;
+  import SI  =  Modelica.Units.SI "units"
+    annotation (x=1);
+  import   A.B.{c, d};
+  import C  .*;
+
+
+  import Y; // last
+  import X;
+  Real x;
+  import K;
+  import J;
+end P;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`package P
+  // imports
+  import D;
+  import SI=Modelica.Units.SI
+    "units"
+    annotation (x=1);
+  import A.B.{c, d};
+  import C.*;
+
+
+  import Y; // last
+  import X;
+  Real x;
+  import K;
+  import J;
+end P;
+`, out)
+
+	opts := DefaultOptions()
+	opts.SortImports = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`package P
+  // imports
+  import A.B.{c, d};
+  import C.*;
+  import D;
+  import SI=Modelica.Units.SI
+    "units"
+    annotation (x=1);
+
+
+  import Y; // last
+  import X;
+  Real x;
+  import J;
+  import K;
+end P;
+`, out)
+}
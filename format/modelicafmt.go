@@ -0,0 +1,2496 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+// Package format formats Modelica source code and gives access to its parse
+// tree
+package format
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// insertIndentBefore returns true if the rule should be on a new line and indented
+func (l *modelicaListener) insertIndentBefore(rule antlr.ParserRuleContext) bool {
+	switch rule.(type) {
+	case
+		parser.IElementContext,
+		parser.IEquationsContext,
+		parser.IAlgorithm_statementsContext,
+		parser.IControl_structure_bodyContext,
+		parser.IExpression_listContext:
+		return true
+	case parser.IAnnotationContext:
+		return l.annotationOnOwnLine(rule)
+	case parser.IIf_expressionContext, parser.IIf_expression_bodyContext:
+		return !l.compactIfExpression(rule)
+	case parser.IConstraining_clauseContext:
+		return l.breakBeforeConstrainingClause(rule.(*parser.Constraining_clauseContext))
+	case
+		parser.IComponent_clauseContext,
+		parser.IComponent_clause1Context,
+		parser.IClass_definitionContext,
+		parser.IShort_class_definitionContext:
+		return l.breakAfterPrefixes(rule)
+	case parser.IComponent_declarationContext:
+		return !l.splitDeclarations(rule.GetParent()) && breakComponentList(rule)
+	case parser.IString_commentContext:
+		return 0 == l.inAnnotation && !l.trailsDeclaration(rule) && !describesLiteral(rule) && !l.trailingDescription(rule)
+	case parser.IEnumeration_literalContext:
+		return l.wrapList(rule)
+	case parser.ICondition_attributeContext:
+		return l.breakBeforeCondition(rule)
+	case parser.IArgumentContext:
+		if l.commentedArgument(rule.(*parser.ArgumentContext)) {
+			return true
+		}
+		switch annotationArgumentName(rule) {
+		case "experiment":
+			return l.opts.Experiment == experimentExpand
+		case "Documentation":
+			if l.opts.Documentation == documentationCompact {
+				return false
+			}
+		case "Dialog":
+			return l.wrapList(rule)
+		case "choices":
+			if severalChoices(rule.GetParent().(*parser.Argument_listContext)) {
+				return true
+			}
+		}
+		if l.opts.MaxInlineModifications > 0 && 0 == l.inAnnotation {
+			// see manyModifications
+			return l.wrapList(rule)
+		}
+		return l.expandArguments() || l.expandModelAnnotation() || l.wrapList(rule) && !hugged(rule.(*parser.ArgumentContext))
+	case parser.INamed_argumentContext:
+		return 0 == l.inSubscript && (l.expandArguments() || l.expandModelAnnotation()) || l.wrapList(rule)
+	case parser.IExpressionContext:
+		return l.inModelAnnotationVector(rule) || l.wrapList(rule) || l.matrixRow(rule) || l.breakBeforeBinding(rule)
+	case parser.IFunction_argumentContext:
+		if iteratorFor(rule.GetParent()) != nil {
+			// laid out with the iterators, see wrappedComprehension
+			return false
+		}
+		return 0 == l.inNamedArgument && 0 == l.inVector && 0 == l.inSubscript && (l.expandArguments() || l.expandModelAnnotation()) || l.wrapList(rule)
+	default:
+		return false
+	}
+}
+
+// annotationOnOwnLine returns true if the annotation starts a line of its
+// own, which class annotations always do, see Options.AnnotationPlacement
+func (l *modelicaListener) annotationOnOwnLine(annotation antlr.ParserRuleContext) bool {
+	_, classAnnotation := annotation.GetParent().(*parser.Model_annotationContext)
+	return classAnnotation || l.opts.AnnotationPlacement == annotationOwnLine
+}
+
+// expandArguments returns true if outside annotations with arguments always
+// written one per line, see Options.Arguments. The arguments within when
+// clauses, such as those of reinit, and within the conditions of clauses are
+// only wrapped when they do not fit, so that conditions stay on the line of
+// their keyword. So are the attributes of type aliases such as
+// `type Percentage=Real(min=0,max=100)`.
+func (l *modelicaListener) expandArguments() bool {
+	return 0 == l.inAnnotation && 0 == l.inWhen && 0 == l.inCondition && 0 == l.inTypeAlias && l.opts.Arguments == argumentsExpand
+}
+
+// inModelAnnotationVector returns true if the expression is an element of the
+// vector of a model annotation being laid out one element per line
+func (l *modelicaListener) inModelAnnotationVector(rule antlr.ParserRuleContext) bool {
+	if len(l.modelAnnotationVectorStack) == 0 {
+		return false
+	}
+
+	// handle expression which is an element of a vector (array_arguments) and within model annotation
+	arrayArgumentsNode, ok := rule.GetParent().(*parser.Array_argumentsContext)
+	if !ok {
+		return false
+	}
+
+	// check if the vector is the same as the one on top of our stack
+	thisVectorInterval := arrayArgumentsNode.GetParent().(*parser.VectorContext).GetSourceInterval()
+	stackVectorInterval := l.modelAnnotationVectorStack[len(l.modelAnnotationVectorStack)-1].GetSourceInterval()
+	return thisVectorInterval.Start == stackVectorInterval.Start && thisVectorInterval.Stop == stackVectorInterval.Stop
+}
+
+// wrapList returns true if the rule is an item of a list which is wrapped, one
+// item per line, because the list does not fit within Options.MaxLineLength.
+// The lists are modification lists, function call arguments and vector
+// elements. The decision is made when entering the first item of the list, at
+// which point the opening bracket has been written, and remembered for the
+// other items. The items of a wrapped list nested in another one are indented
+// one level more than the item holding it, so that nested calls form a
+// staircase.
+func (l *modelicaListener) wrapList(rule antlr.ParserRuleContext) bool {
+	list := wrappableList(rule)
+	return list != nil && l.wrapped(list)
+}
+
+// wrapped returns true if the list does not fit within Options.MaxLineLength,
+// see wrapList
+func (l *modelicaListener) wrapped(list antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[list]; ok {
+		return wrap
+	}
+	wrap := l.manyElements(list) || manyLiterals(list) || l.manyModifications(list)
+	if !wrap && l.opts.MaxLineLength > 0 {
+		width, singleLine := l.flatWidth(list)
+		wrap = l.commentWithin(list) || holdsChoices(list) ||
+			!compactList(list) && !l.singleLinePlacement(list) && (!singleLine || l.lineWidth()+width+trailingWidth(list) > l.opts.MaxLineLength)
+	}
+	l.wrappedLists[list] = wrap
+	return wrap
+}
+
+// manyElements returns true if the list is the elements of a vector outside
+// annotations which has more than Options.MaxVectorElements elements
+func (l *modelicaListener) manyElements(list antlr.ParserRuleContext) bool {
+	arguments, ok := list.(*parser.Array_argumentsContext)
+	return ok && l.opts.MaxVectorElements > 0 && 0 == l.inAnnotation &&
+		len(arguments.AllExpression()) > l.opts.MaxVectorElements
+}
+
+// manyModifications returns true if the list is a modification list outside
+// annotations with more than Options.MaxInlineModifications arguments
+func (l *modelicaListener) manyModifications(list antlr.ParserRuleContext) bool {
+	arguments, ok := list.(*parser.Argument_listContext)
+	return ok && l.opts.MaxInlineModifications > 0 && 0 == l.inAnnotation &&
+		len(arguments.AllArgument()) > l.opts.MaxInlineModifications
+}
+
+// maxInlineLiterals is the number of literals up to which an enumeration is
+// kept on one line when it fits
+const maxInlineLiterals = 2
+
+// manyLiterals returns true if the list is the literals of an enumeration with
+// more than maxInlineLiterals literals, which are written one per line along
+// with their description
+func manyLiterals(list antlr.ParserRuleContext) bool {
+	literals, ok := list.(*parser.Enum_listContext)
+	return ok && len(literals.AllEnumeration_literal()) > maxInlineLiterals
+}
+
+// describesLiteral returns true if the description is that of an enumeration
+// literal, which is kept on the line of the literal
+func describesLiteral(description antlr.ParserRuleContext) bool {
+	_, ok := description.GetParent().(*parser.Enumeration_literalContext)
+	return ok
+}
+
+// trailingDescription returns true if the description is kept on the line of
+// the class or declaration it describes, because it fits within
+// Options.MaxLineLength, see Options.DescriptionPlacement. The decision is
+// made when entering the description and remembered for when it is exited.
+func (l *modelicaListener) trailingDescription(description antlr.ParserRuleContext) bool {
+	if l.opts.DescriptionPlacement != descriptionTrailing {
+		return false
+	}
+	if wrap, ok := l.wrappedLists[description]; ok {
+		return !wrap
+	}
+	wrap := l.onNewLine || l.commentBefore(description)
+	if !wrap && l.opts.MaxLineLength > 0 {
+		width, singleLine := l.flatWidth(description)
+		wrap = !singleLine || l.lineWidth()+1+width+trailingWidth(description) > l.opts.MaxLineLength
+	}
+	l.wrappedLists[description] = wrap
+	return !wrap
+}
+
+// iteratorFor returns the 'for' of an array constructor or a reduction with
+// iterators, such as `{f(i) for i in 1:n}` or `sum(x[i] for i in 1:n)`, or nil
+// if the rule is not one
+func iteratorFor(rule antlr.Tree) antlr.TerminalNode {
+	switch rule.(type) {
+	case *parser.Array_iterator_constructorContext, *parser.Function_argumentsContext:
+		for _, child := range rule.GetChildren() {
+			if terminal, ok := child.(antlr.TerminalNode); ok && terminal.GetText() == "for" {
+				return terminal
+			}
+		}
+	}
+	return nil
+}
+
+// wrappedComprehension returns true if the array constructor or reduction
+// with iterators does not fit within Options.MaxLineLength, in which case its
+// 'for' and iterators are written on their own line, indented. Comprehensions
+// which fit are kept on one line. The decision is made when entering the
+// comprehension and remembered for its 'for'.
+func (l *modelicaListener) wrappedComprehension(rule antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return wrap
+	}
+	wrap := false
+	if l.opts.MaxLineLength > 0 {
+		width, singleLine := l.flatWidth(rule)
+		wrap = !singleLine || l.lineWidth()+width+trailingWidth(rule) > l.opts.MaxLineLength
+	}
+	l.wrappedLists[rule] = wrap
+	return wrap
+}
+
+// breakBeforeFor returns true if the terminal is the 'for' of the innermost
+// comprehension being written and the comprehension is wrapped
+func (l *modelicaListener) breakBeforeFor(node antlr.TerminalNode) bool {
+	n := len(l.comprehensions)
+	return n > 0 && node == iteratorFor(l.comprehensions[n-1].rule) && l.wrappedComprehension(l.comprehensions[n-1].rule)
+}
+
+// hang breaks the line before the next token, indenting the lines of the rule
+// after its first one
+func (l *modelicaListener) hang(rule *hangingRule) {
+	if !l.onNewLine {
+		l.writeNewline()
+	}
+	if rule.indents == 0 {
+		l.maybeIndent()
+		rule.indents = 1
+	}
+}
+
+// hangChain breaks the line before the next token, indenting the lines of the
+// operator chain at index i of operatorChains after its first one. The
+// enclosing chains which are broken but not indented yet, as when breaking
+// their first operand, are indented first so that each chain is indented
+// further than the chain holding it. The lines of a condition followed by
+// `then` or `loop` are indented twice, to set them apart from the body of the
+// clause.
+func (l *modelicaListener) hangChain(i int) {
+	if !l.onNewLine {
+		l.writeNewline()
+	}
+	for j := range l.operatorChains[:i+1] {
+		chain := &l.operatorChains[j]
+		if chain.indents > 0 || j < i && !l.wrappedChain(chain.rule) {
+			continue
+		}
+		chain.indents = 1
+		if in, outermost := conditionChain(chain.rule); in {
+			l.conditionBroken = true
+			if outermost && l.opts.ThenPlacement == thenTrailing {
+				chain.indents = 2
+			}
+		}
+		// not maybeIndent, which indents a line by one level at most
+		for k := 0; k < chain.indents; k++ {
+			l.indentationStack = append(l.indentationStack, renderIndent)
+		}
+		l.lineIndentIncreased = true
+	}
+}
+
+// breakableChain returns true if the rule is an operator chain which is
+// broken when it does not fit, see Options.OperatorWrap. The logical
+// operators of the conditions of if, when and while clauses are broken
+// whatever the option.
+func (l *modelicaListener) breakableChain(rule antlr.Tree) bool {
+	if chainOperators(rule) == nil {
+		return false
+	}
+	in, _ := conditionChain(rule)
+	return l.opts.OperatorWrap != operatorWrapNone || in
+}
+
+// conditionChain returns true if the rule is a chain of `and` or `or` of the
+// condition of an if, elseif, when, elsewhen or while clause, along with true
+// if it is not within another such chain
+func conditionChain(rule antlr.Tree) (in, outermost bool) {
+	switch rule.(type) {
+	case *parser.Logical_expressionContext, *parser.Logical_termContext:
+	default:
+		return false, false
+	}
+
+	outermost = true
+	for node := rule.GetParent(); node != nil; node = node.GetParent() {
+		switch node := node.(type) {
+		case *parser.Logical_expressionContext, *parser.Logical_termContext:
+			outermost = outermost && chainOperators(node) == nil
+		case *parser.Logical_factorContext, *parser.Simple_expressionContext:
+		case *parser.ExpressionContext:
+			return isCondition(node), outermost
+		default:
+			return false, false
+		}
+	}
+	return false, false
+}
+
+// isCondition returns true if the expression is the condition of an if,
+// elseif, when, elsewhen or while clause
+func isCondition(expression *parser.ExpressionContext) bool {
+	switch expression.GetParent().(type) {
+	case *parser.If_equationContext, *parser.If_statementContext, *parser.When_equationContext,
+		*parser.When_statementContext, *parser.While_statementContext:
+		return true
+	}
+	return false
+}
+
+// breakBeforeKeyword returns true if the terminal is the `then` or `loop`
+// following a broken condition, which is written on its own line, see
+// Options.ThenPlacement
+func (l *modelicaListener) breakBeforeKeyword(node antlr.TerminalNode) bool {
+	if !l.conditionBroken {
+		return false
+	}
+	switch node.GetText() {
+	case "then", "loop":
+		l.conditionBroken = false
+		return l.opts.ThenPlacement == thenOwnLine
+	}
+	return false
+}
+
+// chainOperators returns the binary operators of a chain of operands such as
+// `a+b-c`, `x*y` or `p and q`, or nil if the rule is not one. A leading sign,
+// as in `-a+b`, is not a binary operator.
+func chainOperators(rule antlr.Tree) []antlr.TerminalNode {
+	switch rule.(type) {
+	case *parser.Logical_expressionContext, *parser.Logical_termContext, *parser.RelationContext,
+		*parser.Arithmetic_expressionContext, *parser.TermContext:
+	default:
+		return nil
+	}
+
+	var operators []antlr.TerminalNode
+	for i, child := range rule.GetChildren() {
+		if i == 0 {
+			continue
+		}
+		switch child := child.(type) {
+		case antlr.TerminalNode:
+			operators = append(operators, child)
+		case *parser.Add_opContext, *parser.Mul_opContext, *parser.Rel_opContext:
+			operators = append(operators, firstTerminal(child))
+		}
+	}
+	return operators
+}
+
+// wrappedChain returns true if the operator chain does not fit within
+// Options.MaxLineLength, in which case it is broken at each of its operators,
+// see Options.OperatorWrap. The decision is made when entering the chain and
+// remembered for its operators. The operands of a broken chain are broken in
+// turn if they do not fit.
+func (l *modelicaListener) wrappedChain(rule antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return wrap
+	}
+	wrap := false
+	if l.opts.MaxLineLength > 0 {
+		start := l.lineWidth()
+		if l.onNewLine {
+			start = l.opts.Indent * l.indentation()
+		}
+		width, singleLine := l.flatWidth(rule)
+		wrap = !singleLine || start+width+trailingWidth(rule) > l.opts.MaxLineLength
+	}
+	l.wrappedLists[rule] = wrap
+	return wrap
+}
+
+// breakBeforeOperator returns true if the terminal is an operator of the
+// innermost operator chain being written, which is broken before its operators
+func (l *modelicaListener) breakBeforeOperator(node antlr.TerminalNode) bool {
+	return l.opts.OperatorWrap != operatorWrapTrailing && l.chainOperator(node)
+}
+
+// breakAfterOperator returns true if the terminal is an operator of the
+// innermost operator chain being written, which is broken after its operators
+func (l *modelicaListener) breakAfterOperator(node antlr.TerminalNode) bool {
+	return l.opts.OperatorWrap == operatorWrapTrailing && l.chainOperator(node)
+}
+
+// chainOperator returns true if the terminal is an operator of the innermost
+// operator chain being written and the chain is wrapped
+func (l *modelicaListener) chainOperator(node antlr.TerminalNode) bool {
+	n := len(l.operatorChains)
+	if n == 0 || !l.wrappedChain(l.operatorChains[n-1].rule) {
+		return false
+	}
+	for _, operator := range chainOperators(l.operatorChains[n-1].rule) {
+		if operator == node {
+			return true
+		}
+	}
+	return false
+}
+
+// enterChain decides whether an operator chain is wrapped while the output is
+// at its start, see wrappedChain
+func (l *modelicaListener) enterChain(node antlr.ParserRuleContext) {
+	l.operatorChains = append(l.operatorChains, hangingRule{rule: node})
+	l.wrappedChain(node)
+}
+
+// exitChain removes the indentation of the lines of a wrapped operator chain
+func (l *modelicaListener) exitChain() {
+	for i := l.operatorChains[len(l.operatorChains)-1].indents; i > 0; i-- {
+		l.maybeDedent()
+	}
+	l.operatorChains = l.operatorChains[:len(l.operatorChains)-1]
+}
+
+// compactIfExpression returns true if the rule is part of an if-expression,
+// such as `if cond then a else b`, which is kept on one line because it fits
+// within Options.MaxLineLength. Other if-expressions have their branches on
+// their own lines. The decision is made when entering the if-expression.
+func (l *modelicaListener) compactIfExpression(rule antlr.ParserRuleContext) bool {
+	for _, ok := rule.(*parser.If_expressionContext); !ok; _, ok = rule.(*parser.If_expressionContext) {
+		rule = rule.GetParent().(antlr.ParserRuleContext)
+	}
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return !wrap
+	}
+	width, singleLine := l.flatWidth(rule)
+	wrap := l.commentWithin(rule) || !singleLine ||
+		l.opts.MaxLineLength > 0 && l.lineWidth()+width+trailingWidth(rule) > l.opts.MaxLineLength
+	l.wrappedLists[rule] = wrap
+	return !wrap
+}
+
+// closesWrappedVector returns true if the terminal is the closing brace of a
+// vector outside annotations whose elements are wrapped, which is written on
+// its own line
+func (l *modelicaListener) closesWrappedVector(node antlr.TerminalNode) bool {
+	if node.GetText() != "}" || l.inAnnotation > 0 {
+		return false
+	}
+	// the parent of a terminal is the untyped context of its rule, whose
+	// parent is the primary holding the vector
+	primary, ok := node.GetParent().GetParent().(*parser.PrimaryContext)
+	if !ok || primary.Vector() == nil {
+		return false
+	}
+	arguments, ok := primary.Vector().(*parser.VectorContext).Array_arguments().(*parser.Array_argumentsContext)
+	return ok && l.wrappedLists[arguments]
+}
+
+// expandModelAnnotation returns true if within a class annotation which is
+// expanded, with its arguments and the graphical primitives of its vectors on
+// their own lines. Class annotations which fit within Options.MaxLineLength
+// are kept on a single line instead. The arguments of a graphical primitive
+// are not expanded, each primitive stays on one line when it fits, unless
+// Options.Graphics is "expand-all".
+func (l *modelicaListener) expandModelAnnotation() bool {
+	if l.inModelAnnotation == 0 || l.inGraphics > 0 && (l.opts.Graphics == graphicsCompact || len(l.modelAnnotationVectorStack) > 0 && l.opts.Graphics != graphicsExpandAll) {
+		return false
+	}
+	annotation := l.modelAnnotation.Annotation().(*parser.AnnotationContext)
+	list := annotation.Class_modification().(*parser.Class_modificationContext).Argument_list()
+	return list != nil && (l.wrapped(list) || l.opts.Experiment == experimentExpand && hasExperiment(list))
+}
+
+// annotationArgumentName returns the name of the annotation argument whose
+// modification holds the argument, e.g. "experiment" for `StopTime=1` in
+// `annotation (experiment(StopTime=1))`, or "" if the argument is not directly
+// within an argument of an annotation
+func annotationArgumentName(argument antlr.Tree) string {
+	// argument list, class modification, modification, element modification,
+	// element modification or replaceable, argument
+	parent := argument
+	for i := 0; i < 6 && parent != nil; i++ {
+		parent = parent.GetParent()
+	}
+	annotationArgument, ok := parent.(*parser.ArgumentContext)
+	if !ok {
+		return ""
+	}
+	if _, ok := annotationArgument.GetParent().GetParent().GetParent().(*parser.AnnotationContext); !ok {
+		return ""
+	}
+	return argumentName(annotationArgument)
+}
+
+// holdsChoices returns true if the list holds a choices annotation with
+// several choices, which takes several lines
+func holdsChoices(list antlr.ParserRuleContext) bool {
+	arguments, ok := list.(*parser.Argument_listContext)
+	if !ok {
+		return false
+	}
+	for _, argument := range arguments.AllArgument() {
+		argument := argument.(*parser.ArgumentContext)
+		if argumentName(argument) != "choices" {
+			continue
+		}
+		if choices := modificationArguments(argument); choices != nil && severalChoices(choices) {
+			return true
+		}
+	}
+	return false
+}
+
+// modificationArguments returns the argument list modifying the element of
+// the argument, e.g. `choice=1, choice=2` for `choices(choice=1, choice=2)`,
+// or nil if there is none
+func modificationArguments(argument *parser.ArgumentContext) *parser.Argument_listContext {
+	wrapper, ok := argument.Element_modification_or_replaceable().(*parser.Element_modification_or_replaceableContext)
+	if !ok {
+		return nil
+	}
+	modification, ok := wrapper.Element_modification().(*parser.Element_modificationContext)
+	if !ok || modification.Modification() == nil {
+		return nil
+	}
+	classModification := modification.Modification().(*parser.ModificationContext).Class_modification()
+	if classModification == nil {
+		return nil
+	}
+	list, _ := classModification.(*parser.Class_modificationContext).Argument_list().(*parser.Argument_listContext)
+	return list
+}
+
+// severalChoices returns true if the argument list of a choices annotation
+// holds more than one choice
+func severalChoices(list *parser.Argument_listContext) bool {
+	count := 0
+	for _, argument := range list.AllArgument() {
+		if argumentName(argument.(*parser.ArgumentContext)) == "choice" {
+			count++
+		}
+	}
+	return count > 1
+}
+
+// hasExperiment returns true if one of the arguments of the annotation
+// argument list is the experiment annotation
+func hasExperiment(list parser.IArgument_listContext) bool {
+	for _, argument := range list.(*parser.Argument_listContext).AllArgument() {
+		if argumentName(argument.(*parser.ArgumentContext)) == "experiment" {
+			return true
+		}
+	}
+	return false
+}
+
+// wrappableList returns the list which the rule is an item of, or nil if the
+// rule is not an item of a list which can be wrapped
+func wrappableList(rule antlr.ParserRuleContext) antlr.ParserRuleContext {
+	switch rule.(type) {
+	case parser.IArgumentContext:
+		if list, ok := rule.GetParent().(*parser.Argument_listContext); ok {
+			return list
+		}
+	case parser.IExpressionContext:
+		if list, ok := rule.GetParent().(*parser.Array_argumentsContext); ok {
+			return list
+		}
+	case parser.IEnumeration_literalContext:
+		return rule.GetParent().(antlr.ParserRuleContext)
+	case parser.INamed_argumentContext, parser.IFunction_argumentContext:
+		// function arguments and named arguments are nested lists, the
+		// outermost one holds all the arguments of the call
+		var list antlr.ParserRuleContext
+		for parent := rule.GetParent(); ; parent = parent.GetParent() {
+			switch parent := parent.(type) {
+			case *parser.Function_argumentsContext, *parser.Named_argumentsContext:
+				list = parent.(antlr.ParserRuleContext)
+				continue
+			}
+			return list
+		}
+	}
+	return nil
+}
+
+// hugged returns true if the argument is the only argument of an annotation
+// and is either a Placement, Dialog, choices or the argument of a connect
+// annotation. It is kept right after `annotation (` even when it does not fit
+// on the line. The arguments of a Placement are wrapped instead, so that
+// placements take at most a few lines, e.g.
+//
+//	annotation (Placement(
+//	  transformation(extent={{-120,-10},{-100,10}}),
+//	  iconTransformation(extent={{-120,-10},{-100,10}})));
+func hugged(argument *parser.ArgumentContext) bool {
+	list, ok := argument.GetParent().(*parser.Argument_listContext)
+	if !ok || len(list.AllArgument()) != 1 {
+		return false
+	}
+	if _, ok := list.GetParent().GetParent().(*parser.AnnotationContext); !ok {
+		return false
+	}
+	switch argumentName(argument) {
+	case "Placement", "Dialog", "choices":
+		return true
+	}
+	return inConnectAnnotation(argument)
+}
+
+// commentedArgument returns true if the argument is within an annotation and
+// a comment precedes it, in which case it goes on its own line right after
+// the comment, at the indentation of the arguments, rather than after the
+// comment on the line of the previous token. The decision is made when
+// entering the argument and remembered for when it is exited, by which time
+// the comment is written.
+func (l *modelicaListener) commentedArgument(argument *parser.ArgumentContext) bool {
+	if commented, ok := l.commentedArguments[argument]; ok {
+		return commented
+	}
+	commented := 0 < l.inAnnotation && l.commentBefore(argument)
+	l.commentedArguments[argument] = commented
+	return commented
+}
+
+// commentBefore returns true if a comment which is yet to be written precedes
+// the rule
+func (l *modelicaListener) commentBefore(rule antlr.ParserRuleContext) bool {
+	return len(l.commentTokens) > 0 && l.commentTokens[0].GetTokenIndex() < rule.GetStart().GetTokenIndex()
+}
+
+// compactList returns true if the list is kept on a single line however long
+// it is, unless a comment is within it. These are the arguments of Dialog
+// annotations and the lists within the arguments of connect annotations.
+func compactList(list antlr.ParserRuleContext) bool {
+	if inConnectAnnotation(list.GetParent()) {
+		return true
+	}
+	arguments, ok := list.(*parser.Argument_listContext)
+	return ok && annotationArgumentName(arguments.GetChild(0)) == "Dialog"
+}
+
+// inConnectAnnotation returns true if the rule is within an argument of the
+// annotation of a connect equation. These arguments, mostly
+// Line(points=..., color=...), are generated by graphical editors and are each
+// kept on a single line, so that equation sections remain readable.
+func inConnectAnnotation(rule antlr.Tree) bool {
+	for node := rule; node != nil; node = node.GetParent() {
+		argument, ok := node.(*parser.ArgumentContext)
+		if !ok {
+			continue
+		}
+		annotation, ok := argument.GetParent().GetParent().GetParent().(*parser.AnnotationContext)
+		if !ok {
+			continue
+		}
+		equation, ok := annotation.GetParent().(*parser.EquationContext)
+		return ok && equation.Connect_clause() != nil
+	}
+	return false
+}
+
+// trailingWidth returns the width of the closing brackets following the rule
+// and of the comma or semicolon after them, which end up on the same line as
+// the rule
+func trailingWidth(rule antlr.Tree) int {
+	width := 0
+	for node := rule; node.GetParent() != nil; node = node.GetParent() {
+		siblings := node.GetParent().GetChildren()
+		i := 0
+		for siblings[i] != node {
+			i++
+		}
+		for _, sibling := range siblings[i+1:] {
+			terminal, ok := sibling.(antlr.TerminalNode)
+			if !ok {
+				return width
+			}
+			switch terminal.GetText() {
+			case ")", "]", "}":
+				width++
+			case ",", ";":
+				return width + 1
+			default:
+				return width
+			}
+		}
+	}
+	return width
+}
+
+// flatWidth returns the width of the rule's tokens written on a single line,
+// and false if one of them spans several lines
+func (l *modelicaListener) flatWidth(rule antlr.ParserRuleContext) (int, bool) {
+	width, singleLine := 0, true
+	previous, spaceAfterPrevious := "", false
+	var walk func(tree antlr.Tree)
+	walk = func(tree antlr.Tree) {
+		if node, ok := tree.(antlr.ParserRuleContext); ok && l.verbatim(node) {
+			// written as a single token, see writeVerbatim
+			text := verbatimText(node)
+			if previous != "" && l.spaceBetween(previous, spaceAfterPrevious, node.GetStart().GetText(), noOperator) {
+				width++
+			}
+			width += utf8.RuneCountInString(text)
+			singleLine = singleLine && !strings.Contains(text, "\n")
+			previous, spaceAfterPrevious = node.GetStop().GetText(), false
+			return
+		}
+		if node, ok := tree.(antlr.TerminalNode); ok {
+			if l.dropped(node) {
+				return
+			}
+			text := node.GetText()
+			if previous != "" && l.spaceBetween(previous, spaceAfterPrevious, text, roleOf(node)) {
+				width++
+			}
+			width += utf8.RuneCountInString(text)
+			singleLine = singleLine && !strings.Contains(text, "\n")
+			previous, spaceAfterPrevious = text, l.insertSpaceAfterTerminal(node)
+			return
+		}
+		for _, child := range tree.GetChildren() {
+			walk(child)
+		}
+	}
+	walk(rule)
+	return width, singleLine
+}
+
+// leadingWidth returns the width of the rule's tokens written on a single line
+// up to the opening bracket of its first class modification or function call
+// arguments, along with true if there is one, or else up to the first tree
+// for which stop returns true. This is the width of the first line of the
+// rule when the lists it holds are wrapped.
+func (l *modelicaListener) leadingWidth(rule antlr.ParserRuleContext, stop func(antlr.Tree) bool) (int, bool) {
+	width, bracket, done := 0, false, false
+	previous, spaceAfterPrevious := "", false
+	var walk func(tree antlr.Tree)
+	walk = func(tree antlr.Tree) {
+		if done {
+			return
+		}
+		switch tree.(type) {
+		case *parser.Class_modificationContext, *parser.Function_call_argsContext:
+			width++
+			bracket, done = true, true
+			return
+		}
+		if stop(tree) {
+			done = true
+			return
+		}
+		if node, ok := tree.(antlr.TerminalNode); ok {
+			text := node.GetText()
+			if previous != "" && l.spaceBetween(previous, spaceAfterPrevious, text, roleOf(node)) {
+				width++
+			}
+			width += utf8.RuneCountInString(text)
+			previous, spaceAfterPrevious = text, l.insertSpaceAfterTerminal(node)
+			return
+		}
+		for _, child := range tree.GetChildren() {
+			walk(child)
+		}
+	}
+	walk(rule)
+	return width, bracket
+}
+
+// commentWithin returns true if a comment remains to be written within the rule
+func (l *modelicaListener) commentWithin(rule antlr.ParserRuleContext) bool {
+	start, stop := rule.GetStart().GetTokenIndex(), rule.GetStop().GetTokenIndex()
+	for _, comment := range l.commentTokens {
+		if comment.GetTokenIndex() > stop {
+			break
+		}
+		if comment.GetTokenIndex() > start {
+			return true
+		}
+	}
+	return false
+}
+
+// breakComponentList returns true if a component declaration should be put on
+// its own line, which is the case for all but the first declaration of a
+// component list declaring several components when any of them has a
+// modification, description or annotation
+func breakComponentList(rule antlr.ParserRuleContext) bool {
+	componentList, ok := rule.GetParent().(*parser.Component_listContext)
+	if !ok || componentList.Component_declaration(0) == rule {
+		return false
+	}
+
+	for _, declaration := range componentList.AllComponent_declaration() {
+		declarationNode := declaration.(*parser.Component_declarationContext)
+		if declarationNode.Declaration().(*parser.DeclarationContext).Modification() != nil ||
+			declarationNode.String_comment() != nil ||
+			declarationNode.Annotation() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// breakBeforeConstrainingClause returns true if a constraining clause should be
+// put on its own line, which is the case unless the declaration it constrains
+// is on a single line, both fit within Options.MaxLineLength and no comment precedes the
+// clause. The decision is made when
+// entering the clause and remembered for when it is exited.
+func (l *modelicaListener) breakBeforeConstrainingClause(clause *parser.Constraining_clauseContext) bool {
+	if brk, ok := l.constrainingClauseBreaks[clause]; ok {
+		return brk
+	}
+	brk := true
+	if clause.Class_modification() == nil && !l.commentBefore(clause) && l.opts.MaxLineLength > 0 && len(l.declarationLines) > 0 && l.declarationLines[len(l.declarationLines)-1] == l.outputPosition.Line {
+		// the clause is written as "constrainedby" followed by a name without spaces
+		length := len(" constrainedby ") + utf8.RuneCountInString(clause.Name().GetText())
+		brk = l.lineWidth()+length > l.opts.MaxLineLength
+	}
+	l.constrainingClauseBreaks[clause] = brk
+	return brk
+}
+
+// breakBeforeCondition returns true if the condition attribute of a component
+// declaration, such as `if have_pum`, is put on its own line, indented,
+// because it does not fit within Options.MaxLineLength at the end of the
+// declaration. Its description and annotation follow on their own lines.
+func (l *modelicaListener) breakBeforeCondition(rule antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return wrap
+	}
+	wrap := false
+	if l.opts.MaxLineLength > 0 && !l.onNewLine {
+		width, singleLine := l.flatWidth(rule)
+		wrap = !singleLine || l.lineWidth()+1+width+trailingWidth(rule) > l.opts.MaxLineLength
+	}
+	l.wrappedLists[rule] = wrap
+	return wrap
+}
+
+// typeAlias returns true if the short class specifier defines a type, such
+// as `Percentage=Real(min=0,max=100)`
+func typeAlias(specifier *parser.Short_class_specifierContext) bool {
+	var prefixes parser.IClass_prefixesContext
+	switch definition := specifier.GetParent().(type) {
+	case *parser.Class_specifierContext:
+		prefixes = definition.GetParent().(*parser.Class_definitionContext).Class_prefixes()
+	case *parser.Short_class_definitionContext:
+		prefixes = definition.Class_prefixes()
+	default:
+		return false
+	}
+	return prefixes.GetText() == "type"
+}
+
+// redeclaredClause returns true if the rule is the component clause or short
+// class definition of a redeclared or replaceable element, such as
+// `Data dat=Data()` in `redeclare replaceable Data dat=Data()`
+func redeclaredClause(rule antlr.ParserRuleContext) bool {
+	switch rule := rule.(type) {
+	case *parser.Class_definitionContext:
+		if _, ok := rule.Class_specifier().GetChild(0).(*parser.Short_class_specifierContext); !ok {
+			return false
+		}
+	case *parser.Component_clauseContext, *parser.Component_clause1Context, *parser.Short_class_definitionContext:
+	default:
+		return false
+	}
+	switch parent := rule.GetParent().(type) {
+	case *parser.ElementContext:
+		for _, child := range parent.GetChildren() {
+			if terminal, ok := child.(antlr.TerminalNode); ok && (terminal.GetText() == "redeclare" || terminal.GetText() == "replaceable") {
+				return true
+			}
+		}
+		return false
+	case *parser.Element_redeclarationContext, *parser.Element_replaceableContext:
+		return true
+	}
+	return false
+}
+
+// endsHead returns true if the tree ends the head of a declaration, i.e. its
+// type and name up to the binding of a component, or a whole short class
+// definition, up to its description or annotation
+func endsHead(tree antlr.Tree) bool {
+	switch tree := tree.(type) {
+	case *parser.ExpressionContext:
+		_, ok := tree.GetParent().(*parser.ModificationContext)
+		return ok
+	case *parser.Condition_attributeContext, *parser.String_commentContext, *parser.AnnotationContext:
+		return true
+	}
+	return false
+}
+
+// breakAfterPrefixes returns true if the clause of a redeclared or replaceable
+// element is put on its own line, after the `redeclare` and `replaceable`
+// prefixes, which is the case when its head does not fit within
+// Options.MaxLineLength on their line, see endsHead
+func (l *modelicaListener) breakAfterPrefixes(rule antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return wrap
+	}
+	wrap := false
+	if l.opts.MaxLineLength > 0 && !l.onNewLine && redeclaredClause(rule) {
+		width, _ := l.leadingWidth(rule, endsHead)
+		wrap = l.lineWidth()+1+width > l.opts.MaxLineLength
+	}
+	l.wrappedLists[rule] = wrap
+	return wrap
+}
+
+// breakBeforeBinding returns true if the binding of a redeclared or
+// replaceable component, such as `Data()` in `redeclare Data dat=Data()`, is
+// put on its own line, indented, because it does not fit within
+// Options.MaxLineLength after the `=`. A binding calling a function or a
+// record constructor only has to fit up to its opening bracket, the arguments
+// are wrapped if need be.
+func (l *modelicaListener) breakBeforeBinding(rule antlr.ParserRuleContext) bool {
+	if wrap, ok := l.wrappedLists[rule]; ok {
+		return wrap
+	}
+	wrap := false
+	if l.opts.MaxLineLength > 0 && l.bindsRedeclaredComponent(rule) {
+		width, bracket := l.leadingWidth(rule, func(antlr.Tree) bool { return false })
+		if !bracket {
+			width += trailingWidth(rule)
+		}
+		wrap = l.lineWidth()+width > l.opts.MaxLineLength
+	}
+	l.wrappedLists[rule] = wrap
+	return wrap
+}
+
+// bindsRedeclaredComponent returns true if the expression is the binding of a
+// redeclared or replaceable component
+func (l *modelicaListener) bindsRedeclaredComponent(rule antlr.ParserRuleContext) bool {
+	if _, ok := rule.GetParent().(*parser.ModificationContext); !ok {
+		return false
+	}
+	declaration := rule.GetParent().GetParent().GetParent()
+	switch declaration.(type) {
+	case *parser.Component_declarationContext:
+		return redeclaredClause(declaration.GetParent().GetParent().(antlr.ParserRuleContext))
+	case *parser.Component_declaration1Context:
+		return redeclaredClause(declaration.GetParent().(antlr.ParserRuleContext))
+	}
+	return false
+}
+
+// splitDeclarations returns true if the declarations of a component list are
+// written as separate elements, see Options.SplitDeclarations
+func (l *modelicaListener) splitDeclarations(componentList antlr.Tree) bool {
+	if !l.opts.SplitDeclarations {
+		return false
+	}
+	element, ok := componentList.GetParent().GetParent().(*parser.ElementContext)
+	return ok && element.Constraining_clause() == nil
+}
+
+// insertSpaceAfterTerminal returns true if a space should always follow the
+// terminal, regardless of the next token
+func (l *modelicaListener) insertSpaceAfterTerminal(node antlr.TerminalNode) bool {
+	if node.GetText() == "," {
+		// whatever follows, as in `f(a, b)`, `x[1, :]` or `a, /* b */`
+		return l.opts.SpaceInAnnotationVectors || !annotationVectorComma(node)
+	}
+	return l.spacedOperator(roleOf(node))
+}
+
+// operatorRole is the role of an operator whose spacing depends on its
+// context. The + and - of arithmetic expressions are binary operators
+// surrounded by spaces, as in `a - b`, or signs written against their
+// operand, as in `-b`. The `:` of ranges, as in `1:n`, is spaced as set by
+// Options.SpaceAroundRange, unlike the `:` standing for a whole dimension, as
+// in `x[:,1]`. The multiplicative operators and exponents are spaced as set
+// by Options.SpaceAroundMultiplication and Options.SpaceAroundExponent, and
+// the `=` of equations by Options.SpaceAroundEquations.
+type operatorRole int
+
+const (
+	noOperator operatorRole = iota
+	signOperator
+	binaryOperator
+	rangeOperator
+	multiplicativeOperator
+	exponentOperator
+	equationOperator
+)
+
+// roleOf returns the role of the terminal if it is the operator of an
+// arithmetic expression, a sign when it starts the expression, the `:` of a
+// range or the `=` of an equation
+func roleOf(node antlr.TerminalNode) operatorRole {
+	switch terminalRuleIndex(node) {
+	case parser.ModelicaParserRULE_equation:
+		if node.GetText() == "=" {
+			return equationOperator
+		}
+		return noOperator
+	case parser.ModelicaParserRULE_simple_expression:
+		return rangeOperator
+	case parser.ModelicaParserRULE_mul_op:
+		return multiplicativeOperator
+	case parser.ModelicaParserRULE_factor:
+		return exponentOperator
+	case parser.ModelicaParserRULE_add_op:
+	default:
+		return noOperator
+	}
+	expression := node.GetParent().GetParent().(antlr.ParserRuleContext)
+	if expression.GetStart() == node.GetSymbol() {
+		return signOperator
+	}
+	return binaryOperator
+}
+
+// spacedOperator returns true if the operators of the role are surrounded by
+// spaces
+func (l *modelicaListener) spacedOperator(role operatorRole) bool {
+	switch role {
+	case binaryOperator:
+		return true
+	case rangeOperator:
+		return l.opts.SpaceAroundRange
+	case multiplicativeOperator:
+		return l.opts.SpaceAroundMultiplication
+	case exponentOperator:
+		return l.opts.SpaceAroundExponent
+	case equationOperator:
+		return l.opts.SpaceAroundEquations
+	default:
+		return false
+	}
+}
+
+// terminalRuleIndex returns the index of the rule containing a terminal.
+// The parent of a terminal is the generic rule context rather than the
+// specific context type, which is why rules are identified by index here
+func terminalRuleIndex(node antlr.TerminalNode) int {
+	return node.GetParent().(antlr.RuleContext).GetRuleIndex()
+}
+
+// insertSpaceBeforeToken returns true if a space should be inserted before the current token
+func insertSpaceBeforeToken(currentTokenText, previousTokenText string) bool {
+	return !noSpaceAfter[previousTokenText] && !noSpaceBefore[currentTokenText]
+}
+
+// insertNewlineBefore returns true if the rule should be on a new line
+func (l *modelicaListener) insertNewlineBefore(rule antlr.ParserRuleContext) bool {
+	switch rule.(type) {
+	case
+		parser.ICompositionContext,
+		parser.IEquation_sectionContext,
+		parser.IAlgorithm_sectionContext,
+		parser.IEquationsContext:
+		return true
+	case
+		parser.IIf_expression_conditionContext,
+		parser.IElseif_expression_conditionContext,
+		parser.IElse_expression_conditionContext:
+		return !l.compactIfExpression(rule)
+	default:
+		return false
+	}
+}
+
+var (
+	// tokens which should *generally* not have a space after them
+	// this can be overridden in the insertSpace function
+	noSpaceAfterTokens = []string{
+		"(",
+		"=",
+		".",
+		"[",
+		"{",
+		".{", // import list
+		"-", "+", "^", "*", "/",
+		".^", ".*", "./", // element-wise operators
+		";",
+		":", // array range constructor
+	}
+
+	// tokens which should *generally* not have a space before them
+	// this can be overridden in the insertSpace function, and the operators
+	// of arithmetic expressions and ranges are spaced by their role, see
+	// operatorRole
+	noSpaceBeforeTokens = []string{
+		"(", ")",
+		"[", "]",
+		"}",
+		";",
+		"=",
+		",",
+		".",
+		"-", "+", "^", "*", "/",
+		".^", ".*", "./", // element-wise operators
+		":", // array range constructor
+	}
+)
+
+var (
+	// brackets which are padded inside, see Options.SpaceInsideBrackets
+	openingBrackets = tokenSet([]string{"(", "[", "{", ".{"})
+	closingBrackets = tokenSet([]string{")", "]", "}"})
+)
+
+var (
+	// sets of the tokens of the spacing tables, for faster lookups
+	noSpaceAfter  = tokenSet(noSpaceAfterTokens)
+	noSpaceBefore = tokenSet(noSpaceBeforeTokens)
+)
+
+// tokenSet returns the set of the tokens in a group
+func tokenSet(group []string) map[string]bool {
+	set := make(map[string]bool, len(group))
+	for _, token := range group {
+		set[token] = true
+	}
+	return set
+}
+
+// tokenInGroup returns true if a token is in a given list
+func tokenInGroup(token string, group []string) bool {
+	for _, other := range group {
+		if token == other {
+			return true
+		}
+	}
+	return false
+}
+
+type indent int
+
+const (
+	renderIndent indent = iota
+	ignoreIndent
+)
+
+// hangingRule is a rule being written whose lines are indented after the
+// first one once it is broken, see breakBeforeFor and hangChain
+type hangingRule struct {
+	rule    antlr.ParserRuleContext
+	indents int // number of indentation levels added once the rule is broken
+}
+
+// modelicaListener is used to format the parse tree
+type modelicaListener struct {
+	*parser.BaseModelicaListener                 // parser
+	ctx                          context.Context // aborts the walk when done
+	opts                         Options         // formatting options
+	renderer                     Renderer        // writing destination
+	indentationStack             []indent        // a stack used for tracking rendered and ignored indentations
+	onNewLine                    bool            // true when write position succeeds a newline character
+	lineIndentIncreased          bool            // true when the indentation level has already been increased for a line
+	previousTokenText            string          // text of previous token
+	previousTokenIdx             int             // index of previous token
+	spaceAfterPrevious           bool            // true when the previous token must be followed by a space
+	muted                        bool            // true when nothing should be written, see FormatStream
+	emitRange                    antlr.Interval  // indexes of the tokens to write while walking
+	verbatimRange                antlr.Interval  // indexes of the tokens written by writeVerbatim, -1 if none
+	region                       antlr.Interval  // indexes of the tokens written by writeRegion, from the first one on the default channel, -1 if none
+	ignoreStop                   int             // index of the last token of the statement to copy as it is, -1 if none, see ignoreStatement
+	positions                    *PositionMap    // records where tokens are written if not nil
+	outputPosition               Position        // position of the next character written
+	lineTabs                     int             // number of tabs indenting the current output line
+	sourceLine                   int             // source line on which the last written token ends, 0 before the first one
+	afterStatement               bool            // true if the last written token ends a statement or a section keyword, or is a comment
+	separate                     bool            // true if a blank line is to separate the next line from the previous statement, see Options.SeparateSections
+	sourcePosition               *Position       // set to the position of each token visited if not nil
+	declarationLines             []int           // stack of the output lines on which the current replaceable declarations start, 0 until known
+	classLines                   []int           // stack of the output lines on which the current long classes start
+	inWithin                     bool            // true from a within keyword until the token following its clause
+	importShift                  int             // lines between the next token and the place it is written at, see enterImport
+
+	// constrainingClauseBreaks stores whether constraining clauses are put on
+	// their own line, see breakBeforeConstrainingClause
+	constrainingClauseBreaks map[*parser.Constraining_clauseContext]bool
+	// wrappedLists stores whether lists are wrapped, see wrapList
+	wrappedLists map[antlr.ParserRuleContext]bool
+	// broken stores the rules holding syntax errors, which are copied as they
+	// are, see Options.SyntaxErrors
+	broken map[antlr.ParserRuleContext]bool
+	// indents stores the indentation of the source lines indented with tabs,
+	// see sourceColumn
+	indents map[int]string
+	// commentedArguments stores whether the arguments of annotations are
+	// preceded by a comment, see commentedArgument
+	commentedArguments map[*parser.ArgumentContext]bool
+	// alignments stores the padding before the terminals which are aligned,
+	// alignedLists the element and argument lists for which they were
+	// computed, see alignElements, alignArguments and alignAssignments
+	alignments   map[antlr.TerminalNode]int
+	alignedLists map[antlr.ParserRuleContext]bool
+	// importSlots stores the element whose place each sorted import takes,
+	// see sortImports
+	importSlots   map[*parser.ElementContext]*parser.ElementContext
+	commentTokens []antlr.Token     // stores comments to insert while writing
+	tokens        antlr.TokenStream // all the tokens of the source, including whitespace, see writeRegion
+
+	// modelAnnotationVectorStack is a stack which stores `vector` contexts,
+	// which is used for conditionally indenting vector children
+	// Specifically, a vector inside of a model annotation will have indented elements
+	// if that vector has one or more elements which are function calls, class modifications or similar
+	// (ie not if all elements are numbers, more vectors, etc)
+	//
+	// The last element of the slice is the first `vector` context ancestor whose contents
+	// must be indented on new lines
+	// For example, we would like model annotations to look like this:
+	// annotation (
+	// 	Abc(
+	// 		paramA={
+	// 			SomeIdentifier(
+	// 				1,
+	// 				2),
+	//			123}))
+	//
+	// However, due to existing rules, we would end up with something like this
+	// annotation (
+	// 	Abc(
+	// 		paramA={SomeIdentifier(
+	// 			1,
+	// 			2), 123}))
+	//
+	// Thus by pushing/popping vectors we can check if an expression in a vector
+	// should be indented or not by checking if the top of the stack is its ancestor
+	modelAnnotationVectorStack []antlr.RuleContext
+
+	// NOTE: consider refactoring this simple approach for context awareness with
+	// a set.
+	// It should probably be map[string]int for rule name and current count (rules can be recursive, ie inside the same rule multiple times)
+	inAnnotation      int                             // counts number of current or ancestor contexts that are annotation rule
+	inModelAnnotation int                             // counts number of current or ancestor contexts that are model annotation rule
+	modelAnnotation   *parser.Model_annotationContext // the current model annotation
+	inGraphics        int                             // counts number of current or ancestor contexts that are Icon or Diagram annotations
+	inNamedArgument   int                             // counts number of current or ancestor contexts that are named argument
+	inVector          int                             // counts number of current or ancestor contexts that are vector
+	inSubscript       int                             // counts number of current or ancestor contexts that are array subscripts
+	inWhen            int                             // counts number of current or ancestor contexts that are when equations or statements
+	inCondition       int                             // counts number of current or ancestor contexts that are conditions of clauses
+	inTypeAlias       int                             // counts number of current or ancestor contexts that are short type definitions
+	description       *parser.String_commentContext   // the current string comment, nil outside of one
+	comprehensions    []hangingRule                   // stack of the array constructors and reductions with iterators being written
+	operatorChains    []hangingRule                   // stack of the operator chains being written, see Options.OperatorWrap
+	chainBreak        int                             // index in operatorChains of the chain to break before the next token, -1 if none
+	conditionBroken   bool                            // true if the condition of the current clause is broken, see hangChain
+}
+
+func newListener(ctx context.Context, renderer Renderer, commentTokens []antlr.Token, opts Options) *modelicaListener {
+	l := &modelicaListener{
+		BaseModelicaListener:     &parser.BaseModelicaListener{},
+		constrainingClauseBreaks: map[*parser.Constraining_clauseContext]bool{},
+		wrappedLists:             map[antlr.ParserRuleContext]bool{},
+		commentedArguments:       map[*parser.ArgumentContext]bool{},
+		alignments:               map[antlr.TerminalNode]int{},
+		alignedLists:             map[antlr.ParserRuleContext]bool{},
+		importSlots:              map[*parser.ElementContext]*parser.ElementContext{},
+	}
+	l.reset(ctx, renderer, commentTokens, opts)
+	return l
+}
+
+// reset prepares the listener for walking another tree, reusing the memory
+// allocated for its stacks
+func (l *modelicaListener) reset(ctx context.Context, renderer Renderer, commentTokens []antlr.Token, opts Options) {
+	for clause := range l.constrainingClauseBreaks {
+		delete(l.constrainingClauseBreaks, clause)
+	}
+	for list := range l.wrappedLists {
+		delete(l.wrappedLists, list)
+	}
+	for argument := range l.commentedArguments {
+		delete(l.commentedArguments, argument)
+	}
+	for terminal := range l.alignments {
+		delete(l.alignments, terminal)
+	}
+	for list := range l.alignedLists {
+		delete(l.alignedLists, list)
+	}
+	for element := range l.importSlots {
+		delete(l.importSlots, element)
+	}
+	*l = modelicaListener{
+		BaseModelicaListener:       l.BaseModelicaListener,
+		ctx:                        ctx,
+		opts:                       opts,
+		renderer:                   renderer,
+		indentationStack:           l.indentationStack[:0],
+		onNewLine:                  true,
+		previousTokenIdx:           -1,
+		commentTokens:              commentTokens,
+		emitRange:                  antlr.Interval{Start: 0, Stop: math.MaxInt32},
+		verbatimRange:              antlr.Interval{Start: -1, Stop: -1},
+		region:                     antlr.Interval{Start: -1, Stop: -1},
+		ignoreStop:                 -1,
+		outputPosition:             Position{Line: 1, Column: 1},
+		declarationLines:           l.declarationLines[:0],
+		classLines:                 l.classLines[:0],
+		constrainingClauseBreaks:   l.constrainingClauseBreaks,
+		wrappedLists:               l.wrappedLists,
+		commentedArguments:         l.commentedArguments,
+		alignments:                 l.alignments,
+		alignedLists:               l.alignedLists,
+		importSlots:                l.importSlots,
+		modelAnnotationVectorStack: l.modelAnnotationVectorStack[:0],
+		comprehensions:             l.comprehensions[:0],
+		operatorChains:             l.operatorChains[:0],
+		chainBreak:                 -1,
+	}
+}
+
+// lineWidth returns the width of what has been written on the current output
+// line, where each tab indenting it is as wide as an indentation level
+func (l *modelicaListener) lineWidth() int {
+	return l.outputPosition.Column - 1 + l.lineTabs*(l.opts.Indent-1)
+}
+
+// indentation returns the writer's current number of *rendered* indentations
+func (l *modelicaListener) indentation() int {
+	nRenderIndents := 0
+	for _, indentType := range l.indentationStack {
+		if indentType == renderIndent {
+			nRenderIndents++
+		}
+	}
+
+	return nRenderIndents
+}
+
+// maybeIndent should be called when the writer's indentation is to be increased
+func (l *modelicaListener) maybeIndent() {
+	// Only increase indentation if it hasn't been changed already, otherwise ignore it
+	// NOTE: This means that there can be at most 1 increase in indentation per line
+	// This is a bit of a hack to avoid having an overindented line, occurring when
+	// multiple rules want to be indented and we want it to be indented only once
+
+	if !l.lineIndentIncreased {
+		l.indentationStack = append(l.indentationStack, renderIndent)
+
+		// WARNING: this is coupled with writeNewline, which should reset
+		// lineIndentIncreased to false
+		l.lineIndentIncreased = true
+	} else {
+		l.indentationStack = append(l.indentationStack, ignoreIndent)
+	}
+}
+
+// maybeDedent should be called when the writer's indentation is to be decreased
+func (l *modelicaListener) maybeDedent() {
+	l.indentationStack = l.indentationStack[:len(l.indentationStack)-1]
+}
+
+// writeToken renders a token unless output is muted. The line endings of
+// tokens spanning several lines, such as block comments, are normalized to
+// "\n" like the newlines written between tokens.
+func (l *modelicaListener) writeToken(kind TokenKind, text string) {
+	if l.muted {
+		return
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	l.renderer.Token(kind, text)
+	l.outputPosition = advancePosition(l.outputPosition, text)
+}
+
+// recordPosition records that token is written at the current output position
+func (l *modelicaListener) recordPosition(token antlr.Token) {
+	if l.positions == nil || l.muted {
+		return
+	}
+	l.positions.add(Position{Line: token.GetLine(), Column: token.GetColumn() + 1}, l.outputPosition, token.GetText())
+}
+
+func (l *modelicaListener) writeNewline() {
+	if !l.muted {
+		l.renderer.Newline()
+		l.outputPosition = Position{Line: l.outputPosition.Line + 1, Column: 1}
+		l.lineTabs = 0
+	}
+	l.onNewLine = true
+
+	// WARNING: this is coupled with maybeIndent, which uses this state
+	l.lineIndentIncreased = false
+}
+
+func (l *modelicaListener) writeComment(comment antlr.Token) {
+	if l.reflowComment(comment) {
+		return
+	}
+	// the lines of a block comment starting a line follow its indentation
+	reindent := l.onNewLine && comment.GetTokenType() == parser.ModelicaLexerCOMMENT
+	l.writeSpaceBefore(comment, noOperator)
+	text := expandIndentation(trimTrailingSpace(comment.GetText()), l.opts.tabWidth())
+	if l.opts.NormalizeComments && comment.GetTokenType() == parser.ModelicaLexerLINE_COMMENT {
+		text = normalizeLineComment(text)
+	}
+	shift := l.lineWidth() - l.sourceColumn(comment)
+	if reindent {
+		text = reindentBlockComment(text, shift)
+	}
+	if l.opts.UseTabs {
+		text = tabifyIndentation(text, l.opts.Indent)
+	}
+	if reindent && shift != 0 {
+		l.recordLines(comment, text)
+	} else {
+		l.recordPosition(comment)
+	}
+	l.writeToken(Comment, text)
+	l.followSource(comment)
+	if comment.GetTokenType() == parser.ModelicaLexerLINE_COMMENT {
+		l.writeNewline()
+		if !l.muted {
+			l.writeDirective(comment)
+		}
+		return
+	}
+	// a token following a block comment on the same line is spaced as if it
+	// followed a name
+	l.previousTokenText = comment.GetText()
+	l.spaceAfterPrevious = false
+}
+
+// sourceColumn returns the 0-based column of the token in the source, with
+// the tabs indenting its line as wide as Options.TabWidth
+func (l *modelicaListener) sourceColumn(token antlr.Token) int {
+	column := token.GetColumn()
+	if indent, ok := l.indents[token.GetLine()]; ok && column >= len(indent) {
+		column += whitespaceWidth(indent, l.opts.tabWidth()) - len(indent)
+	}
+	return column
+}
+
+// normalizeLineComment separates the text of a line comment from its `//` by
+// one space, as in `// comment`, see Options.NormalizeComments. Comments
+// starting with punctuation, such as `//!` or `//----` banners, are left
+// alone, and so is text indented by several spaces.
+func normalizeLineComment(text string) string {
+	body := strings.TrimPrefix(text, "//")
+	trimmed := strings.TrimLeft(body, " \t")
+	first, _ := utf8.DecodeRuneInString(trimmed)
+	if trimmed == "" || strings.HasPrefix(body, "  ") ||
+		trimmed == body && !unicode.IsLetter(first) && !unicode.IsDigit(first) {
+		return text
+	}
+	return "// " + trimmed
+}
+
+// sectionKeywords are the keywords starting the sections of a class
+var sectionKeywords = tokenSet([]string{"public", "protected", "equation", "algorithm"})
+
+// followSource records where the token just written ends in the source
+func (l *modelicaListener) followSource(token antlr.Token) {
+	if l.muted {
+		return
+	}
+	text := token.GetText()
+	l.sourceLine = token.GetLine() + strings.Count(text, "\n")
+	l.afterStatement = text == ";" || sectionKeywords[text] || isComment(token)
+}
+
+// writeBlankLines writes the blank lines separating the token from the last
+// written token in the source, up to Options.MaxBlankLines. Blank lines are
+// only kept between statements, sections and comments, hence they are removed
+// at the start of class bodies, after equation and algorithm headers, and
+// before `end`, unless the class is long, see separateEnd. With
+// Options.PreserveBlankLines they are also kept between the items of lists
+// written one item per line. Where the next construct is to be separated, see
+// separateBefore, and after the within clause, see
+// Options.BlankLineAfterWithin, there is exactly one.
+func (l *modelicaListener) writeBlankLines(token antlr.Token) {
+	betweenItems := l.opts.PreserveBlankLines && l.previousTokenText == ","
+	if l.muted || l.sourceLine == 0 || !(l.afterStatement || betweenItems) {
+		return
+	}
+	blankLines := token.GetLine() - l.sourceLine - 1
+	if !isComment(token) {
+		blankLines -= l.importShift
+	}
+	if blankLines > l.opts.MaxBlankLines {
+		blankLines = l.opts.MaxBlankLines
+	}
+	if token.GetText() == "end" || l.previousTokenText == "equation" || l.previousTokenText == "algorithm" {
+		blankLines = 0
+	}
+	if l.separate {
+		// the blank line goes before the comments preceding the section
+		blankLines = 1
+		l.separate = false
+	}
+	for i := 0; i < blankLines; i++ {
+		l.renderer.Newline()
+		l.outputPosition = Position{Line: l.outputPosition.Line + 1, Column: 1}
+	}
+}
+
+// separateBefore returns true if the rule is preceded by exactly one blank
+// line, which is the case of sections and class annotations and of class
+// definitions following another class definition. There is no blank line at
+// the start of a class body, see writeBlankLines.
+func separateBefore(rule antlr.ParserRuleContext) bool {
+	switch rule := rule.(type) {
+	case *parser.Equation_sectionContext, *parser.Algorithm_sectionContext, *parser.Model_annotationContext:
+		return true
+	case *parser.ElementContext:
+		return isLongClass(rule.Class_definition()) && previousSibling(rule, func(sibling antlr.Tree) bool {
+			element, ok := sibling.(*parser.ElementContext)
+			return ok && isLongClass(element.Class_definition())
+		})
+	case *parser.Class_definitionContext:
+		_, topLevel := rule.GetParent().(*parser.Stored_definitionContext)
+		return topLevel && isLongClass(rule) && previousSibling(rule, func(sibling antlr.Tree) bool {
+			definition, ok := sibling.(*parser.Class_definitionContext)
+			return ok && isLongClass(definition)
+		})
+	}
+	return false
+}
+
+// isLongClass returns true if the class definition has a body, unlike short
+// class definitions such as `package Medium = Buildings.Media.Water`
+func isLongClass(definition parser.IClass_definitionContext) bool {
+	if definition == nil {
+		return false
+	}
+	specifier := definition.(*parser.Class_definitionContext).Class_specifier()
+	_, ok := specifier.GetChild(0).(*parser.Long_class_specifierContext)
+	return ok
+}
+
+// previousSibling returns true if the closest sibling before the rule which
+// is not a terminal matches
+func previousSibling(rule antlr.ParserRuleContext, matches func(antlr.Tree) bool) bool {
+	var previous antlr.Tree
+	for _, sibling := range rule.GetParent().GetChildren() {
+		if sibling == rule {
+			break
+		}
+		if _, ok := sibling.(antlr.TerminalNode); !ok {
+			previous = sibling
+		}
+	}
+	return previous != nil && matches(previous)
+}
+
+// isSectionKeyword returns true if the terminal starts a public or protected
+// section of a class
+func isSectionKeyword(node antlr.TerminalNode) bool {
+	text := node.GetText()
+	return (text == "public" || text == "protected") && terminalRuleIndex(node) == parser.ModelicaParserRULE_composition
+}
+
+// visibilityPadding returns the number of spaces written before a public or
+// protected keyword to half-dedent it, see Options.HalfDedentVisibility
+func (l *modelicaListener) visibilityPadding(node antlr.TerminalNode) int {
+	if !l.opts.HalfDedentVisibility || l.opts.UseTabs || !isSectionKeyword(node) {
+		return 0
+	}
+	return l.opts.Indent / 2
+}
+
+// separateEnd returns true if the terminal is the end of a class spanning at
+// least Options.EndBlankLineAfter lines, which is preceded by a blank line
+func (l *modelicaListener) separateEnd(node antlr.TerminalNode) bool {
+	if l.opts.EndBlankLineAfter == 0 || node.GetText() != "end" || terminalRuleIndex(node) != parser.ModelicaParserRULE_long_class_specifier {
+		return false
+	}
+	return l.outputPosition.Line-l.classLines[len(l.classLines)-1]+1 >= l.opts.EndBlankLineAfter
+}
+
+// dropped returns true if the terminal is left out of the output, which is the
+// case of the brackets of empty modifications outside annotations, see
+// Options.DropEmptyModifications
+func (l *modelicaListener) dropped(node antlr.TerminalNode) bool {
+	if !l.opts.DropEmptyModifications || 0 < l.inAnnotation || terminalRuleIndex(node) != parser.ModelicaParserRULE_class_modification {
+		return false
+	}
+	// the brackets alone, without an argument list; once the comments are
+	// written, the `)` follows the fate of the `(`
+	modification := node.GetParent().(antlr.ParserRuleContext)
+	return modification.GetChildCount() == 2 && !l.commentWithin(modification) &&
+		l.previousTokenIdx < modification.GetStart().GetTokenIndex()
+}
+
+// startsLine returns true if the terminal starts a branch or the end of an
+// if, when, for or while equation or statement, or is the end of a class.
+// These keywords start their own line, even after an empty branch or
+// section, unlike the `elseif` and `else` of if-expressions.
+func startsLine(node antlr.TerminalNode) bool {
+	switch node.GetText() {
+	case "elseif", "else", "elsewhen", "end":
+	default:
+		return false
+	}
+	switch terminalRuleIndex(node) {
+	case parser.ModelicaParserRULE_long_class_specifier:
+		return node.GetText() == "end"
+	case parser.ModelicaParserRULE_if_equation, parser.ModelicaParserRULE_if_statement,
+		parser.ModelicaParserRULE_when_equation, parser.ModelicaParserRULE_when_statement,
+		parser.ModelicaParserRULE_for_equation, parser.ModelicaParserRULE_for_statement,
+		parser.ModelicaParserRULE_while_statement:
+		return true
+	}
+	return false
+}
+
+// emitted returns true if the token is written rather than muted
+func (l *modelicaListener) emitted(token antlr.Token) bool {
+	return token.GetTokenIndex() >= l.emitRange.Start && token.GetTokenIndex() <= l.emitRange.Stop
+}
+
+// trimTrailingSpace removes the spaces and tabs ending each line of text
+func trimTrailingSpace(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeSpaceBefore writes the indentation of the token if it starts a line,
+// or else the space separating it from the previous token, if any. role is
+// that of the token if it is an operator, see operatorRole.
+func (l *modelicaListener) writeSpaceBefore(token antlr.Token, role operatorRole) {
+	if l.onNewLine {
+		l.writeBlankLines(token)
+		// insert indentation
+		if level := l.indentation(); level > 0 && !l.muted {
+			indentation := l.opts.indentation(level)
+			l.renderer.Indent(indentation)
+			l.outputPosition = advancePosition(l.outputPosition, indentation)
+			if l.opts.UseTabs {
+				l.lineTabs = level
+			}
+		}
+		l.onNewLine = false
+	} else if l.spaceBefore(token, role) {
+		l.writeSpace()
+	}
+}
+
+// writeSpace writes a space unless output is muted
+func (l *modelicaListener) writeSpace() {
+	if !l.muted {
+		l.renderer.Space()
+		l.outputPosition.Column++
+	}
+}
+
+func (l *modelicaListener) VisitTerminal(node antlr.TerminalNode) {
+	if l.sourcePosition != nil {
+		*l.sourcePosition = Position{Line: node.GetSymbol().GetLine(), Column: node.GetSymbol().GetColumn() + 1}
+	}
+	tokenIdx := node.GetSymbol().GetTokenIndex()
+	if tokenIdx >= l.verbatimRange.Start && tokenIdx <= l.verbatimRange.Stop || l.inRegion(tokenIdx) {
+		// already written by writeVerbatim or writeRegion
+		return
+	}
+	muted := tokenIdx < l.emitRange.Start || tokenIdx > l.emitRange.Stop
+	if l.muted && !muted {
+		// the output written before the emitted range ends with the newline
+		// following a ';'
+		l.onNewLine = true
+		l.lineIndentIncreased = false
+		l.previousTokenText = ";"
+	}
+	l.muted = muted
+	if (l.opts.SeparateSections && isSectionKeyword(node) || l.separateEnd(node)) && !muted {
+		l.separate = true
+	}
+	if l.inWithin && l.afterStatement && node.GetText() != "within" {
+		// exactly one blank line follows the within clause
+		l.separate = !muted && l.opts.BlankLineAfterWithin
+		l.inWithin = false
+	}
+	if node.GetText() == "within" {
+		l.inWithin = true
+	}
+
+	// if there's a comment that should go before this node, insert it first.
+	// Comments ending a body, before its `end`, are indented like the body.
+	if node.GetText() == "end" && startsLine(node) {
+		l.indentationStack = append(l.indentationStack, renderIndent)
+		l.writeCommentsBefore(tokenIdx)
+		l.maybeDedent()
+	} else {
+		l.writeCommentsBefore(tokenIdx)
+	}
+	if l.inRegion(tokenIdx) {
+		// the comments started a region, see writeRegion
+		return
+	}
+
+	if l.dropped(node) {
+		return
+	}
+
+	if node.GetText() == "," && terminalRuleIndex(node) == parser.ModelicaParserRULE_component_list && l.splitDeclarations(node.GetParent()) {
+		l.splitDeclaration(node)
+		return
+	}
+
+	if l.breakBeforeString(node) || (l.closesWrappedVector(node) || startsLine(node)) && !l.onNewLine {
+		l.writeNewline()
+	}
+	if l.breakBeforeFor(node) {
+		l.hang(&l.comprehensions[len(l.comprehensions)-1])
+	}
+	if l.breakBeforeOperator(node) {
+		l.hangChain(len(l.operatorChains) - 1)
+	}
+	if l.breakBeforeKeyword(node) && !l.onNewLine {
+		l.writeNewline()
+	}
+	if !continuesName(node) || l.onNewLine {
+		l.writeSpaceBefore(node.GetSymbol(), roleOf(node))
+	}
+	if l.splitsDescription(node) {
+		// decided once the line of the first string is indented
+		l.wrapped(l.description)
+	}
+	if n := len(l.declarationLines); n > 0 && l.declarationLines[n-1] == 0 {
+		l.declarationLines[n-1] = l.outputPosition.Line
+	}
+	for i := l.alignmentPadding(node) + l.visibilityPadding(node); i > 0; i-- {
+		l.writeSpace()
+	}
+
+	l.recordPosition(node.GetSymbol())
+	if pieces := l.splitDescription(node); len(pieces) > 1 {
+		l.writePieces(node.GetSymbol(), pieces)
+	} else {
+		l.writeToken(tokenKind(node.GetSymbol()), node.GetText())
+	}
+	l.followSource(node.GetSymbol())
+	l.separate = false
+	l.importShift = 0
+	if l.breakAfterOperator(node) {
+		l.chainBreak = len(l.operatorChains) - 1
+	}
+
+	l.previousTokenText = node.GetText()
+	l.previousTokenIdx = node.GetSymbol().GetTokenIndex()
+	l.spaceAfterPrevious = l.insertSpaceAfterTerminal(node)
+
+	if node.GetText() == ";" {
+		if !l.muted {
+			l.writeTrailingComments(node.GetSymbol())
+		}
+		if !l.onNewLine {
+			l.writeNewline()
+		}
+	}
+}
+
+// writeTrailingComments writes the comments directly following the token on
+// its source line, such as `x=1; // comment`, so that they stay on its line
+func (l *modelicaListener) writeTrailingComments(token antlr.Token) {
+	for len(l.commentTokens) > 0 && l.commentTokens[0].GetLine() == token.GetLine() {
+		comment := l.commentTokens[0]
+		// only whitespace separates the comment from the token
+		if gap := comment.GetTokenIndex() - token.GetTokenIndex(); gap < 1 || gap > 2 {
+			return
+		}
+		l.commentTokens = l.commentTokens[1:]
+		l.spaceAfterPrevious = true
+		l.writeComment(comment)
+		token = comment
+	}
+}
+
+// writeCommentsBefore writes the comments preceding the token at tokenIdx
+// which follow the previous token
+func (l *modelicaListener) writeCommentsBefore(tokenIdx int) {
+	for len(l.commentTokens) > 0 && tokenIdx > l.commentTokens[0].GetTokenIndex() && l.commentTokens[0].GetTokenIndex() > l.previousTokenIdx {
+		commentToken := l.commentTokens[0]
+		l.commentTokens = l.commentTokens[1:]
+		l.writeComment(commentToken)
+	}
+}
+
+// verbatim returns true if the rule is written exactly as it is in the
+// source, see Options.Graphics, Options.PreserveVendorAnnotations and
+// Options.SyntaxErrors
+func (l *modelicaListener) verbatim(rule antlr.ParserRuleContext) bool {
+	if l.broken[rule] {
+		return true
+	}
+	argument, ok := rule.(*parser.ArgumentContext)
+	if !ok || l.inAnnotation == 0 {
+		return false
+	}
+	return l.opts.Graphics == graphicsPreserve && isGraphics(argument) ||
+		l.opts.PreserveVendorAnnotations && strings.HasPrefix(argumentName(argument), "__")
+}
+
+// splitsDescription returns true if the terminal is a string of a description
+// which may be split, see Options.SplitDescriptions
+func (l *modelicaListener) splitsDescription(node antlr.TerminalNode) bool {
+	return l.opts.SplitDescriptions && l.opts.MaxLineLength > 0 && 0 == l.inAnnotation &&
+		l.description != nil && node.GetSymbol().GetTokenType() == parser.ModelicaLexerSTRING
+}
+
+// breakBeforeString returns true if the string is put on a new line because
+// it is concatenated to the previous strings of a description which does not
+// fit on one line, see Options.SplitDescriptions
+func (l *modelicaListener) breakBeforeString(node antlr.TerminalNode) bool {
+	return l.splitsDescription(node) && l.description.GetChild(0) != node && l.wrapped(l.description)
+}
+
+// splitDescription returns the strings a description string is split into
+// to fit within Options.MaxLineLength, see Options.SplitDescriptions. Each
+// string but the last ends with a space. Strings are only split at spaces,
+// a single word longer than a line is left as it is.
+func (l *modelicaListener) splitDescription(node antlr.TerminalNode) []string {
+	text := node.GetText()
+	if !l.splitsDescription(node) || strings.Contains(text, "\n") {
+		return nil
+	}
+	// the quotes and the `+` ending each line
+	width := l.opts.MaxLineLength - l.lineWidth() - 3
+	if utf8.RuneCountInString(text) <= width+1 {
+		return nil
+	}
+
+	var pieces []string
+	piece := ""
+	for _, word := range strings.SplitAfter(text[1:len(text)-1], " ") {
+		if piece != "" && utf8.RuneCountInString(piece+word) > width {
+			pieces = append(pieces, `"`+piece+`"`)
+			piece = ""
+		}
+		piece += word
+	}
+	return append(pieces, `"`+piece+`"`)
+}
+
+// writePieces writes the strings a description string is split into, joined
+// by `+` at the end of each line. The strings are aligned with the first one.
+func (l *modelicaListener) writePieces(token antlr.Token, pieces []string) {
+	for i, piece := range pieces {
+		if i > 0 {
+			if l.spaceBetween(pieces[i-1], false, "+", noOperator) {
+				l.writeSpace()
+			}
+			l.writeToken(Operator, "+")
+			l.writeNewline()
+			l.writeSpaceBefore(token, noOperator)
+		}
+		l.writeToken(String, piece)
+	}
+}
+
+// verbatimText returns the source text of the rule, including its whitespace
+// and comments
+func verbatimText(rule antlr.ParserRuleContext) string {
+	stream := rule.(interface{ GetParser() antlr.Parser }).GetParser().GetTokenStream()
+	return stream.GetTextFromTokens(rule.GetStart(), rule.GetStop())
+}
+
+// writeVerbatim writes the source text of the rule, including its whitespace
+// and comments, as a single token. The tokens of the rule are skipped while
+// walking it.
+func (l *modelicaListener) writeVerbatim(rule antlr.ParserRuleContext) {
+	start, stop := rule.GetStart(), rule.GetStop()
+	l.writeCommentsBefore(start.GetTokenIndex())
+	for len(l.commentTokens) > 0 && l.commentTokens[0].GetTokenIndex() < stop.GetTokenIndex() {
+		l.commentTokens = l.commentTokens[1:]
+	}
+
+	text := verbatimText(rule)
+	if l.broken[rule] {
+		text = sourceText(rule)
+	}
+	text = normalizeIndentation(text, l.opts)
+	l.writeSpaceBefore(start, noOperator)
+	l.recordPosition(start)
+	l.writeToken(tokenKind(start), text)
+	l.followSource(stop)
+
+	l.previousTokenText = stop.GetText()
+	l.previousTokenIdx = stop.GetTokenIndex()
+	l.spaceAfterPrevious = false
+	l.verbatimRange = antlr.Interval{Start: start.GetTokenIndex(), Stop: stop.GetTokenIndex()}
+}
+
+// inVerbatim returns true if the rule is within the rule being written
+// verbatim, excluding that rule itself
+func (l *modelicaListener) inVerbatim(rule antlr.ParserRuleContext) bool {
+	if l.verbatimRange.Start < 0 {
+		return false
+	}
+	start, stop := rule.GetStart().GetTokenIndex(), rule.GetStop().GetTokenIndex()
+	return start >= l.verbatimRange.Start && stop <= l.verbatimRange.Stop &&
+		(start != l.verbatimRange.Start || stop != l.verbatimRange.Stop || !l.verbatim(rule))
+}
+
+// isGraphics returns true if the argument of an annotation is an Icon or
+// Diagram annotation
+func isGraphics(argument *parser.ArgumentContext) bool {
+	name := argumentName(argument)
+	return name == "Icon" || name == "Diagram"
+}
+
+// argumentName returns the name modified by the argument, e.g. "Placement" in
+// `Placement(transformation(...))`, or "" if it is not an element modification
+func argumentName(argument *parser.ArgumentContext) string {
+	wrapper, ok := argument.Element_modification_or_replaceable().(*parser.Element_modification_or_replaceableContext)
+	if !ok {
+		return ""
+	}
+	modification, ok := wrapper.Element_modification().(*parser.Element_modificationContext)
+	if !ok {
+		return ""
+	}
+	return modification.Name().GetText()
+}
+
+// splitDeclaration replaces the comma separating two declarations of a
+// component list by the end of the element, then starts a new element for the
+// next declaration by walking the prefixes and type of the component clause
+// again
+func (l *modelicaListener) splitDeclaration(comma antlr.TerminalNode) {
+	l.writeSpaceBefore(comma.GetSymbol(), noOperator)
+	l.writeToken(Operator, ";")
+	l.writeNewline()
+	l.previousTokenText = ";"
+	l.spaceAfterPrevious = false
+
+	componentClause := comma.GetParent().GetParent().(*parser.Component_clauseContext)
+	for _, child := range componentClause.GetParent().GetChildren() {
+		if child == componentClause {
+			break
+		}
+		antlr.ParseTreeWalkerDefault.Walk(l, child)
+	}
+	for _, child := range componentClause.GetChildren() {
+		if _, ok := child.(*parser.Component_listContext); ok {
+			break
+		}
+		antlr.ParseTreeWalkerDefault.Walk(l, child)
+	}
+
+	l.previousTokenIdx = comma.GetSymbol().GetTokenIndex()
+}
+
+// finish writes any remaining comments and handles the newline at end of file.
+// These are the comments following the last token, such as license trailers,
+// which keep their own lines.
+func (l *modelicaListener) finish() {
+	l.muted = false
+	for len(l.commentTokens) > 0 {
+		comment := l.commentTokens[0]
+		l.commentTokens = l.commentTokens[1:]
+		if !l.onNewLine && comment.GetLine() > l.sourceLine {
+			l.writeNewline()
+		}
+		l.writeComment(comment)
+	}
+	if !l.onNewLine {
+		l.writeNewline()
+	}
+}
+
+func (l *modelicaListener) EnterEveryRule(node antlr.ParserRuleContext) {
+	checkCanceled(l.ctx)
+	if l.inVerbatim(node) || l.regionRule(node) {
+		return
+	}
+
+	if l.chainBreak >= 0 {
+		// the operand following an operator ending a line
+		l.hangChain(l.chainBreak)
+		l.chainBreak = -1
+	}
+
+	if l.opts.SeparateSections && separateBefore(node) && l.emitted(node.GetStart()) {
+		l.separate = true
+	}
+
+	if l.newlineBefore(node) && !l.onNewLine {
+		l.writeNewline()
+	}
+
+	if l.indentBefore(node) {
+		if !l.onNewLine {
+			l.writeNewline()
+		}
+		l.maybeIndent()
+	}
+
+	if l.verbatim(node) && l.emitted(node.GetStart()) {
+		l.writeVerbatim(node)
+	}
+
+	if l.breakableChain(node) {
+		l.enterChain(node)
+	}
+}
+
+func (l *modelicaListener) ExitEveryRule(node antlr.ParserRuleContext) {
+	if l.inVerbatim(node) || l.regionRule(node) {
+		return
+	}
+	if l.verbatimRange.Start >= 0 && l.verbatim(node) {
+		l.verbatimRange = antlr.Interval{Start: -1, Stop: -1}
+	}
+	if l.indentBefore(node) {
+		l.maybeDedent()
+	}
+
+	if l.breakableChain(node) {
+		l.exitChain()
+	}
+}
+
+func (l *modelicaListener) EnterAnnotation(node *parser.AnnotationContext) {
+	l.inAnnotation++
+}
+
+func (l *modelicaListener) ExitAnnotation(node *parser.AnnotationContext) {
+	l.inAnnotation--
+}
+
+func (l *modelicaListener) EnterModel_annotation(node *parser.Model_annotationContext) {
+	l.inModelAnnotation++
+	l.modelAnnotation = node
+}
+
+func (l *modelicaListener) ExitModel_annotation(node *parser.Model_annotationContext) {
+	l.inModelAnnotation--
+}
+
+func (l *modelicaListener) EnterString_comment(node *parser.String_commentContext) {
+	l.description = node
+}
+
+func (l *modelicaListener) ExitString_comment(node *parser.String_commentContext) {
+	l.description = nil
+}
+
+func (l *modelicaListener) EnterArgument(node *parser.ArgumentContext) {
+	if l.inAnnotation > 0 && isGraphics(node) {
+		l.inGraphics++
+	}
+	if l.opts.AlignArguments {
+		l.alignArguments(wrappableList(node))
+	}
+}
+
+func (l *modelicaListener) ExitArgument(node *parser.ArgumentContext) {
+	if l.inAnnotation > 0 && isGraphics(node) {
+		l.inGraphics--
+	}
+}
+
+func (l *modelicaListener) EnterPrimary(node *parser.PrimaryContext) {
+	if l.opts.AlignMatrices {
+		l.alignMatrix(matrixRows(node))
+	}
+}
+
+func (l *modelicaListener) EnterVector(node *parser.VectorContext) {
+	l.inVector++
+	if l.opts.AlignMatrices && 0 == l.inAnnotation {
+		l.alignMatrix(vectorRows(node))
+	}
+	if l.expandModelAnnotation() {
+		// if this array uses an iterator for construction it gets no special treatment
+		if _, ok := node.GetChild(0).(parser.Array_iterator_constructorContext); ok {
+			return
+		}
+
+		// check if there is an element of this vector which would require indentation
+		for _, child := range node.Array_arguments().GetChildren() {
+			expressionNode, ok := child.(*parser.ExpressionContext)
+			if !ok {
+				continue
+			}
+			startToken := expressionNode.GetStart()
+			if startToken.GetTokenType() == parser.ModelicaLexerIDENT {
+				l.modelAnnotationVectorStack = append(l.modelAnnotationVectorStack, node)
+				break
+			}
+		}
+	}
+}
+
+func (l *modelicaListener) ExitVector(node *parser.VectorContext) {
+	l.inVector--
+	if len(l.modelAnnotationVectorStack) > 0 {
+		annotationVectorInterval := l.modelAnnotationVectorStack[len(l.modelAnnotationVectorStack)-1].GetSourceInterval()
+		thisVectorInterval := node.GetSourceInterval()
+		if annotationVectorInterval.Start == thisVectorInterval.Start && annotationVectorInterval.Stop == thisVectorInterval.Stop {
+			l.modelAnnotationVectorStack = l.modelAnnotationVectorStack[:len(l.modelAnnotationVectorStack)-1]
+		}
+	}
+}
+
+func (l *modelicaListener) EnterArray_iterator_constructor(node *parser.Array_iterator_constructorContext) {
+	l.enterComprehension(node)
+}
+
+func (l *modelicaListener) ExitArray_iterator_constructor(node *parser.Array_iterator_constructorContext) {
+	l.exitComprehension(node)
+}
+
+func (l *modelicaListener) EnterFunction_arguments(node *parser.Function_argumentsContext) {
+	if iteratorFor(node) != nil {
+		l.enterComprehension(node)
+	}
+}
+
+func (l *modelicaListener) ExitFunction_arguments(node *parser.Function_argumentsContext) {
+	if iteratorFor(node) != nil {
+		l.exitComprehension(node)
+	}
+}
+
+// enterComprehension decides whether a comprehension is wrapped while the
+// output is at its start, see wrappedComprehension
+func (l *modelicaListener) enterComprehension(node antlr.ParserRuleContext) {
+	l.comprehensions = append(l.comprehensions, hangingRule{rule: node})
+	l.wrappedComprehension(node)
+}
+
+// exitComprehension removes the indentation of the 'for' of a wrapped
+// comprehension, see breakBeforeFor
+func (l *modelicaListener) exitComprehension(node antlr.ParserRuleContext) {
+	if l.comprehensions[len(l.comprehensions)-1].indents > 0 {
+		l.maybeDedent()
+	}
+	l.comprehensions = l.comprehensions[:len(l.comprehensions)-1]
+}
+
+// enterDeclaration records the line on which a declaration which may have a
+// constraining clause starts. The line is only known once the first token of
+// the declaration is written, after the comments and blank lines preceding it.
+func (l *modelicaListener) enterDeclaration() {
+	l.declarationLines = append(l.declarationLines, 0)
+}
+
+func (l *modelicaListener) exitDeclaration() {
+	l.declarationLines = l.declarationLines[:len(l.declarationLines)-1]
+}
+
+func (l *modelicaListener) EnterStored_definition(node *parser.Stored_definitionContext) {
+	l.tokens = node.GetParser().GetTokenStream()
+	if l.opts.DropEmptyAnnotations {
+		l.dropEmptyAnnotations(node, false)
+	}
+}
+
+func (l *modelicaListener) EnterElement_list(node *parser.Element_listContext) {
+	if l.opts.SortImports {
+		l.sortImports(node)
+	}
+}
+
+func (l *modelicaListener) EnterElement(node *parser.ElementContext) {
+	l.ignoreStatement(node)
+	l.enterImport(node)
+	l.enterDeclaration()
+	if l.opts.AlignParameters || l.opts.AlignDescriptions || l.opts.AlignDeclarations {
+		l.alignElements(node.GetParent().(*parser.Element_listContext))
+	}
+}
+
+func (l *modelicaListener) ExitElement(node *parser.ElementContext) {
+	l.exitDeclaration()
+}
+
+func (l *modelicaListener) EnterLong_class_specifier(node *parser.Long_class_specifierContext) {
+	l.classLines = append(l.classLines, l.outputPosition.Line)
+}
+
+func (l *modelicaListener) ExitLong_class_specifier(node *parser.Long_class_specifierContext) {
+	l.classLines = l.classLines[:len(l.classLines)-1]
+}
+
+func (l *modelicaListener) EnterElement_replaceable(node *parser.Element_replaceableContext) {
+	l.enterDeclaration()
+}
+
+func (l *modelicaListener) ExitElement_replaceable(node *parser.Element_replaceableContext) {
+	l.exitDeclaration()
+}
+
+func (l *modelicaListener) EnterArray_subscripts(node *parser.Array_subscriptsContext) {
+	l.inSubscript++
+}
+
+func (l *modelicaListener) ExitArray_subscripts(node *parser.Array_subscriptsContext) {
+	l.inSubscript--
+}
+
+func (l *modelicaListener) EnterEquation(node *parser.EquationContext) {
+	l.ignoreStatement(node)
+}
+
+func (l *modelicaListener) EnterStatement(node *parser.StatementContext) {
+	l.ignoreStatement(node)
+	if l.opts.AlignAssignments {
+		l.alignAssignments(node.GetParent().(antlr.ParserRuleContext))
+	}
+}
+
+func (l *modelicaListener) EnterShort_class_specifier(node *parser.Short_class_specifierContext) {
+	if typeAlias(node) {
+		l.inTypeAlias++
+	}
+}
+
+func (l *modelicaListener) ExitShort_class_specifier(node *parser.Short_class_specifierContext) {
+	if typeAlias(node) {
+		l.inTypeAlias--
+	}
+}
+
+func (l *modelicaListener) EnterWhen_equation(node *parser.When_equationContext) {
+	l.inWhen++
+}
+
+func (l *modelicaListener) ExitWhen_equation(node *parser.When_equationContext) {
+	l.inWhen--
+}
+
+func (l *modelicaListener) EnterWhen_statement(node *parser.When_statementContext) {
+	l.inWhen++
+}
+
+func (l *modelicaListener) ExitWhen_statement(node *parser.When_statementContext) {
+	l.inWhen--
+}
+
+func (l *modelicaListener) EnterExpression(node *parser.ExpressionContext) {
+	if isCondition(node) {
+		l.inCondition++
+	}
+}
+
+func (l *modelicaListener) ExitExpression(node *parser.ExpressionContext) {
+	if isCondition(node) {
+		l.inCondition--
+	}
+}
+
+func (l *modelicaListener) EnterNamed_argument(node *parser.Named_argumentContext) {
+	l.inNamedArgument++
+	if l.opts.AlignArguments {
+		l.alignArguments(wrappableList(node))
+	}
+}
+
+func (l *modelicaListener) ExitNamed_argument(node *parser.Named_argumentContext) {
+	l.inNamedArgument--
+}
+
+// commentCollector is a wrapper around the default lexer which collects comment
+// tokens for later use
+type commentCollector struct {
+	antlr.TokenSource
+	ctx           context.Context
+	commentTokens []antlr.Token
+}
+
+func newCommentCollector(ctx context.Context, source antlr.TokenSource) commentCollector {
+	return commentCollector{
+		source,
+		ctx,
+		[]antlr.Token{},
+	}
+}
+
+// isComment returns true if the token is a block or line comment
+func isComment(token antlr.Token) bool {
+	tokenType := token.GetTokenType()
+	return tokenType == parser.ModelicaLexerCOMMENT || tokenType == parser.ModelicaLexerLINE_COMMENT
+}
+
+// NextToken returns the next token from the source
+func (c *commentCollector) NextToken() antlr.Token {
+	checkCanceled(c.ctx)
+	token := c.TokenSource.NextToken()
+
+	if isComment(token) {
+		c.commentTokens = append(c.commentTokens, token)
+	}
+
+	return token
+}
+
+// canceled is used as a panic value to unwind the lexer, parser and tree
+// walker once the context is done. It is recovered in Format.
+type canceled struct {
+	err error
+}
+
+// InternalError is returned instead of panicking when formatting fails
+// because of a bug in the formatter or in the parser runtime
+type InternalError struct {
+	Value    interface{} // value passed to panic
+	Position Position    // position in the source of the last token processed, zero if unknown
+	Stack    []byte      // stack trace of the panic
+}
+
+func (e *InternalError) Error() string {
+	if e.Position.Line == 0 {
+		return fmt.Sprintf("internal error: %v", e.Value)
+	}
+	return fmt.Sprintf("internal error at %d:%d: %v", e.Position.Line, e.Position.Column, e.Value)
+}
+
+// recoverPanic is deferred at the API boundary to turn panics into errors.
+// The error of a canceled panic is stored in err, any other panic is stored as
+// an InternalError reporting position, which may be nil.
+func recoverPanic(err *error, position *Position) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if c, ok := r.(canceled); ok {
+		*err = c.err
+		return
+	}
+	internal := &InternalError{Value: r, Stack: debug.Stack()}
+	if position != nil {
+		internal.Position = *position
+	}
+	*err = internal
+}
+
+// checkCanceled panics with canceled if ctx is done
+func checkCanceled(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		panic(canceled{ctx.Err()})
+	default:
+	}
+}
+
+// contextWriter is a writer which refuses to write once the context is done
+type contextWriter struct {
+	ctx context.Context
+	out io.Writer
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.out.Write(p)
+}
+
+// automata are the ATNs and the DFA caches used by the lexer and the parser.
+// The generated lexer and parser share a single ATN and cache between all
+// their instances, which both fill lazily and are not safe for concurrent use.
+// Parsers using an automata of their own instead may run concurrently.
+type automata struct {
+	lexerATN  *antlr.ATN
+	parserATN *antlr.ATN
+	lexer     []*antlr.DFA
+	parser    []*antlr.DFA
+}
+
+// automataPool holds the automata which are not in use, so their cached
+// states are reused
+var automataPool sync.Pool
+
+// getAutomata returns unused automata from the pool, or new ones
+func getAutomata() *automata {
+	if a, ok := automataPool.Get().(*automata); ok {
+		return a
+	}
+	lexerATN, parserATN := parser.NewLexerATN(), parser.NewParserATN()
+	return &automata{
+		lexerATN:  lexerATN,
+		parserATN: parserATN,
+		lexer:     newDFAs(lexerATN),
+		parser:    newDFAs(parserATN),
+	}
+}
+
+// newDFAs returns empty DFAs for the decisions of an ATN
+func newDFAs(atn *antlr.ATN) []*antlr.DFA {
+	dfas := make([]*antlr.DFA, len(atn.DecisionToState))
+	for i, state := range atn.DecisionToState {
+		dfas[i] = antlr.NewDFA(state, i)
+	}
+	return dfas
+}
+
+// newParser creates a parser for text whose token source collects comments.
+// If errorListener is not nil it replaces the default console error listeners
+// of both the lexer and the parser. If dfas is not nil the lexer and the parser
+// use its ATNs and caches rather than the shared ones.
+func newParser(ctx context.Context, text string, errorListener antlr.ErrorListener, dfas *automata) (*parser.ModelicaParser, *commentCollector) {
+	inputStream := antlr.NewInputStream(text)
+	lexer := parser.NewModelicaLexer(inputStream)
+	if dfas != nil {
+		lexer.Interpreter = antlr.NewLexerATNSimulator(lexer, dfas.lexerATN, dfas.lexer, antlr.NewPredictionContextCache())
+	}
+
+	// wrap the default lexer to collect comments and set it as the stream's source
+	stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+	tokenSource := newCommentCollector(ctx, lexer)
+	stream.SetTokenSource(&tokenSource)
+
+	p := parser.NewModelicaParser(stream)
+	if dfas != nil {
+		p.Interpreter = antlr.NewParserATNSimulator(p, dfas.parserATN, dfas.parser, antlr.NewPredictionContextCache())
+	}
+	if errorListener != nil {
+		lexer.RemoveErrorListeners()
+		lexer.AddErrorListener(errorListener)
+		p.RemoveErrorListeners()
+		p.AddErrorListener(errorListener)
+	}
+
+	return p, &tokenSource
+}
+
+// Format formats the Modelica source read from in and writes the result to out.
+// Lexing, parsing, walking the tree and writing are all aborted once ctx is
+// done, in which case the context's error is returned.
+func Format(ctx context.Context, in io.Reader, out io.Writer, opts Options) error {
+	f, err := NewFormatter(opts)
+	if err != nil {
+		return err
+	}
+	return f.Format(ctx, in, out)
+}
+
+// Render formats the Modelica source read from in like Format, but passes the
+// result to renderer instead of writing Modelica source. The line endings and
+// encoding options do not apply, they are up to the renderer.
+func Render(ctx context.Context, in io.Reader, renderer Renderer, opts Options) error {
+	f, err := NewFormatter(opts)
+	if err != nil {
+		return err
+	}
+	return f.Render(ctx, in, renderer)
+}
+
+// FormatWithPositions formats like Format and also returns a map between the
+// positions of the source and the positions of the formatted output
+func FormatWithPositions(ctx context.Context, in io.Reader, out io.Writer, opts Options) (*PositionMap, error) {
+	f, err := NewFormatter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.FormatWithPositions(ctx, in, out)
+}
+
+// FormatString formats Modelica source held in a string
+func FormatString(src string, opts Options) (string, error) {
+	f, err := NewFormatter(opts)
+	if err != nil {
+		return "", err
+	}
+	return f.FormatString(src)
+}
+
+// FormatBytes formats Modelica source held in a byte slice
+func FormatBytes(src []byte, opts Options) ([]byte, error) {
+	f, err := NewFormatter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return f.FormatBytes(src)
+}
@@ -0,0 +1,2103 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/stretchr/testify/require"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+const outputDir = "../test_output"
+
+func TestMain(m *testing.M) {
+	_ = os.Mkdir(outputDir, 0755)
+	code := m.Run()
+	os.Exit(code)
+}
+
+func diffFiles(a, b string) (string, error) {
+	cmd := exec.Command("git", "diff", "--no-index", a, b)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	_ = cmd.Run()
+	return out.String(), nil
+}
+
+// requireIdempotent requires formatting out, the output of formatting with
+// opts, to leave it as it is
+func requireIdempotent(a *require.Assertions, out string, opts Options) {
+	again, err := FormatString(out, opts)
+	a.NoError(err)
+	a.Equal(out, again, "Formatting the output again should not change it")
+}
+
+var exampleFileTests = []struct {
+	sourceFile string
+	outFile    string
+}{
+	{"gmt-building.mo", "gmt-building-out.mo"},
+	{"gmt-coolingtower.mo", "gmt-coolingtower-out.mo"},
+	{"array-declarations.mo", "array-declarations-out.mo"},
+	{"replaceable-constrainedby.mo", "replaceable-constrainedby-out.mo"},
+	{"comments.mo", "comments-out.mo"},
+}
+
+func TestFormattingExamples(t *testing.T) {
+	a := require.New(t)
+	for _, testCase := range exampleFileTests {
+		t.Run(testCase.sourceFile, func(t *testing.T) {
+			// Setup
+			testSourceFile := path.Join("..", "examples", testCase.sourceFile)
+			expectedOutFile := path.Join("..", "examples", testCase.outFile)
+			actualOutFile := path.Join(outputDir, testCase.outFile)
+			source, err := os.Open(testSourceFile)
+			a.NoError(err)
+			defer source.Close()
+			file, err := os.Create(actualOutFile)
+			a.NoError(err)
+			defer file.Close()
+
+			// Act
+			err = Format(context.Background(), source, file, DefaultOptions())
+
+			// Assert
+			a.NoError(err)
+			diff, err := diffFiles(expectedOutFile, actualOutFile)
+			a.NoError(err)
+			a.Len(diff, 0, "File diff should be empty")
+		})
+	}
+}
+
+func TestNoTrailingWhitespace(t *testing.T) {
+	for _, testCase := range exampleFileTests {
+		t.Run(testCase.sourceFile, func(t *testing.T) {
+			a := require.New(t)
+			source, err := os.Open(path.Join("..", "examples", testCase.sourceFile))
+			a.NoError(err)
+			defer source.Close()
+			var out bytes.Buffer
+			err = Format(context.Background(), source, &out, DefaultOptions())
+			a.NoError(err)
+
+			// strings may hold trailing spaces, only whitespace and comments
+			// are checked
+			lexer := parser.NewModelicaLexer(antlr.NewInputStream(out.String()))
+			for _, token := range lexer.GetAllTokens() {
+				text := token.GetText()
+				switch token.GetTokenType() {
+				case parser.ModelicaLexerLINE_COMMENT:
+					text += "\n"
+				case parser.ModelicaLexerWS, parser.ModelicaLexerCOMMENT:
+				default:
+					continue
+				}
+				a.NotContains(text, " \n", "line %d", token.GetLine())
+				a.NotContains(text, "\t\n", "line %d", token.GetLine())
+			}
+		})
+	}
+}
+
+// styleCorpora are the styles which have a corpus of models written in the
+// style of their library in the examples directory named after them, along
+// with the share of their lines which formatting them with the style may
+// change
+var styleCorpora = []struct {
+	style    string
+	maxDelta float64
+}{
+	{StyleMSL, 0.15},
+	{StyleBuildings, 0},
+}
+
+func TestStyleCorpora(t *testing.T) {
+	for _, corpus := range styleCorpora {
+		t.Run(corpus.style, func(t *testing.T) {
+			a := require.New(t)
+			opts, err := StyleOptions(corpus.style)
+			a.NoError(err)
+			files, err := filepath.Glob(filepath.Join("..", "examples", corpus.style, "*.mo"))
+			a.NoError(err)
+			a.NotEmpty(files)
+
+			lines, changed := 0, 0
+			for _, filename := range files {
+				source, err := ioutil.ReadFile(filename)
+				a.NoError(err)
+				out, err := FormatString(string(source), opts)
+				a.NoError(err, filename)
+				again, err := FormatString(out, opts)
+				a.NoError(err, filename)
+				a.Equal(out, again, "Formatting %s again should not change it", filename)
+
+				sourceLines := strings.Split(string(source), "\n")
+				lines += len(sourceLines)
+				changed += changedLines(sourceLines, strings.Split(out, "\n"))
+			}
+			delta := float64(changed) / float64(lines)
+			t.Logf("the %s style changes %d lines of %d (%.0f%%)", corpus.style, changed, lines, 100*delta)
+			a.LessOrEqual(delta, corpus.maxDelta)
+		})
+	}
+}
+
+// changedLines returns the number of lines of a which a minimal line diff of
+// a to b removes or replaces
+func changedLines(a, b []string) int {
+	// length of the longest common subsequence of a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] > common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+	return len(a) - common[0][0]
+}
+
+func TestFormatCanceled(t *testing.T) {
+	a := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	source, err := os.Open(path.Join("..", "examples", "gmt-building.mo"))
+	a.NoError(err)
+	defer source.Close()
+
+	var out bytes.Buffer
+	err = Format(ctx, source, &out, DefaultOptions())
+
+	a.Equal(context.Canceled, err)
+	a.Len(out.Bytes(), 0, "Nothing should be written once canceled")
+}
+
+func TestFormatString(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString("model A Real x; end A;", DefaultOptions())
+
+	a.NoError(err)
+	a.Equal("model A\n  Real x;\nend A;\n", out)
+}
+
+func TestFormatBytes(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatBytes([]byte("model A Real x; end A;"), DefaultOptions())
+
+	a.NoError(err)
+	a.Equal([]byte("model A\n  Real x;\nend A;\n"), out)
+}
+
+func TestSplitDeclarations(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SplitDeclarations = true
+
+	out, err := FormatString(`model A
+  parameter Real[2] a = {1, 2} "a", b(each start = 0) annotation (Evaluate=true);
+  Real c, d;
+  replaceable Real e, f constrainedby Real;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real[2] a={1, 2}
+    "a";
+  parameter Real[2] b(
+    each start=0)
+    annotation (Evaluate=true);
+  Real c;
+  Real d;
+  replaceable Real e, f constrainedby Real;
+end A;
+`, out)
+}
+
+func TestIndent(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Indent = 4
+
+	out, err := FormatString("model A Real x; equation x = 1; end A;", opts)
+
+	a.NoError(err)
+	a.Equal("model A\n    Real x;\n\nequation\n    x=1;\nend A;\n", out)
+}
+
+func TestUseTabs(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.UseTabs = true
+
+	out, err := FormatString("model A Real x; equation x = 1; end A;", opts)
+
+	a.NoError(err)
+	a.Equal("model A\n\tReal x;\n\nequation\n\tx=1;\nend A;\n", out)
+}
+
+func TestMaxLineLength(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  B b annotation (Placement(transformation(extent={{-10,-10},{10,10}}), iconTransformation(extent={{-10,-10},{10,10}})));
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  B b
+    annotation (Placement(
+      transformation(extent={{-10, -10}, {10, 10}}),
+      iconTransformation(extent={{-10, -10}, {10, 10}})));
+end A;
+`, out)
+
+	opts := DefaultOptions()
+	opts.MaxLineLength = 0
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  B b
+    annotation (Placement(transformation(extent={{-10, -10}, {10, 10}}), iconTransformation(extent={{-10, -10}, {10, 10}})));
+end A;
+`, out)
+}
+
+func TestMaxVectorElements(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.MaxVectorElements = 3
+
+	out, err := FormatString(`model A
+  parameter Real a[4]={1,2,3,4};
+  parameter Real b[3]={1,2,3};
+  Real x annotation (Line(points={{0,0},{1,1},{2,2},{3,3}}));
+equation
+  y=f({1,2,3,4});
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real a[4]={
+    1,
+    2,
+    3,
+    4
+  };
+  parameter Real b[3]={1, 2, 3};
+  Real x
+    annotation (Line(points={{0, 0}, {1, 1}, {2, 2}, {3, 3}}));
+
+equation
+  y=f(
+    {
+      1,
+      2,
+      3,
+      4
+    });
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestComprehensions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.MaxLineLength = 60
+
+	out, err := FormatString(`model A
+  parameter Real a[n]={f(i) for i in 1:n};
+  parameter Real b[n,m]={g(i,j,parameterName) for i in 1:numberOfRows, j in 1:m};
+  Real s=sum(x[i] for i in 1:n);
+  Real t=sum(x[i]*y[i]*gainOfTheSum for i in 1:numberOfElements);
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real a[n]={f(i) for i in 1:n};
+  parameter Real b[n, m]={g(i, j, parameterName)
+    for i in 1:numberOfRows, j in 1:m};
+  Real s=sum(x[i] for i in 1:n);
+  Real t=sum(x[i]*y[i]*gainOfTheSum
+    for i in 1:numberOfElements);
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestCompactArguments(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Arguments = "compact"
+	opts.MaxLineLength = 70
+
+	out, err := FormatString(`model A
+  parameter Real k(unit="1")=f(x, y);
+  Modelica.Blocks.Sources.Ramp ramp(height=1, duration=10, offset=0, startTime=5);
+equation
+  y=someFunction(firstArgument, secondArgument, thirdArgument);
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k(unit="1")=f(x, y);
+  Modelica.Blocks.Sources.Ramp ramp(
+    height=1,
+    duration=10,
+    offset=0,
+    startTime=5);
+
+equation
+  y=someFunction(firstArgument, secondArgument, thirdArgument);
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestNestedCalls(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+equation
+  y=f(a=g(b=h(c=1,d=2),e=3),k=4);
+  z=fun(x,gun(yyyyyyy,zzzzzzz,h(wwwwwww,vvvvvvv)));
+end A;`
+
+	opts := DefaultOptions()
+	opts.MaxLineLength = 30
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  y=f(
+    a=g(
+      b=h(
+        c=1,
+        d=2),
+      e=3),
+    k=4);
+  z=fun(
+    x,
+    gun(
+      yyyyyyy,
+      zzzzzzz,
+      h(
+        wwwwwww,
+        vvvvvvv)));
+end A;
+`, out)
+
+	opts.Arguments = "compact"
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  y=f(
+    a=g(b=h(c=1, d=2), e=3),
+    k=4);
+  z=fun(
+    x,
+    gun(
+      yyyyyyy,
+      zzzzzzz,
+      h(wwwwwww, vvvvvvv)));
+end A;
+`, out)
+}
+
+func TestOperatorWrap(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+equation
+  QFlow=someCoefficient*(temperatureOfTheWater-temperatureOfTheAir)+otherCoefficient*massFlowRate-lossTerm;
+  enable=useFirst and firstValue > threshold or useSecond and secondValue < otherThreshold;
+  y=-a+b;
+end A;`
+
+	opts := DefaultOptions()
+	opts.MaxLineLength = 60
+	opts.OperatorWrap = "leading"
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  QFlow=someCoefficient
+      *(temperatureOfTheWater - temperatureOfTheAir)
+    + otherCoefficient*massFlowRate
+    - lossTerm;
+  enable=useFirst and firstValue > threshold
+    or useSecond and secondValue < otherThreshold;
+  y=-a + b;
+end A;
+`, out)
+	requireIdempotent(a, out, opts)
+
+	opts.OperatorWrap = "trailing"
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  QFlow=someCoefficient*
+      (temperatureOfTheWater - temperatureOfTheAir) +
+    otherCoefficient*massFlowRate -
+    lossTerm;
+  enable=useFirst and firstValue > threshold or
+    useSecond and secondValue < otherThreshold;
+  y=-a + b;
+end A;
+`, out)
+	requireIdempotent(a, out, opts)
+}
+
+func TestSigns(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+  Real x=a-b+(-c)*{-1, -2}-d;
+equation
+  if x>-1 then y=a.-b; else y=-1-2; end if;
+  z=f(-1, a-1)+x[end-1];
+algorithm
+  x:=-1;
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x=a - b + (-c)*{-1, -2} - d;
+
+equation
+  if x > -1 then
+    y=a .- b;
+  else
+    y=-1 - 2;
+  end if;
+  z=f(
+    -1,
+    a - 1) + x[end - 1];
+
+algorithm
+  x := -1;
+end A;
+`, out)
+}
+
+func TestSpaceAroundRange(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x[:, 2]={i for i in 1:2};
+  Real y[3]=z[1:3]+(1:2:5);
+equation
+  for i in 1:n loop y[i:end]=x[:, 1]; end for;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Contains(out, "Real y[3]=z[1:3] + (1:2:5);")
+
+	opts := DefaultOptions()
+	opts.SpaceAroundRange = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x[:, 2]={i for i in 1 : 2};
+  Real y[3]=z[1 : 3] + (1 : 2 : 5);
+
+equation
+  for i in 1 : n loop
+    y[i : end]=x[:, 1];
+  end for;
+end A;
+`, out)
+}
+
+func TestSpaceAroundMultiplication(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x=a * b/c .* d./e ^ 2+f .^ 2;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Contains(out, "Real x=a*b/c.*d./e^2 + f.^2;")
+
+	opts := DefaultOptions()
+	opts.SpaceAroundMultiplication = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Contains(out, "Real x=a * b / c .* d ./ e^2 + f.^2;")
+
+	opts.SpaceAroundExponent = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Contains(out, "Real x=a * b / c .* d ./ e ^ 2 + f .^ 2;")
+}
+
+func TestSpaceAroundEquations(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SpaceAroundEquations = true
+
+	out, err := FormatString(`model A
+  Real x(start=0)=1;
+equation
+  x=y+1;
+  for i in 1:2 loop
+    z[i]=i;
+  end for;
+algorithm
+  y:=x;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x(
+    start=0)=1;
+
+equation
+  x = y + 1;
+  for i in 1:2 loop
+    z[i] = i;
+  end for;
+
+algorithm
+  y := x;
+end A;
+`, out)
+}
+
+func TestCommaSpacing(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+  import B.{c,d};
+  Real x[2,2]=[1,2;3,4];
+  Real y[:,:]=z[1,:];
+  Real w=f(a,/* b */b,c /* d */,d)
+    annotation (Placement(transformation(extent={{-10,-10},{10,10}})));
+  M m(redeclare package Medium=Water,k={1,2});
+equation
+  connect(m.a,m.b);
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  import B.{c, d};
+  Real x[2, 2]=[
+    1, 2;
+    3, 4];
+  Real y[:, :]=z[1, :];
+  Real w=f(
+    a,
+    /* b */ b,
+    c /* d */,
+    d)
+    annotation (Placement(transformation(extent={{-10, -10}, {10, 10}})));
+  M m(
+    redeclare package Medium=Water,
+    k={1, 2});
+
+equation
+  connect(m.a, m.b);
+end A;
+`, out)
+}
+
+func TestSpaceInsideBrackets(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SpaceInsideBrackets = true
+
+	out, err := FormatString(`model A
+  import B.{c,d};
+  Real y=f()+g((a+b)*c)+z[i,-1]
+    annotation (Placement(transformation(extent={{-10,-10},{10,10}})));
+equation
+  connect(a,b);
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  import B.{ c, d };
+  Real y=f() + g(
+    ( a + b )*c ) + z[ i, -1 ]
+    annotation ( Placement( transformation( extent={ { -10, -10 }, { 10, 10 } } ) ) );
+
+equation
+  connect( a, b );
+end A;
+`, out)
+}
+
+func TestConditions(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+equation
+  if useFirstCondition and firstValue > thresholdValue or override then
+    y=1;
+  elseif x > 0 then
+    y=2;
+  end if;
+algorithm
+  while counter < maximumNumberOfIterations and errorEstimate > tolerance loop
+    counter := counter+1;
+  end while;
+end A;`
+
+	opts := DefaultOptions()
+	opts.MaxLineLength = 60
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  if useFirstCondition and firstValue > thresholdValue
+      or override then
+    y=1;
+  elseif x > 0 then
+    y=2;
+  end if;
+
+algorithm
+  while counter < maximumNumberOfIterations
+      and errorEstimate > tolerance loop
+    counter := counter + 1;
+  end while;
+end A;
+`, out)
+	requireIdempotent(a, out, opts)
+
+	opts.ThenPlacement = "own-line"
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  if useFirstCondition and firstValue > thresholdValue
+    or override
+  then
+    y=1;
+  elseif x > 0 then
+    y=2;
+  end if;
+
+algorithm
+  while counter < maximumNumberOfIterations
+    and errorEstimate > tolerance
+  loop
+    counter := counter + 1;
+  end while;
+end A;
+`, out)
+	requireIdempotent(a, out, opts)
+}
+
+func TestIfExpressions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.MaxLineLength = 60
+
+	out, err := FormatString(`model A
+  parameter Real k=if useGain then 2 else 1;
+equation
+  y=if x > 0 then x else -x;
+  z=if someLongConditionName > thresholdValue then firstAlternative elseif x < 0 then 0 else secondAlternative;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k=if useGain then 2 else 1;
+
+equation
+  y=if x > 0 then x else -x;
+  z=
+    if someLongConditionName > thresholdValue then
+      firstAlternative
+    elseif x < 0 then
+      0
+    else
+      secondAlternative;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestIfEquations(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+equation
+  if a then y=1; elseif b then else end if;
+  when a then reinit(x,0); elsewhen b then end when;
+algorithm
+  if a then y:=if b then 1 else 2; else end if;
+  while c loop end while;
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  if a then
+    y=1;
+  elseif b then
+  else
+  end if;
+  when a then
+    reinit(x, 0);
+  elsewhen b then
+  end when;
+
+algorithm
+  if a then
+    y := if b then 1 else 2;
+  else
+  end if;
+  while c loop
+  end while;
+end A;
+`, out)
+}
+
+func TestNestedIfChains(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.MaxLineLength = 50
+
+	out, err := FormatString(`model A
+equation
+  if a then
+    if b then y=1; elseif c then if d then y=2; else y=3; end if; else y=4; end if;
+  else
+    y=if someCondition > threshold then 1 elseif other then if third then fourthAlternative else fifthAlternative else 0;
+  end if;
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  if a then
+    if b then
+      y=1;
+    elseif c then
+      if d then
+        y=2;
+      else
+        y=3;
+      end if;
+    else
+      y=4;
+    end if;
+  else
+    y=
+      if someCondition > threshold then
+        1
+      elseif other then
+        if third then
+          fourthAlternative
+        else
+          fifthAlternative
+      else
+        0;
+  end if;
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestLoops(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+equation
+  for i in 1:n loop for j in 1:m loop x[i,j]=i*j; end for; end for;
+algorithm
+  for i in 1:n loop
+    while k < i loop k:=k+1; for j in 1:k loop s:=s+j; end for; end while;
+  end for;
+  for i in 1:n loop end for;
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  for i in 1:n loop
+    for j in 1:m loop
+      x[i, j]=i*j;
+    end for;
+  end for;
+
+algorithm
+  for i in 1:n loop
+    while k < i loop
+      k := k + 1;
+      for j in 1:k loop
+        s := s + j;
+      end for;
+    end while;
+  end for;
+  for i in 1:n loop
+  end for;
+end A;
+`, out)
+}
+
+func TestWhenClauses(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+equation
+  when {sample(0,samplePeriod),initial()} then reinit(x,0); y=pre(y)+1; elsewhen x > threshold then reinit(x,x0); terminate("done"); end when;
+  y=f(a,b);
+algorithm
+  when change(u) then y:=u; end when;
+  if isValid(u,v) then y:=g(u); end if;
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  when {sample(0, samplePeriod), initial()} then
+    reinit(x, 0);
+    y=pre(y) + 1;
+  elsewhen x > threshold then
+    reinit(x, x0);
+    terminate("done");
+  end when;
+  y=f(
+    a,
+    b);
+
+algorithm
+  when change(u) then
+    y := u;
+  end when;
+  if isValid(u, v) then
+    y := g(
+      u);
+  end if;
+end A;
+`, out)
+}
+
+func TestExtendsClauses(t *testing.T) {
+	a := require.New(t)
+	source := `model N
+  extends Base(k=1, T=2) annotation (IconMap(primitivesVisible=false));
+  extends Buildings.Fluid.Interfaces.PartialTwoPortInterface(redeclare package Medium = MediumW, x(start=1, fixed=true), k=1) annotation (IconMap(primitivesVisible=false));
+end N;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model N
+  extends Base(
+    k=1,
+    T=2)
+    annotation (IconMap(primitivesVisible=false));
+  extends Buildings.Fluid.Interfaces.PartialTwoPortInterface(
+    redeclare package Medium=MediumW,
+    x(
+      start=1,
+      fixed=true),
+    k=1)
+    annotation (IconMap(primitivesVisible=false));
+end N;
+`, out)
+
+	opts := DefaultOptions()
+	opts.Arguments = "compact"
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model N
+  extends Base(k=1, T=2)
+    annotation (IconMap(primitivesVisible=false));
+  extends Buildings.Fluid.Interfaces.PartialTwoPortInterface(
+    redeclare package Medium=MediumW,
+    x(start=1, fixed=true),
+    k=1)
+    annotation (IconMap(primitivesVisible=false));
+end N;
+`, out)
+}
+
+func TestRedeclarations(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  redeclare replaceable Buildings.Fluid.Movers.Data.Generic.VeryLongRecordTypeNameForPerformanceData per = Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord annotation (choicesAllMatching=true);
+  redeclare replaceable Buildings.Fluid.Movers.Data.Generic per = Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord;
+  redeclare replaceable package MediumWithAVeryLongName = Buildings.Media.Specialized.Air.PerfectGasWithAVeryLongName;
+  redeclare replaceable Real x = 1 annotation (choicesAllMatching=true);
+  B b(redeclare Buildings.Fluid.Movers.Data.Generic.VeryLongRecordTypeNameForPerformance per = Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord);
+end A;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  redeclare replaceable
+    Buildings.Fluid.Movers.Data.Generic.VeryLongRecordTypeNameForPerformanceData per=
+      Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord
+      annotation (choicesAllMatching=true);
+  redeclare replaceable Buildings.Fluid.Movers.Data.Generic per=
+    Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord;
+  redeclare replaceable
+    package MediumWithAVeryLongName=Buildings.Media.Specialized.Air.PerfectGasWithAVeryLongName;
+  redeclare replaceable Real x=1
+    annotation (choicesAllMatching=true);
+  B b(
+    redeclare Buildings.Fluid.Movers.Data.Generic.VeryLongRecordTypeNameForPerformance per=
+      Buildings.Fluid.Movers.Data.Generic.DefaultPerformanceDataRecord);
+end A;
+`, out)
+}
+
+func TestEnumerations(t *testing.T) {
+	a := require.New(t)
+	source := `package P
+  type E = enumeration(a "first", b "second", c "third") "An enum";
+  type F = enumeration(x, y);
+  type G = enumeration(:);
+  type H = enumeration(on "The setpoint is tracked by the controller", off "The controller output is held at zero");
+  type I = enumeration(on "On", off "Off");
+end P;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`package P
+  type E=enumeration(
+    a "first",
+    b "second",
+    c "third")
+    "An enum";
+  type F=enumeration(x, y);
+  type G=enumeration(:);
+  type H=enumeration(
+    on "The setpoint is tracked by the controller",
+    off "The controller output is held at zero");
+  type I=enumeration(on "On", off "Off");
+end P;
+`, out)
+}
+
+func TestRecordConstructors(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  parameter Data dat = Data(x=1, yLong=2, zz=3);
+  parameter Buildings.Fluid.Movers.Data.Generic per = Buildings.Fluid.Movers.Data.Generic(pressure=P(V_flow={0,1,2}, dp={3,2,1}), use_powerCharacteristic=false);
+end M;
+`
+
+	opts := DefaultOptions()
+	opts.AlignArguments = true
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  parameter Data dat=Data(
+    x    =1,
+    yLong=2,
+    zz   =3);
+  parameter Buildings.Fluid.Movers.Data.Generic per=Buildings.Fluid.Movers.Data.Generic(
+    pressure               =P(
+      V_flow={0, 1, 2},
+      dp    ={3, 2, 1}),
+    use_powerCharacteristic=false);
+end M;
+`, out)
+
+	opts.Arguments = "compact"
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  parameter Data dat=Data(x=1, yLong=2, zz=3);
+  parameter Buildings.Fluid.Movers.Data.Generic per=Buildings.Fluid.Movers.Data.Generic(
+    pressure               =P(V_flow={0, 1, 2}, dp={3, 2, 1}),
+    use_powerCharacteristic=false);
+end M;
+`, out)
+}
+
+func TestConditionalDeclarations(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Buildings.Controls.OBC.CDL.Continuous.MultiSum mulSum(nin=2) if have_pum annotation (Placement(transformation(extent={{260,70},{280,90}})));
+  Modelica.Blocks.Interfaces.RealOutput y if useC "Output";
+  Buildings.Controls.OBC.CDL.Continuous.Sources.Constant con if haveTheVeryLongConditionName and anotherVeryLongCondition "A description" annotation (Placement());
+end M;
+`
+
+	opts := DefaultOptions()
+	opts.Arguments = "compact"
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  Buildings.Controls.OBC.CDL.Continuous.MultiSum mulSum(nin=2) if have_pum
+    annotation (Placement(transformation(extent={{260, 70}, {280, 90}})));
+  Modelica.Blocks.Interfaces.RealOutput y if useC
+    "Output";
+  Buildings.Controls.OBC.CDL.Continuous.Sources.Constant con
+    if haveTheVeryLongConditionName and anotherVeryLongCondition
+    "A description"
+    annotation (Placement());
+end M;
+`, out)
+}
+
+func TestTypeAliases(t *testing.T) {
+	a := require.New(t)
+	source := `package P
+  type Percentage = Real(min=0, max=100, unit="1");
+  type Temperature = Real(final quantity="ThermodynamicTemperature", final unit="K", displayUnit="degC", min=0, nominal=300) "Absolute temperature";
+  type Length = Real;
+  model M = Base(k=1);
+end P;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`package P
+  type Percentage=Real(min=0, max=100, unit="1");
+  type Temperature=Real(
+    final quantity="ThermodynamicTemperature",
+    final unit="K",
+    displayUnit="degC",
+    min=0,
+    nominal=300)
+    "Absolute temperature";
+  type Length=Real;
+  model M=Base(
+    k=1);
+end P;
+`, out)
+}
+
+func TestMaxInlineModifications(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Real x(start=0, fixed=true);
+  Real y(start=0, fixed=true, min=0);
+  Buildings.Fluid.Sources.Boundary_pT bou(redeclare package Medium=Medium, T=293.15);
+end M;
+`
+
+	opts := DefaultOptions()
+	opts.MaxInlineModifications = 2
+	opts.MaxLineLength = 80
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  Real x(start=0, fixed=true);
+  Real y(
+    start=0,
+    fixed=true,
+    min=0);
+  Buildings.Fluid.Sources.Boundary_pT bou(
+    redeclare package Medium=Medium,
+    T=293.15);
+end M;
+`, out)
+
+	opts.Arguments = "compact"
+	out2, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(out, out2)
+}
+
+func TestDropEmptyModifications(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  extends Base();
+  Modelica.Blocks.Sources.Constant c();
+  Real x()=1;
+  Real y(/* keep */);
+  D d(e(), f()=2) annotation (Icon());
+end M;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Contains(out, "Constant c();")
+
+	opts := DefaultOptions()
+	opts.DropEmptyModifications = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  extends Base;
+  Modelica.Blocks.Sources.Constant c;
+  Real x=1;
+  Real y(/* keep */);
+  D d(
+    e,
+    f=2)
+    annotation (Icon());
+end M;
+`, out)
+}
+
+func TestDropEmptyAnnotations(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Real x annotation ();
+  Real y "y" annotation (Dialog(), Evaluate=true);
+  Real z annotation (Icon(graphics()), Diagram());
+  Real w annotation (Icon(/* keep */));
+equation
+  connect(a, b) annotation (Line());
+  annotation ();
+end M;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Contains(out, "Real x\n    annotation ();")
+
+	opts := DefaultOptions()
+	opts.DropEmptyAnnotations = true
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  Real x;
+  Real y
+    "y"
+    annotation (Evaluate=true);
+  Real z;
+  Real w
+    annotation (
+      Icon(/* keep */));
+
+equation
+  connect(a, b);
+end M;
+`, out)
+}
+
+func TestAnnotationPlacement(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  parameter Real x=1 "x" annotation (Evaluate=true);
+  M2 m annotation (Placement(transformation(extent={{-10,-10},{10,10}})));
+equation
+  connect(a, b) annotation (Line(points={{1,2},{3,4}}));
+algorithm
+  x := 1 annotation (foo=1);
+  annotation (Documentation(info="<html></html>"));
+end M;
+`
+
+	opts := DefaultOptions()
+	opts.AnnotationPlacement = "trailing"
+	opts.MaxLineLength = 50
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  parameter Real x=1
+    "x" annotation (Evaluate=true);
+  M2 m annotation (Placement(
+    transformation(
+      extent={{-10, -10}, {10, 10}})));
+
+equation
+  connect(a, b) annotation (Line(points={{1, 2}, {3, 4}}));
+
+algorithm
+  x := 1 annotation (foo=1);
+
+  annotation (
+    Documentation(
+      info="<html></html>"));
+end M;
+`, out)
+
+	opts.AnnotationPlacement = "inline"
+	_, err = FormatString(source, opts)
+
+	a.EqualError(err, `unsupported annotation placement "inline"`)
+}
+
+func TestDescriptionPlacement(t *testing.T) {
+	a := require.New(t)
+	source := `model A "A model"
+  parameter Real k=1 "Gain";
+  parameter Real T=1 "Time constant of the first order block" annotation (Evaluate=true);
+  Real x "x" annotation (Evaluate=true);
+equation
+  x=1 "equation";
+end A;
+`
+
+	opts := DefaultOptions()
+	opts.DescriptionPlacement = "trailing"
+	opts.MaxLineLength = 50
+	out, err := FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model A "A model"
+  parameter Real k=1 "Gain";
+  parameter Real T=1
+    "Time constant of the first order block"
+    annotation (Evaluate=true);
+  Real x "x"
+    annotation (Evaluate=true);
+
+equation
+  x=1 "equation";
+end A;
+`, out)
+
+	opts.DescriptionPlacement = "inline"
+	_, err = FormatString(source, opts)
+
+	a.EqualError(err, `unsupported description placement "inline"`)
+}
+
+func TestSpaceAfterAnnotation(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Real x annotation(Evaluate=true);
+  annotation (Documentation(info="<html></html>"));
+end M;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Contains(out, "annotation (Evaluate=true);")
+
+	opts := DefaultOptions()
+	opts.SpaceAfterAnnotation = false
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`model M
+  Real x
+    annotation(Evaluate=true);
+
+  annotation(Documentation(info="<html></html>"));
+end M;
+`, out)
+}
+
+func TestSpaceInAnnotationVectors(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SpaceInAnnotationVectors = false
+
+	out, err := FormatString(`model A
+  Real x[2]={1, -2};
+  M m annotation (Placement(transformation(extent={{-10,-10},{10, 10}}, rotation=-90)));
+  annotation (Icon(graphics={Line(points={{0,0},{1,-1}}, color={0,0,255})}));
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x[2]={1, -2};
+  M m
+    annotation (Placement(transformation(extent={{-10,-10},{10,10}}, rotation=-90)));
+
+  annotation (Icon(graphics={Line(points={{0,0},{1,-1}}, color={0,0,255})}));
+end A;
+`, out)
+}
+
+func TestNormalizeComments(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  //comment
+  //	tabbed
+  //   indented
+  //! banner
+  //----------
+  //
+  Real x;
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  // comment
+  // tabbed
+  //   indented
+  //! banner
+  //----------
+  //
+  Real x;
+end A;
+`, out)
+
+	opts := DefaultOptions()
+	opts.NormalizeComments = false
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Contains(out, "  //comment\n  //\ttabbed\n")
+}
+
+func TestIndentInvalid(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Indent = 0
+
+	_, err := FormatString("model A end A;", opts)
+
+	a.EqualError(err, "indent must be positive, got 0")
+}
+
+func TestBlankLines(t *testing.T) {
+	a := require.New(t)
+	source := `within;
+
+
+model A
+
+  Real x;
+
+
+
+  Real y(
+
+    start=0);
+
+equation
+
+  x = 1;
+
+  // y
+  y = 2;
+
+end A;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`within;
+
+model A
+  Real x;
+
+
+  Real y(
+    start=0);
+
+equation
+  x=1;
+
+  // y
+  y=2;
+end A;
+`, out)
+
+	opts := DefaultOptions()
+	opts.MaxBlankLines = 0
+	opts.SeparateSections = false
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`within;
+
+model A
+  Real x;
+  Real y(
+    start=0);
+equation
+  x=1;
+  // y
+  y=2;
+end A;
+`, out)
+}
+
+func TestWithin(t *testing.T) {
+	a := require.New(t)
+	tests := map[string]string{
+		"within  Pkg.Sub ;\n\n\n\nmodel M\nend M;\n":   "within Pkg.Sub;\n\nmodel M\nend M;\n",
+		"within;model M\nend M;\n":                     "within;\n\nmodel M\nend M;\n",
+		"\n\nwithin P;\n// comment\nmodel M\nend M;\n": "within P;\n\n// comment\nmodel M\nend M;\n",
+	}
+
+	for source, expected := range tests {
+		out, err := FormatString(source, DefaultOptions())
+		a.NoError(err)
+		a.Equal(expected, out)
+
+		var stream bytes.Buffer
+		a.NoError(FormatStream(context.Background(), strings.NewReader(source), &stream, DefaultOptions()))
+		a.Equal(expected, stream.String())
+	}
+
+	opts := DefaultOptions()
+	opts.BlankLineAfterWithin = false
+	tests = map[string]string{
+		"within P;\n\n\n\nmodel M\nend M;\n": "within P;\n\n\nmodel M\nend M;\n",
+		"within P;\nmodel M\nend M;\n":       "within P;\nmodel M\nend M;\n",
+	}
+	for source, expected := range tests {
+		out, err := FormatString(source, opts)
+		a.NoError(err)
+		a.Equal(expected, out)
+
+		var stream bytes.Buffer
+		a.NoError(FormatStream(context.Background(), strings.NewReader(source), &stream, opts))
+		a.Equal(expected, stream.String())
+	}
+}
+
+func TestSections(t *testing.T) {
+	a := require.New(t)
+	source := `model M
+  Real x;
+  Real y;
+
+
+initial equation
+equation
+
+  der(x) = -x;
+algorithm
+
+
+  y := 2;
+initial algorithm
+end M;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model M
+  Real x;
+  Real y;
+
+initial equation
+
+equation
+  der(
+    x)=-x;
+
+algorithm
+  y := 2;
+
+initial algorithm
+end M;
+`, out)
+}
+
+func TestVisibility(t *testing.T) {
+	a := require.New(t)
+	source := `package P
+  model M
+    Real x;
+  protected Real y;
+  public
+  Real z;
+  end M;
+end P;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`package P
+  model M
+    Real x;
+
+  protected
+    Real y;
+
+  public
+    Real z;
+  end M;
+end P;
+`, out)
+
+	opts := DefaultOptions()
+	opts.HalfDedentVisibility = true
+	opts.Indent = 4
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`package P
+    model M
+        Real x;
+
+      protected
+        Real y;
+
+      public
+        Real z;
+    end M;
+end P;
+`, out)
+}
+
+func TestEndBlankLine(t *testing.T) {
+	a := require.New(t)
+	source := `package P
+  model A
+    Real x;
+  end A;
+  model B
+    Real x;
+    Real y;
+
+  equation
+    x = y;
+  end B;
+end P;
+`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`package P
+  model A
+    Real x;
+  end A;
+
+  model B
+    Real x;
+    Real y;
+
+  equation
+    x=y;
+  end B;
+end P;
+`, out)
+
+	opts := DefaultOptions()
+	opts.EndBlankLineAfter = 5
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Equal(`package P
+  model A
+    Real x;
+  end A;
+
+  model B
+    Real x;
+    Real y;
+
+  equation
+    x=y;
+
+  end B;
+
+end P;
+`, out)
+}
+
+func TestPreserveBlankLines(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.PreserveBlankLines = true
+
+	out, err := FormatString(`model A
+  extends B(
+    a=1,
+
+    b=2,
+
+
+
+    c=3);
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  extends B(
+    a=1,
+
+    b=2,
+
+
+    c=3);
+end A;
+`, out)
+}
+
+func TestShortAnnotations(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+  parameter Real x annotation(Dialog(tab="Advanced"));
+  annotation(Icon(graphics={Rectangle(extent={{-100,-100},{100,100}})}), Documentation(info="<html>A model</html>"));
+end A;
+model B
+  annotation(Evaluate=true, Icon(graphics={Rectangle(extent={{-100,-100},{100,100}})}));
+end B;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real x
+    annotation (Dialog(tab="Advanced"));
+
+  annotation (
+    Icon(
+      graphics={
+        Rectangle(extent={{-100, -100}, {100, 100}})}),
+    Documentation(
+      info="<html>A model</html>"));
+end A;
+
+model B
+  annotation (Evaluate=true, Icon(graphics={Rectangle(extent={{-100, -100}, {100, 100}})}));
+end B;
+`, out)
+}
+
+func TestPlacement(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+  Modelica.Blocks.Interfaces.RealInput u "Input signal" annotation(Placement(transformation(extent={{-140,-20},{-100,20}})));
+  Modelica.Blocks.Interfaces.RealOutput y annotation(Placement(transformation(extent={{100,-10},{120,10}}), iconTransformation(extent={{100,-10},{120,10}})));
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  Modelica.Blocks.Interfaces.RealInput u
+    "Input signal"
+    annotation (Placement(transformation(extent={{-140, -20}, {-100, 20}})));
+  Modelica.Blocks.Interfaces.RealOutput y
+    annotation (Placement(
+      transformation(extent={{100, -10}, {120, 10}}),
+      iconTransformation(extent={{100, -10}, {120, 10}})));
+end A;
+`, out)
+
+	opts := DefaultOptions()
+	opts.Placement = placementSingleLine
+	out, err = FormatString(`model A
+  Modelica.Blocks.Interfaces.RealOutput y annotation(Placement(transformation(extent={{100,-10},{120,10}}), iconTransformation(extent={{100,-10},{120,10}})));
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  Modelica.Blocks.Interfaces.RealOutput y
+    annotation (Placement(transformation(extent={{100, -10}, {120, 10}}), iconTransformation(extent={{100, -10}, {120, 10}})));
+end A;
+`, out)
+}
+
+func TestConnectAnnotations(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+equation
+  connect(a.y, b.u) annotation (Line(points={{-120,-80.6667},{-104,-80.6667},{-104,-58.3333},{-180,-58.3333}}, color={0,0,127}));
+  connect(weaBus, b.weaBus) annotation (Line(points={{1,300},{0,300},{0,20},{-66,20}}, color={255,204,51}, thickness=0.5),
+    Text(string="%first", index=-1, extent={{6,3},{6,3}}, horizontalAlignment=TextAlignment.Left));
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  connect(a.y, b.u)
+    annotation (Line(points={{-120, -80.6667}, {-104, -80.6667}, {-104, -58.3333}, {-180, -58.3333}}, color={0, 0, 127}));
+  connect(weaBus, b.weaBus)
+    annotation (
+      Line(points={{1, 300}, {0, 300}, {0, 20}, {-66, 20}}, color={255, 204, 51}, thickness=0.5),
+      Text(string="%first", index=-1, extent={{6, 3}, {6, 3}}, horizontalAlignment=TextAlignment.Left));
+end A;
+`, out)
+}
+
+func TestDialogAndChoices(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+  parameter Real x "Some parameter" annotation (Dialog(tab="Advanced", group="Very long group name of parameters", enable=use_x and not use_y));
+  replaceable package Medium = Modelica.Media.Interfaces.PartialMedium annotation (choices(
+    choice(redeclare package Medium = Buildings.Media.Air "Moist air"),
+    choice(redeclare package Medium = Buildings.Media.Water "Water")));
+  parameter Integer n=1 annotation (choices(choice=1 "One", choice=2 "Two"), Dialog(group="Size"));
+  parameter Boolean b annotation (choices(checkBox=true));
+  annotation (experiment(StopTime=1));
+end A;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real x
+    "Some parameter"
+    annotation (Dialog(tab="Advanced", group="Very long group name of parameters", enable=use_x and not use_y));
+  replaceable package Medium=Modelica.Media.Interfaces.PartialMedium
+    annotation (choices(
+      choice(redeclare package Medium=Buildings.Media.Air "Moist air"),
+      choice(redeclare package Medium=Buildings.Media.Water "Water")));
+  parameter Integer n=1
+    annotation (
+      choices(
+        choice=1 "One",
+        choice=2 "Two"),
+      Dialog(group="Size"));
+  parameter Boolean b
+    annotation (choices(checkBox=true));
+
+  annotation (experiment(StopTime=1));
+end A;
+`, out)
+}
+
+func TestSplitDescriptions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SplitDescriptions = true
+	opts.MaxLineLength = 60
+
+	out, err := FormatString(`model A
+  parameter Real k "Gain of the controller, which is applied to the control error before the limiter" annotation (Dialog(group="Control"));
+  parameter Real Ti "Time constant" + " of the integrator, which is used when the controller has an integral part";
+  parameter Real yMax "Upper limit";
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k
+    "Gain of the controller, which is applied to the "+
+    "control error before the limiter"
+    annotation (Dialog(group="Control"));
+  parameter Real Ti
+    "Time constant"+
+    " of the integrator, which is used when the "+
+    "controller has an integral part";
+  parameter Real yMax
+    "Upper limit";
+end A;
+`, out)
+
+	requireIdempotent(a, out, opts)
+}
+
+func TestExperiment(t *testing.T) {
+	source := `model A
+  annotation (Documentation(info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    experiment(StartTime=0, StopTime=86400, Tolerance=1e-06, Interval=3600, __Dymola_Algorithm="Dassl"));
+end A;
+model B
+  annotation (experiment(StopTime=1));
+end B;`
+	tests := []struct {
+		experiment string
+		expected   string
+	}{
+		{experimentSingleLine, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    experiment(StartTime=0, StopTime=86400, Tolerance=1e-06, Interval=3600, __Dymola_Algorithm="Dassl"));
+end A;
+
+model B
+  annotation (experiment(StopTime=1));
+end B;
+`},
+		{experimentExpand, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    experiment(
+      StartTime=0,
+      StopTime=86400,
+      Tolerance=1e-06,
+      Interval=3600,
+      __Dymola_Algorithm="Dassl"));
+end A;
+
+model B
+  annotation (
+    experiment(
+      StopTime=1));
+end B;
+`},
+	}
+	for _, test := range tests {
+		t.Run(test.experiment, func(t *testing.T) {
+			a := require.New(t)
+			opts := DefaultOptions()
+			opts.Experiment = test.experiment
+
+			out, err := FormatString(source, opts)
+
+			a.NoError(err)
+			a.Equal(test.expected, out)
+		})
+	}
+}
+
+func TestDocumentation(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Documentation = documentationCompact
+
+	out, err := FormatString(`model A
+  annotation (Documentation(info="<html>
+<p>A</p>
+</html>", revisions="<html>
+<ul><li>First</li></ul>
+</html>"), Icon(coordinateSystem(extent={{-100,-100},{100,100}})));
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  annotation (
+    Documentation(info="<html>
+<p>A</p>
+</html>", revisions="<html>
+<ul><li>First</li></ul>
+</html>"),
+    Icon(
+      coordinateSystem(
+        extent={{-100, -100}, {100, 100}})));
+end A;
+`, out)
+}
+
+func TestVendorAnnotations(t *testing.T) {
+	a := require.New(t)
+	source := `model A
+  Real x annotation (__Dymola_tag = {"Temperature", "Setpoint"});
+  annotation (
+    experiment(StopTime=3600, Tolerance=1e-6, __Dymola_Algorithm = "Dassl"),
+    __Dymola_Commands(file="modelica://Buildings/Resources/Scripts/Dymola/A.mos"
+        "Simulate and plot"),
+    __OpenModelica_simulationFlags(solver =  "dassl"));
+end A;`
+
+	out, err := FormatString(source, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+  Real x
+    annotation (__Dymola_tag = {"Temperature", "Setpoint"});
+
+  annotation (
+    experiment(StopTime=3600, Tolerance=1e-6, __Dymola_Algorithm = "Dassl"),
+    __Dymola_Commands(file="modelica://Buildings/Resources/Scripts/Dymola/A.mos"
+        "Simulate and plot"),
+    __OpenModelica_simulationFlags(solver =  "dassl"));
+end A;
+`, out)
+
+	opts := DefaultOptions()
+	opts.PreserveVendorAnnotations = false
+	out, err = FormatString(source, opts)
+
+	a.NoError(err)
+	a.Contains(out, `__Dymola_Algorithm="Dassl"`)
+	a.Contains(out, `__Dymola_tag={"Temperature", "Setpoint"}`)
+}
+
+func TestGraphics(t *testing.T) {
+	source := `model A
+  annotation (Documentation(info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    Icon(coordinateSystem(preserveAspectRatio=false), graphics={Rectangle(extent={{-100,-100},{100,100}}),
+                   /* label */ Text(extent={{-50,-50},{50,50}}, textString="A"),
+                   Polygon(points={{-80,-80},{-60,-40},{-40,-80},{-20,-40},{0,-80}}, lineColor={0,0,255}, fillColor={0,0,255}, fillPattern=FillPattern.Solid)}));
+end A;`
+	tests := []struct {
+		graphics string
+		expected string
+	}{
+		{graphicsExpand, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    Icon(
+      coordinateSystem(
+        preserveAspectRatio=false),
+      graphics={
+        Rectangle(extent={{-100, -100}, {100, 100}}),
+        /* label */ Text(extent={{-50, -50}, {50, 50}}, textString="A"),
+        Polygon(
+          points={{-80, -80}, {-60, -40}, {-40, -80}, {-20, -40}, {0, -80}},
+          lineColor={0, 0, 255},
+          fillColor={0, 0, 255},
+          fillPattern=FillPattern.Solid)}));
+end A;
+`},
+		{graphicsExpandAll, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    Icon(
+      coordinateSystem(
+        preserveAspectRatio=false),
+      graphics={
+        Rectangle(
+          extent={{-100, -100}, {100, 100}}),
+        /* label */ Text(
+          extent={{-50, -50}, {50, 50}},
+          textString="A"),
+        Polygon(
+          points={{-80, -80}, {-60, -40}, {-40, -80}, {-20, -40}, {0, -80}},
+          lineColor={0, 0, 255},
+          fillColor={0, 0, 255},
+          fillPattern=FillPattern.Solid)}));
+end A;
+`},
+		{graphicsCompact, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    Icon(
+      coordinateSystem(preserveAspectRatio=false),
+      graphics={
+        Rectangle(extent={{-100, -100}, {100, 100}}),
+        /* label */ Text(extent={{-50, -50}, {50, 50}}, textString="A"),
+        Polygon(
+          points={{-80, -80}, {-60, -40}, {-40, -80}, {-20, -40}, {0, -80}},
+          lineColor={0, 0, 255},
+          fillColor={0, 0, 255},
+          fillPattern=FillPattern.Solid)}));
+end A;
+`},
+		{graphicsPreserve, `model A
+  annotation (
+    Documentation(
+      info="<html>A model which is documented at length, beyond the length of a line</html>"),
+    Icon(coordinateSystem(preserveAspectRatio=false), graphics={Rectangle(extent={{-100,-100},{100,100}}),
+                   /* label */ Text(extent={{-50,-50},{50,50}}, textString="A"),
+                   Polygon(points={{-80,-80},{-60,-40},{-40,-80},{-20,-40},{0,-80}}, lineColor={0,0,255}, fillColor={0,0,255}, fillPattern=FillPattern.Solid)}));
+end A;
+`},
+	}
+	for _, test := range tests {
+		t.Run(test.graphics, func(t *testing.T) {
+			a := require.New(t)
+			opts := DefaultOptions()
+			opts.Graphics = test.graphics
+
+			out, err := FormatString(source, opts)
+
+			a.NoError(err)
+			a.Equal(test.expected, out)
+		})
+	}
+}
+
+func TestSeparateSections(t *testing.T) {
+	a := require.New(t)
+
+	out, err := FormatString(`model A
+equation
+  x = 1;
+end A;
+model B
+  package M=C;
+  package N=D;
+  model E
+  end E;
+
+
+  model F
+  end F;
+  Real x;
+protected
+  Real y;
+  // equations
+equation
+  x = y;
+algorithm
+  y := 1;
+  annotation (Evaluate=true);
+end B;`, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`model A
+equation
+  x=1;
+end A;
+
+model B
+  package M=C;
+  package N=D;
+  model E
+  end E;
+
+  model F
+  end F;
+  Real x;
+
+protected
+  Real y;
+
+// equations
+equation
+  x=y;
+
+algorithm
+  y := 1;
+
+  annotation (Evaluate=true);
+end B;
+`, out)
+}
+
+// panickingWriter panics on every write
+type panickingWriter struct{}
+
+func (panickingWriter) Write(p []byte) (int, error) {
+	panic("write failed")
+}
+
+func TestFormatInternalError(t *testing.T) {
+	a := require.New(t)
+
+	err := Format(context.Background(), strings.NewReader("model A Real x; end A;"), panickingWriter{}, DefaultOptions())
+
+	internal, ok := err.(*InternalError)
+	a.True(ok, "expected an internal error, got %v", err)
+	a.Equal("write failed", internal.Value)
+	a.Equal("internal error at 1:22: write failed", internal.Error())
+}
@@ -0,0 +1,522 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options configures the formatter. Use DefaultOptions as a starting point and
+// override individual fields as needed.
+type Options struct {
+	// LineEndings is the newline written to the output, either "lf", "crlf"
+	// or "auto" for the line endings of the input
+	LineEndings string `yaml:"line-endings"`
+	// Encoding is the character encoding of the output, one of "utf-8",
+	// "windows-1252", "iso-8859-1" or "auto" for the encoding of the input,
+	// which is read as windows-1252 unless it is UTF-8
+	Encoding string `yaml:"encoding"`
+	// ByteOrderMark is what becomes of the byte order mark starting a UTF-8
+	// input, either "keep" to write it before the output or "remove". It is
+	// never part of the input which is formatted, nor written in other
+	// encodings.
+	ByteOrderMark string `yaml:"byte-order-mark"`
+	// SyntaxErrors is what is written for a source with syntax errors, either
+	// "abort", nothing, "passthrough", the source unchanged, or "partial", the
+	// source formatted except for the smallest elements, equations,
+	// statements, lists of them or classes holding the errors, which are
+	// copied as they are. Either way the errors are returned as a
+	// *SyntaxError. FormatStream always aborts, Render aborts rather than
+	// passing the source through.
+	SyntaxErrors string `yaml:"syntax-errors"`
+	// MaxBlankLines is the maximum number of consecutive blank lines kept
+	// from the input between statements
+	MaxBlankLines int `yaml:"max-blank-lines"`
+	// PreserveBlankLines also keeps the blank lines of the input, up to
+	// MaxBlankLines, between the items of lists written one item per line,
+	// such as the modifications of a component, which authors use to group
+	// related items
+	PreserveBlankLines bool `yaml:"preserve-blank-lines"`
+	// SeparateSections puts exactly one blank line before the sections and
+	// the annotation of a class and between consecutive class definitions
+	SeparateSections bool `yaml:"separate-sections"`
+	// BlankLineAfterWithin puts exactly one blank line after the within
+	// clause. Without it the blank lines following the clause are kept, up
+	// to MaxBlankLines.
+	BlankLineAfterWithin bool `yaml:"blank-line-after-within"`
+	// EndBlankLineAfter is the number of lines from which a class is long
+	// enough for one blank line to be written before its `end`, including
+	// right after the `end` of a nested class. Zero never writes one.
+	// FormatStream, which does not know the length of a class when writing
+	// its `end`, rejects it.
+	EndBlankLineAfter int `yaml:"end-blank-line-after"`
+	// FinalNewline ends the output with a newline. Without it the newline
+	// ending the last line is left out.
+	FinalNewline bool `yaml:"final-newline"`
+	// SplitDeclarations rewrites component clauses declaring several
+	// components, e.g. `Real a, b;`, into one declaration per component.
+	// Declarations of replaceable components with a constraining clause are
+	// left as they are.
+	SplitDeclarations bool `yaml:"split-declarations"`
+	// SortImports sorts the import clauses alphabetically within each group
+	// of imports which are not separated by a blank line, a comment or
+	// another element. FormatStream, which formats each import on its own,
+	// rejects it.
+	SortImports bool `yaml:"sort-imports"`
+	// DropEmptyModifications removes the empty modifications outside
+	// annotations, which have no meaning, e.g. `Constant c();` is written as
+	// `Constant c;`
+	DropEmptyModifications bool `yaml:"drop-empty-modifications"`
+	// DropEmptyAnnotations removes the annotations without arguments, such as
+	// `annotation ()`, and the empty arguments of annotations, such as
+	// `Icon()`, which graphical editors leave behind
+	DropEmptyAnnotations bool `yaml:"drop-empty-annotations"`
+	// AlignParameters aligns the '=' of consecutive parameter declarations
+	// which are not separated by a blank line or a comment, e.g.
+	// `parameter Real k =1` and `parameter Modelica.SIunits.Time Ti=0.5`.
+	// Declarations with a class modification are not aligned. FormatStream,
+	// which formats each declaration on its own, rejects it.
+	AlignParameters bool `yaml:"align-parameters"`
+	// AlignDescriptions keeps the description strings of component
+	// declarations written on a single line at the end of the line, aligned
+	// with the descriptions of the consecutive declarations which are not
+	// separated by a blank line or a comment. Descriptions which would not fit
+	// within MaxLineLength are written on their own line. FormatStream,
+	// which formats each declaration on its own, rejects it.
+	AlignDescriptions bool `yaml:"align-descriptions"`
+	// AlignDeclarations lays out consecutive component declarations written
+	// on a single line as a table, aligning their types, names, bindings and
+	// descriptions in columns. It implies AlignParameters and
+	// AlignDescriptions. FormatStream, which formats each declaration on its
+	// own, rejects it.
+	AlignDeclarations bool `yaml:"align-declarations"`
+	// AlignAssignments aligns the ':=' of consecutive assignments of
+	// algorithm sections which are not separated by a blank line or a
+	// comment. FormatStream, which formats each statement on its own,
+	// rejects it.
+	AlignAssignments bool `yaml:"align-assignments"`
+	// AlignArguments aligns the '=' of the arguments of modification lists
+	// and function calls written one per line, e.g. the bindings of a
+	// redeclared record. Arguments with a class modification, such as
+	// `x(start=1)=2`, are not aligned.
+	AlignArguments bool `yaml:"align-arguments"`
+	// AlignMatrices writes each row of a matrix such as `[1, 2; 3, 4]` or
+	// `{{1, 2}, {3, 4}}` on its own line and right-aligns the elements of
+	// its columns. Vectors of vectors within annotations, such as extents,
+	// are left as they are.
+	AlignMatrices bool `yaml:"align-matrices"`
+	// SplitDescriptions splits description strings which do not fit within
+	// MaxLineLength at spaces into several strings concatenated with `+`, one
+	// per line
+	SplitDescriptions bool `yaml:"split-descriptions"`
+	// Indent is the number of spaces per indentation level. With UseTabs it is
+	// the width of a tab when measuring the length of lines.
+	Indent int `yaml:"indent"`
+	// UseTabs indents lines with one tab per indentation level instead of
+	// spaces. Whitespace aligning text after the indentation is still written
+	// as spaces.
+	UseTabs bool `yaml:"use-tabs"`
+	// TabWidth is the width of the tabs indenting the lines of the source, a
+	// tab reaching the next multiple of it, which places block comments
+	// indented with tabs. The lines of comments and of text kept as it is,
+	// except for regions with formatting turned off, are indented like the
+	// output. With UseTabs tabs are as wide as Indent instead.
+	TabWidth int `yaml:"tab-width"`
+	// HalfDedentVisibility writes the protected and public keywords half an
+	// indentation level inside the class, e.g. one space in with Indent 2,
+	// instead of lined up with the class header. It has no effect with
+	// UseTabs.
+	HalfDedentVisibility bool `yaml:"half-dedent-visibility"`
+	// MaxLineLength is the length beyond which modification lists, function
+	// call arguments and vectors are wrapped with one item per line. The
+	// closing brace of a vector wrapped outside annotations is written on its
+	// own line, lined up with the line of the opening brace. Zero disables
+	// wrapping.
+	MaxLineLength int `yaml:"max-line-length"`
+	// MaxVectorElements is the number of elements beyond which vectors outside
+	// annotations are wrapped like those exceeding MaxLineLength, even when
+	// they fit on the line. Zero disables the limit.
+	MaxVectorElements int `yaml:"max-vector-elements"`
+	// Arguments is the layout of the function call arguments and modification
+	// lists outside annotations, either "expand", which always writes each
+	// argument on its own line, or "compact", which keeps them on one line
+	// and only wraps them, one per line, when they do not fit within
+	// MaxLineLength. The attributes of type aliases, such as
+	// `type Percentage=Real(min=0,max=100)`, are always compact.
+	Arguments string `yaml:"arguments"`
+	// MaxInlineModifications is the number of arguments up to which the
+	// modification lists outside annotations, such as `k(start=1,fixed=true)`,
+	// are kept on one line when they fit within MaxLineLength, whatever the
+	// Arguments layout. Lists with more arguments are written one argument
+	// per line. Zero leaves modification lists to the Arguments layout.
+	MaxInlineModifications int `yaml:"max-inline-modifications"`
+	// OperatorWrap is how expressions which do not fit within MaxLineLength
+	// are broken, one operand per line: "none", which leaves them on one
+	// line, "leading", which starts the continuation lines with the operators
+	// such as `+` or `and`, or "trailing", which ends the broken lines with
+	// them
+	OperatorWrap string `yaml:"operator-wrap"`
+	// ThenPlacement is where the `then` of if and when clauses, and the
+	// `loop` of while loops, go when their condition is broken: "trailing",
+	// at the end of the last line of the condition, or "own-line", on a line
+	// of its own. Conditions which do not fit within MaxLineLength are broken
+	// before their `and` and `or`, or after them with OperatorWrap
+	// "trailing".
+	ThenPlacement string `yaml:"then-placement"`
+	// SpaceAroundRange writes spaces around the `:` of ranges, as in
+	// `1 : n` or `1 : 2 : n`, rather than `1:n`. A `:` standing for a whole
+	// dimension, as in `x[:,1]`, is written as is.
+	SpaceAroundRange bool `yaml:"space-around-range"`
+	// SpaceAroundMultiplication writes spaces around the `*` and `/` of
+	// expressions and their element-wise `.*` and `./`, as in `a * b`,
+	// rather than `a*b`
+	SpaceAroundMultiplication bool `yaml:"space-around-multiplication"`
+	// SpaceAroundExponent writes spaces around the `^` and `.^` of
+	// expressions, as in `x ^ 2`, rather than `x^2`
+	SpaceAroundExponent bool `yaml:"space-around-exponent"`
+	// SpaceAroundEquations writes spaces around the `=` of equations, as in
+	// `y = k*u`, rather than `y=k*u`. The `=` of bindings and modifications,
+	// as in `k=1`, is written without spaces.
+	SpaceAroundEquations bool `yaml:"space-around-equations"`
+	// SpaceInsideBrackets writes spaces inside parentheses, brackets and
+	// braces, as in `f( x )`, `x[ i ]` or `{ 1, 2 }`, rather than `f(x)`.
+	// Empty pairs such as `f()` are written as they are.
+	SpaceInsideBrackets bool `yaml:"space-inside-brackets"`
+	// NormalizeComments writes line comments with one space between the `//`
+	// and their text, as in `// comment`, rather than `//comment`. Banners
+	// such as `//!` or `//----` and text indented by several spaces are kept
+	// as they are.
+	NormalizeComments bool `yaml:"normalize-comments"`
+	// ReflowComments re-wraps the paragraphs of line comments with a line
+	// longer than MaxLineLength, keeping the comments separating paragraphs,
+	// such as `//`, and those which are not prose, such as banners
+	ReflowComments bool `yaml:"reflow-comments"`
+	// AnnotationPlacement is where the annotations of declarations,
+	// equations and statements go: "own-line", on an indented line of their
+	// own, or "trailing", on the line of what they annotate. Class
+	// annotations are always on a line of their own.
+	AnnotationPlacement string `yaml:"annotation-placement"`
+	// DescriptionPlacement is where the description strings of classes,
+	// declarations, equations and statements go: "own-line", on an indented
+	// line of their own, or
+	// "trailing", on the line of what they describe when they fit within
+	// MaxLineLength. Descriptions aligned by AlignDescriptions or
+	// AlignDeclarations are always trailing.
+	DescriptionPlacement string `yaml:"description-placement"`
+	// Graphics is the layout of Icon and Diagram annotations, one of
+	// "expand", which writes each argument and graphical primitive on its own
+	// line, wrapping the arguments of a primitive only if it does not fit,
+	// "expand-all", which also writes each argument of a primitive on its
+	// own line, "compact", which only wraps them to fit within
+	// MaxLineLength, or "preserve", which keeps them exactly as they are
+	// written
+	Graphics string `yaml:"graphics"`
+	// Placement is the layout of the Placement annotations of components,
+	// either "wrap", which wraps their arguments when they do not fit within
+	// MaxLineLength, or "single-line", which keeps them on one line however
+	// long it is
+	Placement string `yaml:"placement"`
+	// Experiment is the layout of the experiment annotation of a class, either
+	// "single-line", which keeps all its arguments on one line however long
+	// it is, or "expand", which always writes each argument on its own line
+	Experiment string `yaml:"experiment"`
+	// Documentation is the layout of the Documentation annotation of a
+	// class, either "expand", which writes each of its arguments, such as
+	// info and revisions, on its own line when the class annotation is
+	// expanded, or "compact", which writes them one after the other, as in
+	// `Documentation(info="<html>...</html>", revisions="<html>...</html>")`
+	Documentation string `yaml:"documentation"`
+	// SpaceAfterAnnotation writes a space between the annotation keyword and
+	// its opening bracket, as in `annotation (`, which is the style of the
+	// Modelica Standard Library, rather than `annotation(`
+	SpaceAfterAnnotation bool `yaml:"space-after-annotation"`
+	// SpaceInAnnotationVectors writes a space after the commas separating the
+	// elements of the vectors of annotations, as in `{-100, -100}`, rather
+	// than `{-100,-100}` as graphical editors write them
+	SpaceInAnnotationVectors bool `yaml:"space-in-annotation-vectors"`
+	// PreserveVendorAnnotations writes vendor-specific annotations, whose
+	// names start with two underscores such as __Dymola_Commands or
+	// __OpenModelica_simulationFlags, exactly as they are written
+	PreserveVendorAnnotations bool `yaml:"preserve-vendor-annotations"`
+	// Rules are hooks overriding the built-in formatting rules, see Rule
+	Rules []Rule `yaml:"-"`
+}
+
+// handlings of the byte order mark, see Options.ByteOrderMark
+const (
+	byteOrderMarkKeep   = "keep"
+	byteOrderMarkRemove = "remove"
+)
+
+// handlings of syntax errors, see Options.SyntaxErrors
+const (
+	SyntaxErrorsAbort       = "abort"
+	SyntaxErrorsPassthrough = "passthrough"
+	SyntaxErrorsPartial     = "partial"
+)
+
+// layouts of arguments, see Options.Arguments
+const (
+	argumentsExpand  = "expand"
+	argumentsCompact = "compact"
+)
+
+// positions of the operators of broken expressions, see Options.OperatorWrap
+const (
+	operatorWrapNone     = "none"
+	operatorWrapLeading  = "leading"
+	operatorWrapTrailing = "trailing"
+)
+
+// placements of the then of broken conditions, see Options.ThenPlacement
+const (
+	thenTrailing = "trailing"
+	thenOwnLine  = "own-line"
+)
+
+// placements of annotations, see Options.AnnotationPlacement
+const (
+	annotationOwnLine  = "own-line"
+	annotationTrailing = "trailing"
+)
+
+// placements of descriptions, see Options.DescriptionPlacement
+const (
+	descriptionOwnLine  = "own-line"
+	descriptionTrailing = "trailing"
+)
+
+// layouts of graphical annotations, see Options.Graphics
+const (
+	graphicsExpand    = "expand"
+	graphicsExpandAll = "expand-all"
+	graphicsCompact   = "compact"
+	graphicsPreserve  = "preserve"
+)
+
+// layouts of the documentation annotation, see Options.Documentation
+const (
+	documentationExpand  = "expand"
+	documentationCompact = "compact"
+)
+
+// layouts of placement annotations, see Options.Placement
+const (
+	placementWrap       = "wrap"
+	placementSingleLine = "single-line"
+)
+
+// layouts of the experiment annotation, see Options.Experiment
+const (
+	experimentSingleLine = "single-line"
+	experimentExpand     = "expand"
+)
+
+// DefaultOptions returns the options used when none are specified
+func DefaultOptions() Options {
+	return Options{
+		LineEndings:               "lf",
+		Encoding:                  "auto",
+		ByteOrderMark:             byteOrderMarkKeep,
+		SyntaxErrors:              SyntaxErrorsAbort,
+		FinalNewline:              true,
+		MaxBlankLines:             2,
+		SeparateSections:          true,
+		BlankLineAfterWithin:      true,
+		Arguments:                 argumentsExpand,
+		OperatorWrap:              operatorWrapNone,
+		ThenPlacement:             thenTrailing,
+		AnnotationPlacement:       annotationOwnLine,
+		DescriptionPlacement:      descriptionOwnLine,
+		Graphics:                  graphicsExpand,
+		Placement:                 placementWrap,
+		Experiment:                experimentSingleLine,
+		Documentation:             documentationExpand,
+		SpaceAfterAnnotation:      true,
+		SpaceInAnnotationVectors:  true,
+		NormalizeComments:         true,
+		PreserveVendorAnnotations: true,
+		Indent:                    2,
+		TabWidth:                  4,
+		MaxLineLength:             100,
+	}
+}
+
+// names of the style presets, see StyleOptions
+const (
+	StyleDefault   = "default"
+	StyleMSL       = "msl"
+	StyleBuildings = "buildings"
+	StyleCompact   = "compact"
+	StyleExpanded  = "expanded"
+)
+
+// StyleOptions returns the options of a named style preset, which individual
+// options then override like DefaultOptions:
+//   - "default" is DefaultOptions
+//   - "msl" follows the conventions of the Modelica Standard Library:
+//     descriptions on the line of their declaration, placements on one
+//     line, vectors of annotations without spaces, spaced equations,
+//     compact arguments and documentation, and graphics kept as the
+//     graphical editors wrote them
+//   - "buildings" follows the conventions of the Modelica Buildings Library:
+//     descriptions and annotations on their own lines, expanded class
+//     annotations and graphics, placements on one line and vectors of
+//     annotations without spaces
+//   - "compact" writes as few lines as possible, wrapping lists only when
+//     they do not fit and keeping annotations on the line they annotate
+//   - "expanded" writes one argument per line everywhere and aligns
+//     declarations, assignments and arguments
+//
+// An empty name is the default style.
+func StyleOptions(style string) (Options, error) {
+	opts := DefaultOptions()
+	switch style {
+	case "", StyleDefault:
+	case StyleMSL:
+		opts.Arguments = argumentsCompact
+		opts.Graphics = graphicsPreserve
+		opts.DescriptionPlacement = descriptionTrailing
+		opts.Placement = placementSingleLine
+		opts.Documentation = documentationCompact
+		opts.SpaceInAnnotationVectors = false
+		opts.SpaceAroundEquations = true
+		opts.SeparateSections = false
+		opts.BlankLineAfterWithin = false
+	case StyleBuildings:
+		opts.Arguments = argumentsCompact
+		opts.MaxInlineModifications = 1
+		opts.Graphics = graphicsExpandAll
+		opts.Placement = placementSingleLine
+		opts.SpaceInAnnotationVectors = false
+		opts.BlankLineAfterWithin = false
+	case StyleCompact:
+		opts.Arguments = argumentsCompact
+		opts.Graphics = graphicsCompact
+		opts.AnnotationPlacement = annotationTrailing
+		opts.MaxBlankLines = 1
+		opts.DropEmptyModifications = true
+		opts.DropEmptyAnnotations = true
+	case StyleExpanded:
+		opts.Experiment = experimentExpand
+		opts.AlignDeclarations = true
+		opts.AlignAssignments = true
+		opts.AlignArguments = true
+		opts.AlignMatrices = true
+	default:
+		return opts, fmt.Errorf("unsupported style %q", style)
+	}
+	return opts, nil
+}
+
+// streamUnsupported returns the keys of the options which are set but which
+// FormatStream cannot honor, as they depend on the statements around the one
+// being formatted or, for SyntaxErrors, on the whole source parsing
+func (o Options) streamUnsupported() []string {
+	var keys []string
+	if o.EndBlankLineAfter > 0 {
+		keys = append(keys, "end-blank-line-after")
+	}
+	if o.SortImports {
+		keys = append(keys, "sort-imports")
+	}
+	if o.AlignParameters {
+		keys = append(keys, "align-parameters")
+	}
+	if o.AlignDescriptions {
+		keys = append(keys, "align-descriptions")
+	}
+	if o.AlignDeclarations {
+		keys = append(keys, "align-declarations")
+	}
+	if o.AlignAssignments {
+		keys = append(keys, "align-assignments")
+	}
+	if o.SyntaxErrors != SyntaxErrorsAbort {
+		keys = append(keys, "syntax-errors")
+	}
+	return keys
+}
+
+// validate returns an error if an option has an unsupported value
+func (o Options) validate() error {
+	if _, ok := lineEndings[o.LineEndings]; !ok {
+		return fmt.Errorf("unsupported line endings %q", o.LineEndings)
+	}
+	if _, ok := encoders[o.Encoding]; !ok {
+		return fmt.Errorf("unsupported encoding %q", o.Encoding)
+	}
+	if o.ByteOrderMark != byteOrderMarkKeep && o.ByteOrderMark != byteOrderMarkRemove {
+		return fmt.Errorf("unsupported byte order mark handling %q", o.ByteOrderMark)
+	}
+	if o.SyntaxErrors != SyntaxErrorsAbort && o.SyntaxErrors != SyntaxErrorsPassthrough && o.SyntaxErrors != SyntaxErrorsPartial {
+		return fmt.Errorf("unsupported syntax errors handling %q", o.SyntaxErrors)
+	}
+	if o.Indent < 1 {
+		return fmt.Errorf("indent must be positive, got %d", o.Indent)
+	}
+	if o.TabWidth < 1 {
+		return fmt.Errorf("tab width must be positive, got %d", o.TabWidth)
+	}
+	if o.MaxBlankLines < 0 {
+		return fmt.Errorf("max blank lines must not be negative, got %d", o.MaxBlankLines)
+	}
+	if o.MaxInlineModifications < 0 {
+		return fmt.Errorf("max inline modifications must not be negative, got %d", o.MaxInlineModifications)
+	}
+	if o.EndBlankLineAfter < 0 {
+		return fmt.Errorf("end blank line after must not be negative, got %d", o.EndBlankLineAfter)
+	}
+	if o.Arguments != argumentsExpand && o.Arguments != argumentsCompact {
+		return fmt.Errorf("unsupported arguments layout %q", o.Arguments)
+	}
+	if o.OperatorWrap != operatorWrapNone && o.OperatorWrap != operatorWrapLeading && o.OperatorWrap != operatorWrapTrailing {
+		return fmt.Errorf("unsupported operator wrap %q", o.OperatorWrap)
+	}
+	if o.ThenPlacement != thenTrailing && o.ThenPlacement != thenOwnLine {
+		return fmt.Errorf("unsupported then placement %q", o.ThenPlacement)
+	}
+	if o.AnnotationPlacement != annotationOwnLine && o.AnnotationPlacement != annotationTrailing {
+		return fmt.Errorf("unsupported annotation placement %q", o.AnnotationPlacement)
+	}
+	if o.DescriptionPlacement != descriptionOwnLine && o.DescriptionPlacement != descriptionTrailing {
+		return fmt.Errorf("unsupported description placement %q", o.DescriptionPlacement)
+	}
+	if o.Graphics != graphicsExpand && o.Graphics != graphicsExpandAll && o.Graphics != graphicsCompact && o.Graphics != graphicsPreserve {
+		return fmt.Errorf("unsupported graphics layout %q", o.Graphics)
+	}
+	if o.Placement != placementWrap && o.Placement != placementSingleLine {
+		return fmt.Errorf("unsupported placement layout %q", o.Placement)
+	}
+	if o.Experiment != experimentSingleLine && o.Experiment != experimentExpand {
+		return fmt.Errorf("unsupported experiment layout %q", o.Experiment)
+	}
+	if o.Documentation != documentationExpand && o.Documentation != documentationCompact {
+		return fmt.Errorf("unsupported documentation layout %q", o.Documentation)
+	}
+	if o.MaxLineLength < 0 {
+		return fmt.Errorf("max line length must not be negative, got %d", o.MaxLineLength)
+	}
+	if o.MaxVectorElements < 0 {
+		return fmt.Errorf("max vector elements must not be negative, got %d", o.MaxVectorElements)
+	}
+	return nil
+}
+
+// tabWidth returns the width of the tabs of the source, see Options.TabWidth
+func (o Options) tabWidth() int {
+	if o.UseTabs {
+		return o.Indent
+	}
+	return o.TabWidth
+}
+
+// indentation returns the whitespace indenting a line by level levels
+func (o Options) indentation(level int) string {
+	if o.UseTabs {
+		return strings.Repeat("\t", level)
+	}
+	return strings.Repeat(" ", o.Indent*level)
+}
@@ -0,0 +1,309 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+var (
+	// utf8BOM is the byte order mark which some editors, such as Dymola, write
+	// at the start of UTF-8 files
+	utf8BOM = []byte("\xEF\xBB\xBF")
+
+	// newline sequences by line ending option, empty for the line endings
+	// detected in the input
+	lineEndings = map[string]string{
+		"lf":   "\n",
+		"crlf": "\r\n",
+		"auto": "",
+	}
+
+	// encoders by encoding option, nil for the encoding detected in the
+	// input. An encoder returns false if the rune has no representation in the
+	// encoding
+	encoders = map[string]func(r rune, buf []byte) ([]byte, bool){
+		"auto":         nil,
+		"utf-8":        encodeUTF8,
+		"iso-8859-1":   encodeLatin1,
+		"windows-1252": encodeWindows1252,
+	}
+
+	// windows1252 maps the runes which windows-1252 places in 0x80-0x9F
+	windows1252 = map[rune]byte{
+		'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+		'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+		'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+		'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+	}
+)
+
+func encodeUTF8(r rune, buf []byte) ([]byte, bool) {
+	var encoded [utf8.UTFMax]byte
+	n := utf8.EncodeRune(encoded[:], r)
+	return append(buf, encoded[:n]...), true
+}
+
+func encodeLatin1(r rune, buf []byte) ([]byte, bool) {
+	if r > 0xFF {
+		return buf, false
+	}
+	return append(buf, byte(r)), true
+}
+
+func encodeWindows1252(r rune, buf []byte) ([]byte, bool) {
+	if b, ok := windows1252[r]; ok {
+		return append(buf, b), true
+	}
+	if r >= 0x80 && r <= 0x9F && decodeWindows1252(byte(r)) != r {
+		return buf, false
+	}
+	return encodeLatin1(r, buf)
+}
+
+// decodeWindows1252 returns the rune of a windows-1252 byte. The bytes which
+// windows-1252 leaves undefined are read as in iso-8859-1, so that any text is
+// encoded back to the same bytes.
+func decodeWindows1252(b byte) rune {
+	if b >= 0x80 && b <= 0x9F {
+		for r, encoded := range windows1252 {
+			if encoded == b {
+				return r
+			}
+		}
+	}
+	return rune(b)
+}
+
+// inputDecoder is a reader which decodes the text read through it to UTF-8,
+// detecting its encoding from its first non-ASCII character: a text which is
+// not UTF-8 is read as windows-1252. It also detects the line endings of the
+// text from its first newline. A byte order mark starting the text is not read
+// through, it is only recorded.
+type inputDecoder struct {
+	in       io.Reader
+	newline  string // empty until a newline is read
+	lastCR   bool   // true if the last byte read is a carriage return
+	bom      bool   // true if the text starts with a byte order mark
+	encoding string // empty until a non-ASCII character is read
+	started  bool   // true once the byte order mark is looked for
+	line     int    // 1-based line of the text read so far
+	buf      []byte
+	raw      []byte // bytes read from in, not decoded yet
+	decoded  []byte // decoded text not read through yet
+	err      error  // error of in, returned once the text read before it is
+}
+
+func newInputDecoder(in io.Reader) *inputDecoder {
+	return &inputDecoder{in: in, line: 1}
+}
+
+func (d *inputDecoder) Read(p []byte) (int, error) {
+	for len(d.decoded) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		d.fill()
+	}
+
+	n := copy(p, d.decoded)
+	d.decoded = d.decoded[n:]
+	for i := 0; i < n && d.newline == ""; i++ {
+		if p[i] == '\n' {
+			d.newline = "\n"
+			if d.lastCR {
+				d.newline = "\r\n"
+			}
+		}
+		d.lastCR = p[i] == '\r'
+	}
+	return n, nil
+}
+
+// fill reads from in and decodes what it can of the bytes read so far. An
+// incomplete UTF-8 sequence is left for the next call unless in is exhausted.
+func (d *inputDecoder) fill() {
+	if d.buf == nil {
+		d.buf = make([]byte, 4096)
+	}
+	n, err := d.in.Read(d.buf)
+	d.raw = append(d.raw, d.buf[:n]...)
+	d.err = err
+	complete := err != nil
+
+	if !d.started {
+		if len(d.raw) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, d.raw) && !complete {
+			return
+		}
+		d.started = true
+		if bytes.HasPrefix(d.raw, utf8BOM) {
+			d.bom = true
+			d.encoding = "utf-8"
+			d.raw = d.raw[len(utf8BOM):]
+		}
+	}
+
+	d.decoded = d.decoded[:0]
+	for len(d.raw) > 0 {
+		b := d.raw[0]
+		if b < utf8.RuneSelf {
+			if b == '\n' {
+				d.line++
+			}
+			d.decoded = append(d.decoded, b)
+			d.raw = d.raw[1:]
+			continue
+		}
+		if !utf8.FullRune(d.raw) && !complete {
+			break
+		}
+		r, size := utf8.DecodeRune(d.raw)
+		if d.encoding == "" {
+			d.encoding = "utf-8"
+			if r == utf8.RuneError && size == 1 {
+				d.encoding = "windows-1252"
+			}
+		}
+		if d.encoding != "utf-8" {
+			r, size = decodeWindows1252(b), 1
+		} else if r == utf8.RuneError && size == 1 {
+			d.err = fmt.Errorf("invalid UTF-8 on line %d of a UTF-8 input", d.line)
+			d.raw = nil
+			break
+		}
+		d.decoded = append(d.decoded, string(r)...)
+		d.raw = d.raw[size:]
+	}
+}
+
+// Decode returns the text of a source, which is read as windows-1252 unless
+// it is UTF-8, without its byte order mark
+func Decode(input []byte) (string, error) {
+	text, err := ioutil.ReadAll(newInputDecoder(bytes.NewReader(input)))
+	return string(text), err
+}
+
+// lineEnding returns the newline detected so far, or "\n" if there is none
+func (d *inputDecoder) lineEnding() string {
+	if d.newline == "" {
+		return "\n"
+	}
+	return d.newline
+}
+
+// inputEncoding returns the encoding detected so far, or "utf-8" if there is
+// none
+func (d *inputDecoder) inputEncoding() string {
+	if d.encoding == "" {
+		return "utf-8"
+	}
+	return d.encoding
+}
+
+// original returns text read through the decoder as it was read, encoded in
+// the detected encoding and starting with the byte order mark if there was
+// one
+func (d *inputDecoder) original(text []byte) []byte {
+	var original []byte
+	if d.bom {
+		original = append(original, utf8BOM...)
+	}
+	encode := encoders[d.inputEncoding()]
+	for _, r := range string(text) {
+		// every character decoded from windows-1252 is encoded back
+		original, _ = encode(r, original)
+	}
+	return original
+}
+
+// outputWriter is the output layer shared by every write path. It receives
+// UTF-8 text using "\n" newlines and writes it to out using the line endings
+// and encoding selected by the options. Automatic line endings are those of
+// the input read through input when the first newline is written, so is the
+// byte order mark kept by Options.ByteOrderMark, and the automatic encoding is
+// that of the input when the first non-ASCII character is written. Without
+// Options.FinalNewline the newline ending the output is left out.
+type outputWriter struct {
+	out          io.Writer
+	newline      string
+	input        *inputDecoder
+	finalNewline bool
+	keepBOM      bool
+	written      bool // true once something is written
+	encoding     string
+	encode       func(r rune, buf []byte) ([]byte, bool)
+	pending      []byte // incomplete UTF-8 sequence left over from the last write
+	held         bool   // true if a newline is held back until more text follows
+	buf          []byte
+}
+
+func newOutputWriter(out io.Writer, opts Options, input *inputDecoder) *outputWriter {
+	w := &outputWriter{out: out, input: input}
+	w.configure(opts)
+	return w
+}
+
+// configure applies the output options, before anything is written
+func (w *outputWriter) configure(opts Options) {
+	w.newline = lineEndings[opts.LineEndings]
+	w.finalNewline = opts.FinalNewline
+	// the byte order mark is only meaningful in UTF-8
+	w.keepBOM = opts.ByteOrderMark == byteOrderMarkKeep && (opts.Encoding == "utf-8" || opts.Encoding == "auto")
+	w.encoding = opts.Encoding
+	w.encode = encoders[opts.Encoding]
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	text := append(w.pending, p...)
+	w.buf = w.buf[:0]
+	if !w.written && len(p) > 0 {
+		w.written = true
+		if w.keepBOM && w.input.bom {
+			w.buf = append(w.buf, utf8BOM...)
+		}
+	}
+	for len(text) > 0 {
+		if !utf8.FullRune(text) {
+			break
+		}
+		r, size := utf8.DecodeRune(text)
+		if w.held {
+			w.buf = append(w.buf, w.newline...)
+			w.held = false
+		}
+		if r == '\n' {
+			if w.newline == "" {
+				w.newline = w.input.lineEnding()
+			}
+			if w.finalNewline {
+				w.buf = append(w.buf, w.newline...)
+			} else {
+				// the newline is only written once it is known not to end
+				// the output
+				w.held = true
+			}
+		} else {
+			encoding, encode := w.encoding, w.encode
+			if encode == nil {
+				encoding = w.input.inputEncoding()
+				encode = encoders[encoding]
+			}
+			var ok bool
+			if w.buf, ok = encode(r, w.buf); !ok {
+				return 0, fmt.Errorf("cannot encode %q as %s", r, encoding)
+			}
+		}
+		text = text[size:]
+	}
+	w.pending = append(w.pending[:0], text...)
+
+	if _, err := w.out.Write(w.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,204 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputWriter(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.LineEndings = "crlf"
+	opts.Encoding = "windows-1252"
+
+	var b bytes.Buffer
+	w := newOutputWriter(&b, opts, nil)
+	text := []byte("\"°C – €\"\n")
+	// split the input inside of a multi-byte sequence
+	_, err := w.Write(text[:2])
+	a.NoError(err)
+	_, err = w.Write(text[2:])
+	a.NoError(err)
+
+	a.Equal([]byte("\"\xb0C \x96 \x80\"\r\n"), b.Bytes())
+}
+
+func TestOutputWriterUnencodable(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Encoding = "iso-8859-1"
+
+	var b bytes.Buffer
+	_, err := newOutputWriter(&b, opts, nil).Write([]byte("\"€\"\n"))
+
+	a.Error(err)
+}
+
+func TestLineEndingsAuto(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.LineEndings = "auto"
+
+	out, err := FormatString("model A\r\n  /* a\r\n  b */\r\n  Real x;\r\nend A;\r\n", opts)
+
+	a.NoError(err)
+	a.Equal("model A\r\n  /* a\r\n  b */ Real x;\r\nend A;\r\n", out)
+
+	out, err = FormatString("model A\n  Real x;\nend A;\n", opts)
+
+	a.NoError(err)
+	a.Equal("model A\n  Real x;\nend A;\n", out)
+}
+
+func TestLineEndingsAutoStream(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.LineEndings = "auto"
+
+	var b bytes.Buffer
+	err := FormatStream(context.Background(), strings.NewReader("model A\r\n  Real x;\r\nend A;\r\n"), &b, opts)
+
+	a.NoError(err)
+	a.Equal("model A\r\n  Real x;\r\nend A;\r\n", b.String())
+}
+
+func TestInputDecoder(t *testing.T) {
+	a := require.New(t)
+
+	// the carriage return and the line feed are read separately
+	decoder := newInputDecoder(iotest.OneByteReader(strings.NewReader("model A\r\nend A;")))
+	_, err := ioutil.ReadAll(decoder)
+
+	a.NoError(err)
+	a.Equal("\r\n", decoder.lineEnding())
+	a.Equal("\n", newInputDecoder(strings.NewReader("")).lineEnding())
+
+	// a multi-byte sequence is read a byte at a time
+	decoder = newInputDecoder(iotest.OneByteReader(strings.NewReader("\"°C\" \"\xb0C\"")))
+	text, err := ioutil.ReadAll(decoder)
+	a.EqualError(err, "invalid UTF-8 on line 1 of a UTF-8 input")
+	a.Equal("\"°C\" \"", string(text))
+
+	decoder = newInputDecoder(iotest.OneByteReader(strings.NewReader("\"\xb0C \x96 \x80 \x81\"\n\"°C\"")))
+	text, err = ioutil.ReadAll(decoder)
+	a.NoError(err)
+	a.Equal("\"°C – € \u0081\"\n\"Â°C\"", string(text))
+	a.Equal("windows-1252", decoder.inputEncoding())
+	a.Equal([]byte("\"\xb0C \x96 \x80 \x81\"\n\"°C\""), decoder.original(text), "Any byte should be encoded back")
+}
+
+func TestLegacyEncoding(t *testing.T) {
+	a := require.New(t)
+	source := "model A \"Temperature in \xb0C\" Real x; // \xfcber\nend A;\n"
+
+	out, err := FormatString(source, DefaultOptions())
+	a.NoError(err)
+	a.Equal("model A\n  \"Temperature in \xb0C\"\n  Real x; // \xfcber\nend A;\n", out, "The output should keep the encoding of the input")
+
+	var b bytes.Buffer
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(out, b.String())
+
+	opts := DefaultOptions()
+	opts.Encoding = "utf-8"
+	out, err = FormatString(source, opts)
+	a.NoError(err)
+	a.Equal("model A\n  \"Temperature in °C\"\n  Real x; // über\nend A;\n", out)
+
+	a.NoError(VerifyTokens([]byte(source), []byte(out)), "The tokens should be compared once decoded")
+
+	sd, diagnostics := Parse(strings.NewReader(source))
+	a.Empty(diagnostics)
+	a.Equal("Temperature in °C", sd.Classes()[0].Description())
+
+	opts = DefaultOptions()
+	opts.SyntaxErrors = SyntaxErrorsPassthrough
+	b.Reset()
+	err = Format(context.Background(), strings.NewReader("model A \"\xb0C\" Real x end A;"), &b, opts)
+	a.IsType(&SyntaxError{}, err)
+	a.Equal("model A \"\xb0C\" Real x end A;", b.String())
+}
+
+func TestFinalNewline(t *testing.T) {
+	a := require.New(t)
+	sources := []string{
+		"model A end A;",
+		"model A end A;\n\n\n",
+		"model A end A; // comment",
+		"model A end A; /* comment */\n\n",
+		"model A annotation (Evaluate=true); end A;",
+	}
+	for _, source := range sources {
+		out, err := FormatString(source, DefaultOptions())
+
+		a.NoError(err)
+		a.True(strings.HasSuffix(out, "\n"), "output of %q should end with a newline", source)
+		a.False(strings.HasSuffix(out, "\n\n"), "output of %q should end with a single newline", source)
+	}
+}
+
+func TestFinalNewlineDisabled(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.FinalNewline = false
+	opts.LineEndings = "crlf"
+
+	out, err := FormatString("model A Real x; end A; // comment\n", opts)
+
+	a.NoError(err)
+	a.Equal("model A\r\n  Real x;\r\nend A; // comment", out)
+
+	var b bytes.Buffer
+	err = FormatStream(context.Background(), strings.NewReader("model A Real x; end A;\n"), &b, opts)
+
+	a.NoError(err)
+	a.Equal("model A\r\n  Real x;\r\nend A;", b.String())
+}
+
+func TestByteOrderMark(t *testing.T) {
+	a := require.New(t)
+	source := "\xEF\xBB\xBFmodel A Real x; end A;\n"
+
+	out, err := FormatString(source, DefaultOptions())
+	a.NoError(err)
+	a.Equal("\xEF\xBB\xBFmodel A\n  Real x;\nend A;\n", out, "The byte order mark should be kept")
+
+	var b bytes.Buffer
+	err = FormatStream(context.Background(), iotest.OneByteReader(strings.NewReader(source)), &b, DefaultOptions())
+	a.NoError(err)
+	a.Equal(out, b.String())
+
+	opts := DefaultOptions()
+	opts.ByteOrderMark = byteOrderMarkRemove
+	out, err = FormatString(source, opts)
+	a.NoError(err)
+	a.Equal("model A\n  Real x;\nend A;\n", out)
+
+	opts = DefaultOptions()
+	opts.Encoding = "windows-1252"
+	out, err = FormatString(source, opts)
+	a.NoError(err)
+	a.Equal("model A\n  Real x;\nend A;\n", out, "The byte order mark should only be written in UTF-8")
+
+	out, err = FormatString("model A Real x; end A;\n", DefaultOptions())
+	a.NoError(err)
+	a.Equal("model A\n  Real x;\nend A;\n", out, "No byte order mark should be added")
+
+	sd, diagnostics := Parse(strings.NewReader(source))
+	a.Empty(diagnostics)
+	a.Equal(1, sd.Classes()[0].Position().Column)
+
+	opts = DefaultOptions()
+	opts.SyntaxErrors = SyntaxErrorsPassthrough
+	b.Reset()
+	err = Format(context.Background(), strings.NewReader("\xEF\xBB\xBFmodel A Real x end A;"), &b, opts)
+	a.IsType(&SyntaxError{}, err)
+	a.Equal("\xEF\xBB\xBFmodel A Real x end A;", b.String())
+}
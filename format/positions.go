@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"sort"
+)
+
+// Position is a location in Modelica source
+type Position struct {
+	Line   int `json:"line"`   // 1-based line
+	Column int `json:"column"` // 1-based column, counted in characters
+}
+
+// before returns true if p comes before other
+func (p Position) before(other Position) bool {
+	return p.Line < other.Line || (p.Line == other.Line && p.Column < other.Column)
+}
+
+// advancePosition returns the position following text when it starts at p
+func advancePosition(p Position, text string) Position {
+	for _, r := range text {
+		if r == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+	return p
+}
+
+// tokenSpan is a token written to the output
+type tokenSpan struct {
+	input  Position // start of the token in the source
+	output Position // start of the token in the output
+	text   string
+}
+
+// PositionMap maps positions in the source to positions in the formatted
+// output and back. It is returned by FormatWithPositions.
+//
+// A position within a token, or right after it, maps to the same place in the
+// token's counterpart. A position within whitespace maps to the start of the
+// next token, or to the end of the last token when none follows.
+type PositionMap struct {
+	byInput  []tokenSpan // ordered by input position
+	byOutput []tokenSpan // ordered by output position
+}
+
+// add records that the token with text starting at input is written at output.
+// Tokens are added in the order of the output, which differs from that of the
+// source when tokens are moved, such as the imports sorted by
+// Options.SortImports. Tokens which are written again, such as the types
+// repeated when splitting declarations, are only recorded the first time.
+func (m *PositionMap) add(input, output Position, text string) {
+	i := sort.Search(len(m.byInput), func(i int) bool { return input.before(m.byInput[i].input) })
+	if i > 0 && m.byInput[i-1].input == input {
+		return
+	}
+	span := tokenSpan{input, output, text}
+	m.byInput = append(m.byInput, tokenSpan{})
+	copy(m.byInput[i+1:], m.byInput[i:])
+	m.byInput[i] = span
+	m.byOutput = append(m.byOutput, span)
+}
+
+// OutputPosition returns the position in the output corresponding to the
+// position p in the source
+func (m *PositionMap) OutputPosition(p Position) Position {
+	return lookup(m.byInput, p, func(s tokenSpan) Position { return s.input }, func(s tokenSpan) Position { return s.output })
+}
+
+// InputPosition returns the position in the source corresponding to the
+// position p in the output
+func (m *PositionMap) InputPosition(p Position) Position {
+	return lookup(m.byOutput, p, func(s tokenSpan) Position { return s.output }, func(s tokenSpan) Position { return s.input })
+}
+
+// lookup maps p, a position on the from side of the spans, which are ordered
+// by that side, to the to side
+func lookup(spans []tokenSpan, p Position, from, to func(tokenSpan) Position) Position {
+	if len(spans) == 0 {
+		return Position{Line: 1, Column: 1}
+	}
+
+	// find the last token starting at or before p
+	i := sort.Search(len(spans), func(i int) bool { return p.before(from(spans[i])) }) - 1
+	if i < 0 {
+		return to(spans[0])
+	}
+
+	span := spans[i]
+	if offset, ok := textOffset(from(span), span.text, p); ok {
+		return advancePosition(to(span), span.text[:offset])
+	}
+	if i+1 < len(spans) {
+		return to(spans[i+1])
+	}
+	return advancePosition(to(span), span.text)
+}
+
+// textOffset returns the byte offset in text, which starts at start, of the
+// position p. A position past the end of a line within text maps to the end of
+// that line. It returns false if p is past the end of text.
+func textOffset(start Position, text string, p Position) (int, bool) {
+	position := start
+	for offset, r := range text {
+		if position == p || (r == '\n' && position.Line == p.Line && position.before(p)) {
+			return offset, true
+		}
+		position = advancePosition(position, string(r))
+	}
+	return len(text), position == p
+}
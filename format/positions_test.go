@@ -0,0 +1,69 @@
+package format
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPositionMap(t *testing.T) {
+	a := require.New(t)
+	source := "model A Real x=1 /* a\n  b */;\nend A;"
+
+	var out strings.Builder
+	positions, err := FormatWithPositions(context.Background(), strings.NewReader(source), &out, DefaultOptions())
+
+	a.NoError(err)
+	a.Equal("model A\n  Real x=1 /* a\n  b */;\nend A;\n", out.String())
+	testCases := []struct {
+		input  Position
+		output Position
+	}{
+		{Position{1, 1}, Position{1, 1}},   // model
+		{Position{1, 9}, Position{2, 3}},   // Real
+		{Position{1, 14}, Position{2, 8}},  // x
+		{Position{1, 15}, Position{2, 9}},  // =
+		{Position{1, 18}, Position{2, 12}}, // comment
+		{Position{2, 3}, Position{3, 3}},   // inside of the comment
+		{Position{2, 7}, Position{3, 7}},   // ;
+		{Position{3, 5}, Position{4, 5}},   // A
+		{Position{3, 7}, Position{4, 7}},   // end of file
+	}
+	for _, testCase := range testCases {
+		a.Equal(testCase.output, positions.OutputPosition(testCase.input), "input %v", testCase.input)
+		a.Equal(testCase.input, positions.InputPosition(testCase.output), "output %v", testCase.output)
+	}
+
+	// positions after the last token map to its end
+	a.Equal(Position{4, 7}, positions.OutputPosition(Position{5, 1}))
+}
+
+func TestPositionMapSortedImports(t *testing.T) {
+	a := require.New(t)
+	source := "package P\n  import Modelica.Units;\n  import Buildings.Fluid;\nend P;"
+	opts := DefaultOptions()
+	opts.SortImports = true
+
+	var out strings.Builder
+	positions, err := FormatWithPositions(context.Background(), strings.NewReader(source), &out, opts)
+
+	a.NoError(err)
+	a.Equal("package P\n  import Buildings.Fluid;\n  import Modelica.Units;\nend P;\n", out.String())
+	testCases := []struct {
+		input  Position
+		output Position
+	}{
+		{Position{2, 3}, Position{3, 3}},   // import Modelica
+		{Position{2, 10}, Position{3, 10}}, // Modelica
+		{Position{2, 20}, Position{3, 20}}, // Units
+		{Position{3, 10}, Position{2, 10}}, // Buildings
+		{Position{3, 19}, Position{2, 19}}, // Fluid
+		{Position{4, 5}, Position{4, 5}},   // P
+	}
+	for _, testCase := range testCases {
+		a.Equal(testCase.output, positions.OutputPosition(testCase.input), "input %v", testCase.input)
+		a.Equal(testCase.input, positions.InputPosition(testCase.output), "output %v", testCase.output)
+	}
+}
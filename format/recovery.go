@@ -0,0 +1,106 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// recoveryUnit returns true if the rule may be copied as it is on its own when
+// it holds a syntax error, from the smallest, such as an equation, to the
+// largest, the whole source
+func recoveryUnit(rule antlr.ParserRuleContext) bool {
+	if rule.GetStop() == nil || rule.GetStop().GetTokenIndex() < rule.GetStart().GetTokenIndex() {
+		// an empty rule, such as the element list of a class without elements
+		return false
+	}
+	switch rule.GetRuleIndex() {
+	case parser.ModelicaParserRULE_element,
+		parser.ModelicaParserRULE_equation,
+		parser.ModelicaParserRULE_statement,
+		parser.ModelicaParserRULE_model_annotation,
+		parser.ModelicaParserRULE_element_list,
+		parser.ModelicaParserRULE_equations,
+		parser.ModelicaParserRULE_algorithm_statements,
+		parser.ModelicaParserRULE_class_definition,
+		parser.ModelicaParserRULE_stored_definition:
+		return true
+	}
+	return false
+}
+
+// brokenRules returns the smallest rules of the tree holding the syntax
+// errors. The parser leaves an error node in the tree for each token it skips
+// or makes up, while the characters the lexer drops are only known by the
+// diagnostics. A parser error which left no error node, such as a rule ended
+// early, left every token in the tree.
+func brokenRules(tree antlr.ParserRuleContext, diagnostics []Diagnostic) map[antlr.ParserRuleContext]bool {
+	broken := make(map[antlr.ParserRuleContext]bool)
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Rule != "" {
+			continue
+		}
+		if unit := unitAt(tree, Position{Line: diagnostic.Line, Column: diagnostic.Column}); unit != nil {
+			broken[unit] = true
+		}
+	}
+	markErrorNodes(tree, broken)
+	return broken
+}
+
+// unitAt returns the smallest recovery unit of the tree spanning the position,
+// or nil if there is none
+func unitAt(tree antlr.ParserRuleContext, position Position) antlr.ParserRuleContext {
+	var unit antlr.ParserRuleContext
+	for rule := tree; rule != nil; {
+		if recoveryUnit(rule) {
+			unit = rule
+		}
+		var next antlr.ParserRuleContext
+		for _, child := range rule.GetChildren() {
+			if child, ok := child.(antlr.ParserRuleContext); ok && spans(child, position) {
+				next = child
+				break
+			}
+		}
+		rule = next
+	}
+	return unit
+}
+
+// spans returns true if the source of the rule, from its first character to
+// the one following its last, holds the position
+func spans(rule antlr.ParserRuleContext, position Position) bool {
+	start, stop := rule.GetStart(), rule.GetStop()
+	if stop == nil || stop.GetTokenIndex() < start.GetTokenIndex() {
+		return false
+	}
+	end := advancePosition(Position{Line: stop.GetLine(), Column: stop.GetColumn() + 1}, stop.GetText())
+	return !position.before(Position{Line: start.GetLine(), Column: start.GetColumn() + 1}) && !end.before(position)
+}
+
+// markErrorNodes marks the recovery units holding the error nodes of the tree
+// as broken. A token the parser made up to complete a rule, such as a missing
+// `;`, belongs to the unit it completes.
+func markErrorNodes(tree antlr.Tree, broken map[antlr.ParserRuleContext]bool) {
+	for i, child := range tree.GetChildren() {
+		if _, ok := child.(antlr.ErrorNode); !ok {
+			markErrorNodes(child, broken)
+			continue
+		}
+		if i > 0 && child.(antlr.ErrorNode).GetSymbol().GetTokenIndex() < 0 {
+			if previous, ok := tree.GetChild(i - 1).(antlr.ParserRuleContext); ok && recoveryUnit(previous) {
+				broken[previous] = true
+				continue
+			}
+		}
+		for rule, _ := tree.(antlr.ParserRuleContext); rule != nil; rule, _ = rule.GetParent().(antlr.ParserRuleContext) {
+			if recoveryUnit(rule) {
+				broken[rule] = true
+				break
+			}
+		}
+	}
+}
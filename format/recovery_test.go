@@ -0,0 +1,59 @@
+package format
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyntaxErrorsPartial(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SyntaxErrors = SyntaxErrorsPartial
+
+	cases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{
+			name:     "missing semicolon",
+			source:   "model A\n  Real   x\n  Real   y;\nequation\n  x  =  1;\nend A;\n",
+			expected: "model A\n  Real   x\n  Real y;\n\nequation\n  x=1;\nend A;\n",
+		},
+		{
+			name:     "dropped character",
+			source:   "model A\n  Real x = $1 ;\n  Real   y;\nend A;\n",
+			expected: "model A\n  Real x = $1;\n  Real y;\nend A;\n",
+		},
+		{
+			name:     "skipped token",
+			source:   "model A\n  Real   x;\nequation\n  x = 1 1;\n  y  =  2;\nend A;\n",
+			expected: "model A\n  Real x;\n\nequation\n  x = 1 1;\n  y  =  2;\nend A;\n",
+		},
+		{
+			name:     "class",
+			source:   "package P\n  model A\n  end B\n  model C\n      Real   y;\n  end C;\nend P;\n",
+			expected: "package P\n  model A\n  end B\n  model C\n    Real y;\n  end C;\nend P;\n",
+		},
+	}
+	for _, c := range cases {
+		out, err := FormatString(c.source, opts)
+		a.IsType(&SyntaxError{}, err, c.name)
+		a.Equal(c.expected, out, c.name)
+	}
+}
+
+func TestBrokenRules(t *testing.T) {
+	a := require.New(t)
+	sd, diagnostics := Parse(strings.NewReader("model A\n  Real x = $1;\n  Real y\nequation\n  y = 1;\nend A;\n"))
+	broken := brokenRules(sd.node, diagnostics)
+	var names []string
+	for rule := range broken {
+		names = append(names, RuleNode{rule}.Name()+" "+sourceText(rule))
+	}
+	sort.Strings(names)
+	a.Equal([]string{"element Real x = $1", "element Real y"}, names)
+}
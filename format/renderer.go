@@ -0,0 +1,132 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bufio"
+	"html"
+	"io"
+	"unicode"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// TokenKind classifies the tokens given to a Renderer
+type TokenKind int
+
+const (
+	// Keyword is a reserved word such as "model" or "equation"
+	Keyword TokenKind = iota
+	// Identifier is a name
+	Identifier
+	// Number is a numeric literal
+	Number
+	// String is a string literal
+	String
+	// Operator is an operator or a punctuation mark
+	Operator
+	// Comment is a block or line comment. The text of a line comment does not
+	// include the newline ending it.
+	Comment
+)
+
+// tokenKindNames are the names of the token kinds
+var tokenKindNames = map[TokenKind]string{
+	Keyword:    "keyword",
+	Identifier: "identifier",
+	Number:     "number",
+	String:     "string",
+	Operator:   "operator",
+	Comment:    "comment",
+}
+
+func (k TokenKind) String() string {
+	return tokenKindNames[k]
+}
+
+// Renderer produces the output of the formatter. The formatter decides the
+// layout and calls the renderer for each piece of it, in order.
+type Renderer interface {
+	// Token writes a token or a comment
+	Token(kind TokenKind, text string)
+	// Space writes the space separating two tokens on the same line
+	Space()
+	// Newline ends the current line
+	Newline()
+	// Indent writes the whitespace indenting the start of a line. It is not
+	// called for lines without indentation.
+	Indent(indentation string)
+	// Flush writes any buffered output, returning the first error which
+	// occurred while writing
+	Flush() error
+}
+
+// textRenderer renders plain Modelica source
+type textRenderer struct {
+	writer *bufio.Writer
+}
+
+// NewTextRenderer returns a renderer writing formatted Modelica source to out
+func NewTextRenderer(out io.Writer) Renderer {
+	return &textRenderer{bufio.NewWriter(out)}
+}
+
+func (r *textRenderer) Token(kind TokenKind, text string) {
+	r.writer.WriteString(text)
+}
+
+func (r *textRenderer) Space() {
+	r.writer.WriteString(" ")
+}
+
+func (r *textRenderer) Newline() {
+	r.writer.WriteString("\n")
+}
+
+func (r *textRenderer) Indent(indentation string) {
+	r.writer.WriteString(indentation)
+}
+
+func (r *textRenderer) Flush() error {
+	return r.writer.Flush()
+}
+
+// htmlRenderer renders syntax highlighted Modelica source as HTML
+type htmlRenderer struct {
+	textRenderer
+}
+
+// NewHTMLRenderer returns a renderer writing formatted Modelica source as an
+// HTML fragment to out. Each token is wrapped in a span whose class is its
+// kind, e.g. <span class="keyword">model</span>. The fragment is meant to be
+// placed in a pre element.
+func NewHTMLRenderer(out io.Writer) Renderer {
+	return &htmlRenderer{textRenderer{bufio.NewWriter(out)}}
+}
+
+func (r *htmlRenderer) Token(kind TokenKind, text string) {
+	r.writer.WriteString(`<span class="` + kind.String() + `">`)
+	r.writer.WriteString(html.EscapeString(text))
+	r.writer.WriteString("</span>")
+}
+
+// tokenKind returns the kind of a token produced by the lexer
+func tokenKind(token antlr.Token) TokenKind {
+	switch token.GetTokenType() {
+	case parser.ModelicaLexerIDENT:
+		return Identifier
+	case parser.ModelicaLexerUNSIGNED_NUMBER:
+		return Number
+	case parser.ModelicaLexerSTRING:
+		return String
+	case parser.ModelicaLexerCOMMENT, parser.ModelicaLexerLINE_COMMENT:
+		return Comment
+	}
+	text := token.GetText()
+	if text != "" && unicode.IsLetter(rune(text[0])) {
+		return Keyword
+	}
+	return Operator
+}
@@ -0,0 +1,24 @@
+package format
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLRenderer(t *testing.T) {
+	a := require.New(t)
+	var out strings.Builder
+
+	err := Render(context.Background(), strings.NewReader(`model A Real x=1 "a<b"; // c
+end A;`), NewHTMLRenderer(&out), DefaultOptions())
+
+	a.NoError(err)
+	a.Equal(`<span class="keyword">model</span> <span class="identifier">A</span>
+  <span class="identifier">Real</span> <span class="identifier">x</span><span class="operator">=</span><span class="number">1</span>
+    <span class="string">&#34;a&lt;b&#34;</span><span class="operator">;</span> <span class="comment">// c</span>
+<span class="keyword">end</span> <span class="identifier">A</span><span class="operator">;</span>
+`, out.String())
+}
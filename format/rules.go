@@ -0,0 +1,165 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+)
+
+// Decision is the answer of a Rule to a formatting question
+type Decision int
+
+const (
+	// Undecided leaves the decision to the next rule, or to the built-in rules
+	Undecided Decision = iota
+	// Insert inserts the newline, indentation or space
+	Insert
+	// Omit omits the newline, indentation or space
+	Omit
+)
+
+// Rule is a hook which overrides formatting decisions. Rules are set in
+// Options.Rules and consulted in order, the first one which decides wins. When
+// all rules are undecided the built-in rules apply.
+//
+// Rules must be deterministic: a decision for a node may be asked for more
+// than once, for example when entering and when leaving it.
+type Rule interface {
+	// IndentBefore decides whether node is put on a new line and indented
+	IndentBefore(node RuleNode) Decision
+	// NewlineBefore decides whether node is put on a new line without
+	// changing the indentation
+	NewlineBefore(node RuleNode) Decision
+	// SpaceBefore decides whether a space separates the token with text
+	// current from the token with text previous on the same line
+	SpaceBefore(previous, current string) Decision
+}
+
+// BaseRule is a Rule which is undecided on everything. Embed it to only
+// implement some of the methods of Rule.
+type BaseRule struct{}
+
+// IndentBefore implements Rule
+func (BaseRule) IndentBefore(node RuleNode) Decision { return Undecided }
+
+// NewlineBefore implements Rule
+func (BaseRule) NewlineBefore(node RuleNode) Decision { return Undecided }
+
+// SpaceBefore implements Rule
+func (BaseRule) SpaceBefore(previous, current string) Decision { return Undecided }
+
+// RuleNode is a node of the parse tree, as seen by rule hooks
+type RuleNode struct {
+	rule antlr.ParserRuleContext
+}
+
+// Name returns the name of the node's grammar rule, e.g. "element",
+// "annotation" or "function_argument", see thirdparty/Modelica.g4
+func (n RuleNode) Name() string {
+	withParser, ok := n.rule.(interface{ GetParser() antlr.Parser })
+	if !ok {
+		return ""
+	}
+	return withParser.GetParser().GetRuleNames()[n.rule.GetRuleIndex()]
+}
+
+// Parent returns the parent of the node, or false for the root of the tree
+func (n RuleNode) Parent() (RuleNode, bool) {
+	parent, ok := n.rule.GetParent().(antlr.ParserRuleContext)
+	if !ok || parent == nil {
+		return RuleNode{}, false
+	}
+	return RuleNode{parent}, true
+}
+
+// Within returns true if the node or one of its ancestors is named name
+func (n RuleNode) Within(name string) bool {
+	for node, ok := n, true; ok; node, ok = node.Parent() {
+		if node.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Text returns the text of the node's tokens, without whitespace and comments
+func (n RuleNode) Text() string {
+	return n.rule.GetText()
+}
+
+// decide returns the first decision of the rules which is not Undecided
+func decide(rules []Rule, fn func(Rule) Decision) Decision {
+	for _, rule := range rules {
+		if decision := fn(rule); decision != Undecided {
+			return decision
+		}
+	}
+	return Undecided
+}
+
+// indentBefore returns true if the rule should be on a new line and indented,
+// as decided by the rule hooks or else by insertIndentBefore
+func (l *modelicaListener) indentBefore(rule antlr.ParserRuleContext) bool {
+	switch decide(l.opts.Rules, func(r Rule) Decision { return r.IndentBefore(RuleNode{rule}) }) {
+	case Insert:
+		return true
+	case Omit:
+		return false
+	}
+	return l.insertIndentBefore(rule)
+}
+
+// newlineBefore returns true if the rule should be on a new line, as decided
+// by the rule hooks or else by insertNewlineBefore
+func (l *modelicaListener) newlineBefore(rule antlr.ParserRuleContext) bool {
+	switch decide(l.opts.Rules, func(r Rule) Decision { return r.NewlineBefore(RuleNode{rule}) }) {
+	case Insert:
+		return true
+	case Omit:
+		return false
+	}
+	return l.insertNewlineBefore(rule)
+}
+
+// spaceBefore returns true if a space should be inserted before the token, as
+// decided by the rule hooks or else by the spacing tables
+func (l *modelicaListener) spaceBefore(token antlr.Token, role operatorRole) bool {
+	return l.spaceBetween(l.previousTokenText, l.spaceAfterPrevious, token.GetText(), role)
+}
+
+// spaceBetween returns true if a space should separate the token with text
+// current from the token with text previous, as decided by the rule hooks,
+// Options.SpaceAfterAnnotation, Options.SpaceInsideBrackets or else by the
+// spacing tables.
+// spaceAfterPrevious is true when the previous token must be followed by a
+// space. role is that of the current token if it is an operator spaced by its
+// context, see operatorRole.
+func (l *modelicaListener) spaceBetween(previous string, spaceAfterPrevious bool, current string, role operatorRole) bool {
+	switch decide(l.opts.Rules, func(r Rule) Decision { return r.SpaceBefore(previous, current) }) {
+	case Insert:
+		return true
+	case Omit:
+		return false
+	}
+	if previous == "annotation" && current == "(" {
+		return l.opts.SpaceAfterAnnotation
+	}
+	if l.opts.SpaceInsideBrackets && (openingBrackets[previous] || closingBrackets[current]) {
+		// except between the brackets of an empty pair, as in `f()`
+		return !openingBrackets[previous] || !closingBrackets[current]
+	}
+	if previous == "," {
+		// see insertSpaceAfterTerminal
+		return spaceAfterPrevious
+	}
+	switch role {
+	case noOperator:
+	case signOperator:
+		// spaced like the operand it precedes, as in `x > -1` or `{-1,-2}`
+		return spaceAfterPrevious || !noSpaceAfter[previous]
+	default:
+		return l.spacedOperator(role)
+	}
+	return spaceAfterPrevious || insertSpaceBeforeToken(current, previous)
+}
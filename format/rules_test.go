@@ -0,0 +1,80 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// compactAnnotations keeps annotations on the line of their element and
+// separates equal signs with spaces
+type compactAnnotations struct {
+	BaseRule
+}
+
+func (compactAnnotations) IndentBefore(node RuleNode) Decision {
+	if node.Name() == "annotation" {
+		return Omit
+	}
+	return Undecided
+}
+
+func (compactAnnotations) SpaceBefore(previous, current string) Decision {
+	if previous == "=" || current == "=" {
+		return Insert
+	}
+	return Undecided
+}
+
+func TestRules(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.Rules = []Rule{compactAnnotations{}}
+
+	out, err := FormatString(`model A
+  parameter Real k = 1 annotation (Evaluate = true);
+end A;`, opts)
+
+	a.NoError(err)
+	a.Equal(`model A
+  parameter Real k = 1 annotation (Evaluate = true);
+end A;
+`, out)
+}
+
+func TestRuleNode(t *testing.T) {
+	a := require.New(t)
+	var names []string
+	var within []bool
+	opts := DefaultOptions()
+	opts.Rules = []Rule{recordingRule{func(node RuleNode) {
+		if node.Text() == "true" {
+			names = append(names, node.Name())
+			within = append(within, node.Within("annotation"))
+		}
+	}}}
+
+	_, err := FormatString("model A Real k annotation (Evaluate=true); end A;", opts)
+
+	a.NoError(err)
+	a.Contains(names, "expression")
+	a.NotContains(within, false)
+}
+
+// recordingRule calls fn for every node it is asked about
+type recordingRule struct {
+	fn func(node RuleNode)
+}
+
+func (r recordingRule) IndentBefore(node RuleNode) Decision {
+	r.fn(node)
+	return Undecided
+}
+
+func (r recordingRule) NewlineBefore(node RuleNode) Decision {
+	return Undecided
+}
+
+func (r recordingRule) SpaceBefore(previous, current string) Decision {
+	return Undecided
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// optionDoc documents a key of the configuration file, see ConfigSchema and
+// WriteDefaultConfig
+type optionDoc struct {
+	key         string
+	description string   // a single line, written after the value of the key
+	values      []string // values of a string option, nil for any
+	minimum     int      // smallest value of an integer option
+}
+
+// styles are the names of the style presets, see StyleOptions
+var styles = []string{StyleDefault, StyleMSL, StyleBuildings, StyleCompact, StyleExpanded}
+
+// optionDocs documents the keys of the configuration file, in the order in
+// which `config init` writes them
+var optionDocs = []optionDoc{
+	{key: "style", description: "preset which the other options override: default, msl, buildings, compact or expanded", values: styles},
+	{key: "line-endings", description: "lf, crlf or auto to keep the line endings of the input", values: []string{"lf", "crlf", "auto"}},
+	{key: "encoding", description: "utf-8, windows-1252, iso-8859-1 or auto to keep the encoding of the input, read as windows-1252 unless it is UTF-8", values: []string{"utf-8", "windows-1252", "iso-8859-1", "auto"}},
+	{key: "byte-order-mark", description: "keep or remove the byte order mark starting a UTF-8 input", values: []string{byteOrderMarkKeep, byteOrderMarkRemove}},
+	{key: "syntax-errors", description: "write nothing (abort), the input unchanged (passthrough) or the input formatted except for the elements, equations, statements or classes holding the errors (partial) for files with syntax errors", values: []string{SyntaxErrorsAbort, SyntaxErrorsPassthrough, SyntaxErrorsPartial}},
+	{key: "final-newline", description: "end the output with a newline"},
+	{key: "max-blank-lines", description: "keep at most this many consecutive blank lines between statements"},
+	{key: "preserve-blank-lines", description: "also keep blank lines between the items of lists written one per line"},
+	{key: "arguments", description: "layout of function call arguments and modifications outside annotations: expand, one per line, or compact, wrapped only when too long", values: []string{argumentsExpand, argumentsCompact}},
+	{key: "max-inline-modifications", description: "keep modification lists with at most this many arguments on one line when they fit, break longer ones, 0 to follow arguments"},
+	{key: "operator-wrap", description: "break expressions longer than max-line-length before (leading) or after (trailing) their operators, or none", values: []string{operatorWrapNone, operatorWrapLeading, operatorWrapTrailing}},
+	{key: "then-placement", description: "where then and loop go when a long condition is broken: trailing or own-line", values: []string{thenTrailing, thenOwnLine}},
+	{key: "space-around-range", description: "write `1 : n` rather than `1:n` in for loops, subscripts and range expressions"},
+	{key: "space-around-multiplication", description: "write `a * b` rather than `a*b`, also for /, .* and ./"},
+	{key: "space-around-exponent", description: "write `x ^ 2` rather than `x^2`, also for .^"},
+	{key: "space-around-equations", description: "write `y = k*u` rather than `y=k*u` in equations"},
+	{key: "space-inside-brackets", description: "write `f( x )`, `x[ i ]` and `{ 1, 2 }` rather than `f(x)`, `x[i]` and `{1, 2}`"},
+	{key: "normalize-comments", description: "write `//comment` as `// comment`, leaving banners such as `//!` or `//----` alone"},
+	{key: "reflow-comments", description: "re-wrap paragraphs of // comments with lines longer than max-line-length"},
+	{key: "annotation-placement", description: "where the annotations of declarations, equations and statements go: own-line or trailing", values: []string{annotationOwnLine, annotationTrailing}},
+	{key: "description-placement", description: "where description strings go: own-line or trailing, on the line of what they describe when they fit", values: []string{descriptionOwnLine, descriptionTrailing}},
+	{key: "graphics", description: "layout of Icon and Diagram annotations: expand, expand-all to also put the arguments of primitives on their own lines, compact or preserve", values: []string{graphicsExpand, graphicsExpandAll, graphicsCompact, graphicsPreserve}},
+	{key: "placement", description: "layout of Placement annotations: wrap, when longer than max-line-length, or single-line", values: []string{placementWrap, placementSingleLine}},
+	{key: "documentation", description: "layout of Documentation annotations: expand, one argument per line, or compact", values: []string{documentationExpand, documentationCompact}},
+	{key: "space-after-annotation", description: "write `annotation (` rather than `annotation(`"},
+	{key: "space-in-annotation-vectors", description: "write `{-100, -100}` rather than `{-100,-100}` in annotations"},
+	{key: "preserve-vendor-annotations", description: "keep __Dymola_*, __OpenModelica_* and other vendor annotations exactly as written"},
+	{key: "experiment", description: "layout of the experiment annotation: single-line or expand, one argument per line", values: []string{experimentSingleLine, experimentExpand}},
+	{key: "blank-line-after-within", description: "exactly one blank line after the within clause, rather than those of the input"},
+	{key: "separate-sections", description: "one blank line before equation, algorithm, public, protected and the class annotation, and between classes"},
+	{key: "end-blank-line-after", description: "one blank line before the end of classes of at least this many lines, 0 to disable"},
+	{key: "align-parameters", description: "align the = of consecutive parameter declarations"},
+	{key: "align-descriptions", description: "keep descriptions at the end of single line declarations, aligned to a common column"},
+	{key: "align-declarations", description: "align the types, names, bindings and descriptions of single line declarations in columns"},
+	{key: "align-assignments", description: "align the := of consecutive assignments in algorithm sections"},
+	{key: "align-arguments", description: "align the = of arguments written one per line"},
+	{key: "align-matrices", description: "write each row of a matrix on its own line and align its columns"},
+	{key: "split-descriptions", description: "split description strings longer than max-line-length into strings joined with +"},
+	{key: "split-declarations", description: "write `Real a, b;` as `Real a; Real b;`"},
+	{key: "sort-imports", description: "sort the imports of each group, separated by blank lines, alphabetically"},
+	{key: "drop-empty-modifications", description: "write `Constant c();` as `Constant c;`"},
+	{key: "drop-empty-annotations", description: "remove `annotation ()` and empty annotation arguments such as `Icon()`"},
+	{key: "indent", description: "number of spaces per indentation level, or the width of a tab with use-tabs", minimum: 1},
+	{key: "use-tabs", description: "indent with tabs instead of spaces"},
+	{key: "tab-width", description: "width of the tabs indenting the input, which are written as spaces without use-tabs, where tabs are as wide as indent", minimum: 1},
+	{key: "half-dedent-visibility", description: "write protected and public half an indentation level inside the class"},
+	{key: "max-line-length", description: "wrap lists making lines longer than this, 0 to disable"},
+	{key: "max-vector-elements", description: "also wrap vectors with more elements than this outside annotations, 0 for no limit"},
+}
+
+// profilesExample ends the configuration written by WriteDefaultConfig
+const profilesExample = `# profiles override options for the files matching their path patterns,
+# relative to this file, where ** matches any number of directories:
+# profiles:
+#   - paths: ["export/**"]
+#     line-endings: crlf
+#     encoding: windows-1252
+`
+
+// optionValues returns the values of the options by key of the
+// configuration file
+func optionValues(opts Options) map[string]interface{} {
+	values := make(map[string]interface{})
+	v := reflect.ValueOf(opts)
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if key != "" && key != "-" {
+			values[key] = v.Field(i).Interface()
+		}
+	}
+	return values
+}
+
+// WriteDefaultConfig writes a configuration file setting every option to its
+// value in the style preset, each with a comment describing it
+func WriteDefaultConfig(w io.Writer, style string) error {
+	opts, err := StyleOptions(style)
+	if err != nil {
+		return err
+	}
+	if style == "" {
+		style = StyleDefault
+	}
+	values := optionValues(opts)
+	values["style"] = style
+
+	var b strings.Builder
+	b.WriteString("# modelica-fmt configuration, see `modelicafmt config schema` for the values of each option\n")
+	for _, doc := range optionDocs {
+		fmt.Fprintf(&b, "%s: %v  # %s\n", doc.key, values[doc.key], doc.description)
+	}
+	b.WriteString(profilesExample)
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// JSONSchema is the subset of JSON Schema describing the configuration file,
+// see ConfigSchema
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type"`
+	Enum                 []string               `json:"enum,omitempty"`
+	Minimum              *int                   `json:"minimum,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+}
+
+// ConfigSchema returns the JSON Schema of the configuration file, generated
+// from the options, their defaults and optionDocs
+func ConfigSchema() *JSONSchema {
+	closed, one := false, 1
+	root := &JSONSchema{
+		Schema:               "http://json-schema.org/draft-07/schema#",
+		Title:                "modelica-fmt configuration",
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema),
+		AdditionalProperties: &closed,
+	}
+	profile := &JSONSchema{
+		Type:                 "object",
+		Properties:           make(map[string]*JSONSchema),
+		Required:             []string{"paths"},
+		AdditionalProperties: &closed,
+	}
+	profile.Properties["paths"] = &JSONSchema{
+		Description: "path patterns of the files, relative to the configuration file, where ** matches any number of directories",
+		Type:        "array",
+		Items:       &JSONSchema{Type: "string"},
+		MinItems:    &one,
+	}
+
+	defaults := optionValues(DefaultOptions())
+	defaults["style"] = StyleDefault
+	for _, doc := range optionDocs {
+		option := &JSONSchema{Description: doc.description, Default: defaults[doc.key]}
+		switch defaults[doc.key].(type) {
+		case bool:
+			option.Type = "boolean"
+		case int:
+			option.Type = "integer"
+			minimum := doc.minimum
+			option.Minimum = &minimum
+		default:
+			option.Type = "string"
+			option.Enum = doc.values
+		}
+		root.Properties[doc.key] = option
+		if doc.key != "style" {
+			// profiles override options, not the preset
+			profile.Properties[doc.key] = option
+		}
+	}
+	root.Properties["profiles"] = &JSONSchema{
+		Description: "option overrides for the files matching path patterns",
+		Type:        "array",
+		Items:       profile,
+	}
+	return root
+}
+
+// ValidateConfig checks the YAML content of a configuration file against the
+// schema, returning a diagnostic for each problem found, or an error if the
+// content is not YAML
+func ValidateConfig(content []byte, schema *JSONSchema) ([]Diagnostic, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		// an empty file sets no option
+		return nil, nil
+	}
+	return schema.validate(document.Content[0]), nil
+}
+
+// validate returns a diagnostic for each part of the node which does not
+// conform to the schema
+func (s *JSONSchema) validate(node *yaml.Node) []Diagnostic {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	var diagnostics []Diagnostic
+	report := func(at *yaml.Node, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{Line: at.Line, Column: at.Column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.Type {
+	case "object":
+		if node.Kind != yaml.MappingNode {
+			report(node, "expected a mapping")
+			break
+		}
+		keys := make(map[string]bool)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keys[key.Value] = true
+			property, ok := s.Properties[key.Value]
+			if !ok {
+				report(key, "unknown option %q", key.Value)
+				continue
+			}
+			diagnostics = append(diagnostics, property.validate(value)...)
+		}
+		for _, key := range s.Required {
+			if !keys[key] {
+				report(node, "missing %q", key)
+			}
+		}
+	case "array":
+		if node.Kind != yaml.SequenceNode {
+			report(node, "expected a list")
+			break
+		}
+		if s.MinItems != nil && len(node.Content) < *s.MinItems {
+			report(node, "expected at least %d item(s)", *s.MinItems)
+		}
+		for _, item := range node.Content {
+			diagnostics = append(diagnostics, s.Items.validate(item)...)
+		}
+	case "boolean":
+		var b bool
+		if node.Kind != yaml.ScalarNode || node.Decode(&b) != nil {
+			report(node, "expected true or false, got %q", node.Value)
+		}
+	case "integer":
+		var n int
+		if node.Kind != yaml.ScalarNode || node.Decode(&n) != nil {
+			report(node, "expected an integer, got %q", node.Value)
+		} else if s.Minimum != nil && n < *s.Minimum {
+			report(node, "must be at least %d, got %d", *s.Minimum, n)
+		}
+	case "string":
+		if node.Kind != yaml.ScalarNode {
+			report(node, "expected a string")
+		} else if len(s.Enum) > 0 && !tokenInGroup(node.Value, s.Enum) {
+			report(node, "unsupported value %q, expected one of %s", node.Value, strings.Join(s.Enum, ", "))
+		}
+	}
+	return diagnostics
+}
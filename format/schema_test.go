@@ -0,0 +1,89 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionDocs(t *testing.T) {
+	a := require.New(t)
+	documented := make(map[string]bool)
+	for _, doc := range optionDocs {
+		documented[doc.key] = true
+	}
+	values := optionValues(DefaultOptions())
+	for key := range values {
+		a.True(documented[key], "Option %q should be documented", key)
+	}
+	for key := range documented {
+		_, ok := values[key]
+		a.True(ok || key == "style", "Documented option %q should exist", key)
+	}
+
+	for _, doc := range optionDocs {
+		if doc.key == "style" {
+			continue
+		}
+		for _, value := range doc.values {
+			content := doc.key + ": " + value + "\n"
+			_, err := LoadConfig(writeConfig(t, content), "")
+			a.NoError(err, "Value %q of %q should be supported", value, doc.key)
+		}
+	}
+}
+
+func TestWriteDefaultConfig(t *testing.T) {
+	a := require.New(t)
+	for _, style := range styles {
+		var b bytes.Buffer
+		a.NoError(WriteDefaultConfig(&b, style))
+
+		diagnostics, err := ValidateConfig(b.Bytes(), ConfigSchema())
+		a.NoError(err)
+		a.Empty(diagnostics, style)
+
+		cfg, err := LoadConfig(writeConfig(t, b.String()), "")
+		a.NoError(err)
+		expected, err := StyleOptions(style)
+		a.NoError(err)
+		a.Equal(expected, cfg.options, "The configuration of %q should load back to its options", style)
+	}
+
+	a.Error(WriteDefaultConfig(&bytes.Buffer{}, "gnu"))
+}
+
+func TestValidateConfig(t *testing.T) {
+	a := require.New(t)
+	diagnostics, err := ValidateConfig([]byte(`indnt: 2
+indent: 0
+graphics: all
+use-tabs: maybe
+max-line-length: long
+style: gnu
+profiles:
+  - paths: []
+    style: msl
+  - encoding: windows-1252
+`), ConfigSchema())
+	a.NoError(err)
+	a.Equal([]Diagnostic{
+		{Line: 1, Column: 1, Message: `unknown option "indnt"`},
+		{Line: 2, Column: 9, Message: `must be at least 1, got 0`},
+		{Line: 3, Column: 11, Message: `unsupported value "all", expected one of expand, expand-all, compact, preserve`},
+		{Line: 4, Column: 11, Message: `expected true or false, got "maybe"`},
+		{Line: 5, Column: 18, Message: `expected an integer, got "long"`},
+		{Line: 6, Column: 8, Message: `unsupported value "gnu", expected one of default, msl, buildings, compact, expanded`},
+		{Line: 8, Column: 12, Message: `expected at least 1 item(s)`},
+		{Line: 9, Column: 5, Message: `unknown option "style"`},
+		{Line: 10, Column: 5, Message: `missing "paths"`},
+	}, diagnostics)
+
+	diagnostics, err = ValidateConfig(nil, ConfigSchema())
+	a.NoError(err)
+	a.Empty(diagnostics)
+
+	_, err = ValidateConfig([]byte("indent: [2"), ConfigSchema())
+	a.Error(err)
+}
@@ -0,0 +1,689 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// FormatStream formats like Format, but without loading the whole source into
+// memory. The source is lexed as it is read and parsed one statement (element,
+// equation, algorithm statement, ...) at a time, so memory use is bounded by
+// the largest statement rather than by the size of the file.
+//
+// Each statement is parsed together with a skeleton of the classes enclosing
+// it, i.e. their headers, the keywords of the current section and synthesized
+// `end` clauses. Only the tokens of the statement itself are written. The
+// options depending on the statements around the one being formatted, such
+// as AlignDeclarations, are rejected, which leaves the output the same as
+// Format's. The syntax errors are reported at their positions in the source,
+// but errors on which the parser recovers differently may be left out, and as
+// statements already written cannot be taken back, SyntaxErrors other than
+// abort are rejected too.
+//
+// As statements are written once formatted, out holds the output of the
+// statements preceding an error, such as a syntax error, when one is returned.
+func FormatStream(ctx context.Context, in io.Reader, out io.Writer, opts Options) (err error) {
+	var position Position
+	defer recoverPanic(&err, &position)
+
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if keys := opts.streamUnsupported(); len(keys) > 0 {
+		return fmt.Errorf("cannot format one statement at a time with %s", strings.Join(keys, ", "))
+	}
+
+	decoder := newInputDecoder(in)
+	input := newReaderStream(decoder)
+	lexer := parser.NewModelicaLexer(input)
+	collector := newDiagnosticCollector()
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(collector)
+	splitter := newStatementSplitter(ctx, lexer)
+	output := newOutputWriter(contextWriter{ctx, out}, opts, decoder)
+	renderer := NewTextRenderer(output)
+	// nothing is written before the directives of the file are read
+	directives := splitter.leadingComments()
+	if skipFile(directives) {
+		return ErrSkipped
+	}
+	if opts, err = fileOptions(opts, directives); err != nil {
+		return err
+	}
+	output.configure(opts)
+	var previous *modelicaListener
+	failed, cutShort := false, false
+	for {
+		statement, ok := splitter.next()
+		if !ok {
+			break
+		}
+
+		// all tokens are fetched by the token stream, hence the indexes of the
+		// statement's tokens follow the skeleton's tokens
+		tokens := append(append(append([]antlr.Token{}, statement.skeleton...), statement.tokens...), statement.closers...)
+		stream := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
+		tokenSource := newCommentCollector(ctx, newSliceTokenSource(lexer, tokens))
+		stream.SetTokenSource(&tokenSource)
+
+		p := parser.NewModelicaParser(stream)
+		p.RemoveErrorListeners()
+		errors := &statementErrorListener{diagnosticCollector: collector, source: input}
+		p.AddErrorListener(errors)
+		reported := len(collector.diagnostics)
+		sd := p.Stored_definition()
+		if len(collector.diagnostics) == reported && !errors.synthesized {
+			collector.diagnostics = append(collector.diagnostics, errorNodes(sd)...)
+		}
+		if len(collector.diagnostics) == reported && errors.synthesized {
+			// the tokens of the statement are fine but it is cut short by the
+			// end of the file
+			cutShort = true
+		}
+		if len(collector.diagnostics) > 0 || cutShort {
+			// the following statements are only parsed, to report the errors
+			// of the whole source
+			failed = true
+		}
+		if failed {
+			continue
+		}
+
+		listener := newListener(ctx, renderer, tokenSource.commentTokens, opts)
+		listener.sourcePosition = &position
+		listener.indents = input.indents.indents
+		if previous != nil {
+			// blank lines are counted from the end of the previous statement
+			listener.sourceLine = previous.sourceLine
+			listener.afterStatement = previous.afterStatement
+			listener.inWithin = previous.inWithin
+		}
+		listener.emitRange = antlr.Interval{
+			Start: len(statement.skeleton),
+			Stop:  len(statement.skeleton) + len(statement.tokens) - 1,
+		}
+		if statement.trailing {
+			// only comments are left at the end of the file
+			listener.commentTokens = nil
+			for _, token := range statement.tokens {
+				if isComment(token) {
+					listener.commentTokens = append(listener.commentTokens, token)
+				}
+			}
+		} else {
+			antlr.ParseTreeWalkerDefault.Walk(listener, sd)
+		}
+		listener.finish()
+		previous = listener
+	}
+
+	if cutShort || !failed && len(splitter.classes) > 0 {
+		// the end clauses synthesized for the statements hide the ones
+		// missing from the source. After an error the classes found by the
+		// splitter are unreliable.
+		failed = true
+		start := Position{Line: splitter.eof.GetLine(), Column: splitter.eof.GetColumn() + 1}
+		diagnostic := Diagnostic{Line: start.Line, Column: start.Column, End: start, Message: "unexpected end of file"}
+		if !cutShort {
+			diagnostic.Rule = "long_class_specifier"
+			diagnostic.Message += ", expecting 'end'"
+		}
+		collector.diagnostics = append(collector.diagnostics, diagnostic)
+	}
+	if failed {
+		return &SyntaxError{Diagnostics: collector.sorted()}
+	}
+	return renderer.Flush()
+}
+
+// statementErrorListener records the syntax errors found when parsing a
+// statement with the collector, leaving out the errors on the tokens which are
+// not read from the source but synthesized around the statement, i.e. the end
+// clauses and the end of file, whose positions are meaningless
+type statementErrorListener struct {
+	*diagnosticCollector
+	source      antlr.CharStream
+	synthesized bool // true if an error on a synthesized token was left out
+}
+
+func (l *statementErrorListener) SyntaxError(recognizer antlr.Recognizer, offendingSymbol interface{}, line, column int, msg string, e antlr.RecognitionException) {
+	if token, ok := offendingSymbol.(antlr.Token); ok && token.GetInputStream() != l.source {
+		l.synthesized = true
+		return
+	}
+	l.diagnosticCollector.SyntaxError(recognizer, offendingSymbol, line, column, msg, e)
+}
+
+// readerStream is an ANTLR character stream which reads runes on demand and
+// only keeps the runes of the token being lexed and of the previous token. It
+// records the indentation of the lines indented with tabs as it reads them.
+type readerStream struct {
+	reader    *bufio.Reader
+	indents   *indentScanner
+	data      []rune // buffered runes, data[0] is at index base
+	base      int
+	index     int
+	marks     int
+	markIndex int  // index of the first mark, i.e. the start of the current token
+	eof       bool // true when the reader is exhausted
+}
+
+func newReaderStream(in io.Reader) *readerStream {
+	return &readerStream{reader: bufio.NewReader(in), indents: newIndentScanner()}
+}
+
+// fill buffers runes until index i is buffered or the reader is exhausted
+func (s *readerStream) fill(i int) {
+	for !s.eof && i >= s.base+len(s.data) {
+		r, _, err := s.reader.ReadRune()
+		if err != nil {
+			s.eof = true
+			return
+		}
+		s.data = append(s.data, r)
+		s.indents.scan(r)
+	}
+}
+
+func (s *readerStream) Consume() {
+	if s.LA(1) == antlr.TokenEOF {
+		panic("cannot consume EOF")
+	}
+	s.index++
+}
+
+func (s *readerStream) LA(offset int) int {
+	if offset == 0 {
+		return 0
+	}
+	if offset < 0 {
+		offset++
+	}
+	pos := s.index + offset - 1
+	s.fill(pos)
+	if pos < s.base || pos >= s.base+len(s.data) {
+		return antlr.TokenEOF
+	}
+	return int(s.data[pos-s.base])
+}
+
+func (s *readerStream) Mark() int {
+	if s.marks == 0 {
+		s.markIndex = s.index
+	}
+	s.marks++
+	return -s.marks
+}
+
+func (s *readerStream) Release(marker int) {
+	s.marks--
+	if s.marks > 0 {
+		return
+	}
+	// drop everything before the token which was just lexed, which is kept
+	// so its text can still be read
+	if drop := s.markIndex - s.base; drop > 0 {
+		s.data = append(s.data[:0], s.data[drop:]...)
+		s.base = s.markIndex
+	}
+}
+
+func (s *readerStream) Index() int {
+	return s.index
+}
+
+func (s *readerStream) Seek(index int) {
+	s.index = index
+}
+
+func (s *readerStream) Size() int {
+	return s.base + len(s.data)
+}
+
+func (s *readerStream) GetSourceName() string {
+	return "<stream>"
+}
+
+func (s *readerStream) GetText(start, stop int) string {
+	if start < s.base {
+		start = s.base
+	}
+	if stop >= s.base+len(s.data) {
+		stop = s.base + len(s.data) - 1
+	}
+	if start > stop {
+		return ""
+	}
+	return string(s.data[start-s.base : stop-s.base+1])
+}
+
+func (s *readerStream) GetTextFromTokens(start, end antlr.Token) string {
+	return s.GetText(start.GetStart(), end.GetStop())
+}
+
+func (s *readerStream) GetTextFromInterval(i *antlr.Interval) string {
+	return s.GetText(i.Start, i.Stop)
+}
+
+// sliceTokenSource is a token source returning a fixed list of tokens followed
+// by EOF. It embeds the lexer which produced the tokens to satisfy the rest of
+// the interface.
+type sliceTokenSource struct {
+	antlr.TokenSource
+	tokens []antlr.Token
+	eof    antlr.Token
+}
+
+func newSliceTokenSource(lexer antlr.TokenSource, tokens []antlr.Token) *sliceTokenSource {
+	return &sliceTokenSource{
+		TokenSource: lexer,
+		tokens:      tokens,
+		eof:         parser.NewModelicaLexer(antlr.NewInputStream("")).NextToken(),
+	}
+}
+
+func (s *sliceTokenSource) NextToken() antlr.Token {
+	if len(s.tokens) == 0 {
+		return s.eof
+	}
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return token
+}
+
+// statement is a chunk of the source which is formatted on its own
+type statement struct {
+	skeleton []antlr.Token // headers and section keywords of the enclosing classes
+	tokens   []antlr.Token // tokens of the statement, including comments
+	closers  []antlr.Token // end clauses of the classes still open after the statement
+	trailing bool          // true if the tokens are the comments at the end of the file
+}
+
+// openClass is a long class definition whose end has not been reached yet
+type openClass struct {
+	name    string
+	header  []antlr.Token // tokens preceding the class composition
+	section []antlr.Token // keywords of the current section, e.g. "initial equation"
+}
+
+// statementSplitter splits the tokens produced by a lexer into statements,
+// keeping track of the classes and sections they are in
+type statementSplitter struct {
+	ctx       context.Context
+	lexer     antlr.TokenSource
+	lookahead []antlr.Token
+	classes   []*openClass
+	eof       antlr.Token // end of file of the source, once reached
+	done      bool
+}
+
+func newStatementSplitter(ctx context.Context, lexer antlr.TokenSource) *statementSplitter {
+	return &statementSplitter{ctx: ctx, lexer: lexer}
+}
+
+// fetch reads the next token from the lexer, copying its text as the stream
+// only keeps the text of the latest token
+func (s *statementSplitter) fetch() antlr.Token {
+	checkCanceled(s.ctx)
+	token := s.lexer.NextToken()
+	if token.GetTokenType() != antlr.TokenEOF {
+		token.SetText(token.GetText())
+	}
+	return token
+}
+
+// peek returns the n-th upcoming token on the default channel (starting at 0)
+func (s *statementSplitter) peek(n int) antlr.Token {
+	for i := 0; ; i++ {
+		if i == len(s.lookahead) {
+			s.lookahead = append(s.lookahead, s.fetch())
+		}
+		token := s.lookahead[i]
+		if token.GetTokenType() == antlr.TokenEOF {
+			return token
+		}
+		if token.GetChannel() != antlr.TokenDefaultChannel {
+			continue
+		}
+		if n == 0 {
+			return token
+		}
+		n--
+	}
+}
+
+// leadingComments returns the comments preceding the first class definition,
+// following the within clause if any, which hold the directives of the file.
+// The tokens read ahead are kept for the statements.
+func (s *statementSplitter) leadingComments() []antlr.Token {
+	n := 0
+	if s.peek(0).GetText() == "within" {
+		for s.peek(n).GetText() != ";" && s.peek(n).GetTokenType() != antlr.TokenEOF {
+			n++
+		}
+		n++
+	}
+	first := s.peek(n)
+	var comments []antlr.Token
+	for _, token := range s.lookahead {
+		if token == first {
+			break
+		}
+		if isComment(token) {
+			comments = append(comments, token)
+		}
+	}
+	return comments
+}
+
+// skeleton returns the headers and section keywords of the open classes
+func (s *statementSplitter) skeleton() []antlr.Token {
+	var tokens []antlr.Token
+	for _, class := range s.classes {
+		tokens = append(tokens, class.header...)
+		tokens = append(tokens, class.section...)
+	}
+	return tokens
+}
+
+// closers returns the end clauses of the open classes, innermost first
+func (s *statementSplitter) closers() []antlr.Token {
+	var tokens []antlr.Token
+	for i := len(s.classes) - 1; i >= 0; i-- {
+		tokens = append(tokens, lexTokens("end "+s.classes[i].name+";")...)
+	}
+	return tokens
+}
+
+// lexTokens returns the default channel tokens of text
+func lexTokens(text string) []antlr.Token {
+	lexer := parser.NewModelicaLexer(antlr.NewInputStream(text))
+	lexer.RemoveErrorListeners()
+	var tokens []antlr.Token
+	for token := lexer.NextToken(); token.GetTokenType() != antlr.TokenEOF; token = lexer.NextToken() {
+		if token.GetChannel() == antlr.TokenDefaultChannel {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// statementScanner holds the state used to find the end of a statement
+type statementScanner struct {
+	atStart bool     // true at the start of a statement
+	depth   int      // nesting of parentheses, brackets and braces
+	frames  []string // open control structures ("block") and if-expressions ("if")
+}
+
+func (c *statementScanner) top() string {
+	if len(c.frames) == 0 {
+		return ""
+	}
+	return c.frames[len(c.frames)-1]
+}
+
+func (c *statementScanner) pop() {
+	c.frames = c.frames[:len(c.frames)-1]
+}
+
+var (
+	// prefixes which may precede the keyword of a class definition
+	classPrefixes = []string{
+		"final", "encapsulated", "partial", "redeclare", "inner", "outer",
+		"replaceable", "expandable", "pure", "impure", "operator",
+	}
+
+	classKeywords = []string{
+		"class", "model", "record", "block", "connector", "type", "package", "function", "operator",
+	}
+
+	// tokens after which an 'if' starts an if-expression rather than a
+	// component's condition attribute
+	expressionIfPrecedingTokens = []string{
+		"=", ":=", "(", ",", "{", "[", ";",
+		"+", "-", ".+", ".-", "*", "/", ".*", "./", "^", ".^",
+		"<", "<=", ">", ">=", "==", "<>", "and", "or", "not",
+		"then", "else", "in", ":",
+	}
+)
+
+// next returns the next statement, or false at the end of the source
+func (s *statementSplitter) next() (statement, bool) {
+	if s.done {
+		return statement{}, false
+	}
+
+	result := statement{skeleton: s.skeleton()}
+	scanner := statementScanner{atStart: true}
+	var previous string
+	endsClass := false
+	for {
+		if s.peek(0).GetTokenType() == antlr.TokenEOF {
+			// only hidden tokens (or nothing) are left
+			result.tokens = append(result.tokens, s.lookahead...)
+			s.eof = result.tokens[len(result.tokens)-1]
+			result.tokens = result.tokens[:len(result.tokens)-1]
+			s.lookahead = nil
+			s.done = true
+			result.trailing = true
+			for _, token := range result.tokens {
+				if token.GetChannel() == antlr.TokenDefaultChannel {
+					result.trailing = false
+				}
+			}
+			result.closers = s.closers()
+			return result, len(result.tokens) > 0
+		}
+
+		if scanner.atStart && len(scanner.frames) == 0 && s.startClass(&result) {
+			continue
+		}
+		if scanner.atStart && len(scanner.frames) == 0 && s.startSection(&result) {
+			continue
+		}
+
+		token := s.lookahead[0]
+		s.lookahead = s.lookahead[1:]
+		result.tokens = append(result.tokens, token)
+		if token.GetChannel() != antlr.TokenDefaultChannel {
+			continue
+		}
+
+		text := token.GetText()
+		if scanner.atStart && len(scanner.frames) == 0 && text == "end" && len(s.classes) > 0 {
+			endsClass = true
+		}
+		if scanner.atStart && tokenInGroup(text, []string{"if", "for", "while", "when"}) {
+			scanner.frames = append(scanner.frames, "block")
+			scanner.atStart = false
+			previous = text
+			continue
+		}
+		if scanner.atStart && text == "end" && scanner.top() == "block" {
+			// end of a control structure, consume its keyword
+			scanner.pop()
+			result.tokens = append(result.tokens, s.take()...)
+			scanner.atStart = false
+			previous = "end"
+			continue
+		}
+		scanner.atStart = false
+
+		switch text {
+		case "(", "[", "{", ".{":
+			scanner.depth++
+		case ")", "]", "}":
+			scanner.depth--
+		case "if":
+			if tokenInGroup(previous, expressionIfPrecedingTokens) {
+				scanner.frames = append(scanner.frames, "if")
+			}
+		case "then", "loop":
+			if scanner.top() == "block" {
+				scanner.atStart = true
+			}
+		case "else":
+			if scanner.top() == "if" {
+				scanner.pop()
+			} else if scanner.top() == "block" {
+				scanner.atStart = true
+			}
+		case ";":
+			if scanner.depth > 0 {
+				break
+			}
+			// if-expressions cannot span statements
+			for scanner.top() == "if" {
+				scanner.pop()
+			}
+			if len(scanner.frames) > 0 {
+				scanner.atStart = true
+				break
+			}
+			if endsClass {
+				s.classes = s.classes[:len(s.classes)-1]
+				endsClass = false
+			}
+			// comments following the statement on its last line stay with it
+			result.tokens = append(result.tokens, s.takeTrailing(token)...)
+			if offRegion(result.tokens) {
+				// the region copied as it is goes on to the next statement
+				scanner.atStart = true
+				break
+			}
+			result.closers = s.closers()
+			return result, true
+		}
+		previous = text
+	}
+}
+
+// take removes the next token on the default channel, and any hidden tokens
+// preceding it, from the lookahead and returns them
+func (s *statementSplitter) take() []antlr.Token {
+	s.peek(0)
+	for i, token := range s.lookahead {
+		if token.GetChannel() == antlr.TokenDefaultChannel {
+			tokens := s.lookahead[:i+1]
+			s.lookahead = s.lookahead[i+1:]
+			return tokens
+		}
+	}
+	return nil
+}
+
+// takeTrailing removes the comments following the token on its line, and the
+// whitespace preceding them, from the lookahead and returns them
+func (s *statementSplitter) takeTrailing(token antlr.Token) []antlr.Token {
+	s.peek(0)
+	n := 0
+	for i, next := range s.lookahead {
+		if next.GetChannel() == antlr.TokenDefaultChannel || next.GetLine() != token.GetLine() {
+			break
+		}
+		if isComment(next) {
+			n = i + 1
+		}
+	}
+	tokens := s.lookahead[:n:n]
+	s.lookahead = s.lookahead[n:]
+	return tokens
+}
+
+// startClass consumes the header of a long class definition if one starts at
+// the next token, returning true if it did
+func (s *statementSplitter) startClass(result *statement) bool {
+	n := 0
+	for tokenInGroup(s.peek(n).GetText(), classPrefixes) {
+		n++
+	}
+	if !tokenInGroup(s.peek(n).GetText(), classKeywords) {
+		// "operator" may be a prefix or the class keyword itself
+		if n == 0 || s.peek(n-1).GetText() != "operator" {
+			return false
+		}
+		n--
+	}
+	n++
+	extends := s.peek(n).GetText() == "extends"
+	if extends {
+		n++
+	}
+	if s.peek(n).GetTokenType() != parser.ModelicaLexerIDENT {
+		return false
+	}
+	name := s.peek(n).GetText()
+	n++
+	if s.peek(n).GetText() == "=" {
+		// short class definitions are regular statements
+		return false
+	}
+	if extends && s.peek(n).GetText() == "(" {
+		for depth := 0; ; n++ {
+			text := s.peek(n).GetText()
+			if text == "(" {
+				depth++
+			} else if text == ")" {
+				depth--
+			}
+			if depth == 0 || s.peek(n).GetTokenType() == antlr.TokenEOF {
+				break
+			}
+		}
+		n++
+	}
+	if s.peek(n).GetTokenType() == parser.ModelicaLexerSTRING {
+		n++
+		for s.peek(n).GetText() == "+" && s.peek(n+1).GetTokenType() == parser.ModelicaLexerSTRING {
+			n += 2
+		}
+	}
+
+	class := &openClass{name: name}
+	for i := 0; i < n; i++ {
+		tokens := s.take()
+		result.tokens = append(result.tokens, tokens...)
+		class.header = append(class.header, tokens[len(tokens)-1])
+	}
+	s.classes = append(s.classes, class)
+	return true
+}
+
+// startSection consumes the keywords of a section if one starts at the next
+// token, returning true if it did
+func (s *statementSplitter) startSection(result *statement) bool {
+	if len(s.classes) == 0 {
+		return false
+	}
+
+	n := 0
+	switch s.peek(0).GetText() {
+	case "public", "protected", "equation", "algorithm":
+		n = 1
+	case "initial":
+		if next := s.peek(1).GetText(); next == "equation" || next == "algorithm" {
+			n = 2
+		}
+	}
+	if n == 0 {
+		return false
+	}
+
+	class := s.classes[len(s.classes)-1]
+	class.section = nil
+	for i := 0; i < n; i++ {
+		tokens := s.take()
+		result.tokens = append(result.tokens, tokens...)
+		class.section = append(class.section, tokens[len(tokens)-1])
+	}
+	return true
+}
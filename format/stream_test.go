@@ -0,0 +1,98 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatStream(t *testing.T) {
+	for _, testCase := range exampleFileTests {
+		t.Run(testCase.sourceFile, func(t *testing.T) {
+			a := require.New(t)
+			expected, err := ioutil.ReadFile(path.Join("..", "examples", testCase.outFile))
+			a.NoError(err)
+			source, err := ioutil.ReadFile(path.Join("..", "examples", testCase.sourceFile))
+			a.NoError(err)
+
+			var out bytes.Buffer
+			err = FormatStream(context.Background(), bytes.NewReader(source), &out, DefaultOptions())
+
+			a.NoError(err)
+			a.Equal(string(expected), out.String())
+		})
+	}
+}
+
+func TestFormatStreamCanceled(t *testing.T) {
+	a := require.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	source, err := ioutil.ReadFile(path.Join("..", "examples", "gmt-building.mo"))
+	a.NoError(err)
+
+	var out bytes.Buffer
+	err = FormatStream(ctx, bytes.NewReader(source), &out, DefaultOptions())
+
+	a.Equal(context.Canceled, err)
+	a.Len(out.Bytes(), 0, "Nothing should be written once canceled")
+}
+
+func TestFormatStreamUnsupportedOptions(t *testing.T) {
+	a := require.New(t)
+	opts := DefaultOptions()
+	opts.SortImports = true
+	opts.AlignAssignments = true
+	opts.SyntaxErrors = SyntaxErrorsPassthrough
+
+	var out bytes.Buffer
+	err := FormatStream(context.Background(), bytes.NewReader([]byte("model A end A;\n")), &out, opts)
+
+	a.EqualError(err, "cannot format one statement at a time with sort-imports, align-assignments, syntax-errors")
+	a.Len(out.Bytes(), 0, "Nothing should be written")
+}
+
+func TestFormatStreamSyntaxErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		source   string
+		expected []Diagnostic
+	}{
+		{
+			name:   "error on a later line",
+			source: "model A\n  Real x;\n  Real y = ;\n  Real z;\nend A;\n",
+			expected: []Diagnostic{
+				{Line: 3, Column: 12, End: Position{3, 13}, Rule: "expression", Message: "unexpected token ';'"},
+			},
+		},
+		{
+			name:   "missing end",
+			source: "model A\n  Real x;\n",
+			expected: []Diagnostic{
+				{Line: 3, Column: 1, End: Position{3, 1}, Rule: "long_class_specifier", Message: "unexpected end of file, expecting 'end'"},
+			},
+		},
+		{
+			name:   "statement cut short",
+			source: "model A\n  Real x;\nend A",
+			expected: []Diagnostic{
+				{Line: 3, Column: 6, End: Position{3, 6}, Message: "unexpected end of file"},
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			a := require.New(t)
+
+			err := FormatStream(context.Background(), strings.NewReader(testCase.source), ioutil.Discard, DefaultOptions())
+
+			a.Equal(&SyntaxError{Diagnostics: testCase.expected}, err, "Errors should be at their positions in the source, not at the synthesized end clauses")
+		})
+	}
+}
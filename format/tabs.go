@@ -0,0 +1,147 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"strings"
+)
+
+// indentScanner records the whitespace indenting the lines of a source which
+// are indented with tabs as the runes of the source are read
+type indentScanner struct {
+	line      int
+	indent    []byte
+	indenting bool           // true while reading the whitespace starting a line
+	tabbed    bool           // true if the whitespace read so far holds a tab
+	indents   map[int]string // by line, for the lines indented with tabs only
+}
+
+func newIndentScanner() *indentScanner {
+	return &indentScanner{line: 1, indenting: true, indents: make(map[int]string)}
+}
+
+func (s *indentScanner) scan(r rune) {
+	switch {
+	case r == '\n':
+		s.line++
+		s.indent = s.indent[:0]
+		s.indenting, s.tabbed = true, false
+	case !s.indenting:
+	case r == ' ' || r == '\t':
+		s.indent = append(s.indent, byte(r))
+		s.tabbed = s.tabbed || r == '\t'
+	default:
+		s.indenting = false
+		if s.tabbed {
+			s.indents[s.line] = string(s.indent)
+		}
+	}
+}
+
+// scanIndents returns the whitespace indenting the lines of text which are
+// indented with tabs, by line
+func scanIndents(text string) map[int]string {
+	s := newIndentScanner()
+	if strings.IndexByte(text, '\t') >= 0 {
+		for _, r := range text {
+			s.scan(r)
+		}
+	}
+	return s.indents
+}
+
+// whitespaceWidth returns the number of columns of whitespace, a tab reaching
+// the next multiple of tabWidth
+func whitespaceWidth(whitespace string, tabWidth int) int {
+	width := 0
+	for _, r := range whitespace {
+		if r == '\t' {
+			width += tabWidth - width%tabWidth
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// reindentLines replaces the whitespace starting each line of text but the
+// first by indent(width), width being its number of columns with tabs reaching
+// the next multiple of tabWidth. Lines continuing a string or a quoted
+// identifier are left alone, blank lines are emptied.
+func reindentLines(text string, tabWidth int, indent func(width int) string) string {
+	if !strings.Contains(text, "\n") {
+		return text
+	}
+
+	var b strings.Builder
+	var quote, comment, previous rune // quote of the string or comment being read, if any
+	for i, line := range strings.SplitAfter(text, "\n") {
+		if i > 0 && quote == 0 {
+			trimmed := strings.TrimLeft(line, " \t")
+			if trimmed != "" && trimmed[0] != '\n' && trimmed[0] != '\r' {
+				b.WriteString(indent(whitespaceWidth(line[:len(line)-len(trimmed)], tabWidth)))
+			}
+			line = trimmed
+		}
+		b.WriteString(line)
+
+		for _, r := range line {
+			switch {
+			case comment == '/':
+				if r == '\n' {
+					comment = 0
+				}
+			case comment == '*':
+				if r == '/' && previous == '*' {
+					comment, r = 0, 0
+				}
+			case quote != 0:
+				if r == '\\' && previous == '\\' {
+					// an escaped backslash, which escapes nothing
+					r = 0
+				} else if r == quote && previous != '\\' {
+					quote, r = 0, 0
+				}
+			case r == '"' || r == '\'':
+				quote = r
+			case r == '/' && previous == '/':
+				comment = '/'
+			case r == '*' && previous == '/':
+				comment, r = '*', 0
+			}
+			previous = r
+		}
+	}
+	return b.String()
+}
+
+// expandIndentation indents the lines of text following the first one with
+// spaces instead of tabs, see reindentLines
+func expandIndentation(text string, tabWidth int) string {
+	if !strings.Contains(text, "\t") {
+		return text
+	}
+	return reindentLines(text, tabWidth, func(width int) string {
+		return strings.Repeat(" ", width)
+	})
+}
+
+// tabifyIndentation indents the lines of text following the first one with
+// as many tabs as fit, each as wide as indent, followed by spaces, see
+// reindentLines
+func tabifyIndentation(text string, indent int) string {
+	return reindentLines(text, indent, func(width int) string {
+		return strings.Repeat("\t", width/indent) + strings.Repeat(" ", width%indent)
+	})
+}
+
+// normalizeIndentation indents the lines of text copied from the source,
+// following the first one, with the indentation characters of the output
+func normalizeIndentation(text string, opts Options) string {
+	text = expandIndentation(text, opts.tabWidth())
+	if opts.UseTabs {
+		text = tabifyIndentation(text, opts.Indent)
+	}
+	return text
+}
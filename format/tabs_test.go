@@ -0,0 +1,47 @@
+package format
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanIndents(t *testing.T) {
+	a := require.New(t)
+	a.Equal(map[int]string{2: "\t", 4: " \t "}, scanIndents("model A\n\tReal x;\n  Real y;\n \t Real z;\n\t\nend A;\n"))
+	a.Empty(scanIndents("model A\n  Real x;\nend A;\n"))
+}
+
+func TestExpandIndentation(t *testing.T) {
+	a := require.New(t)
+	a.Equal("/* a\n    b\n     c\n\n     */", expandIndentation("/* a\n\tb\n \t c\n\t\n\t */", 4))
+	a.Equal("x = \"a\n\tb\";\n  y", expandIndentation("x = \"a\n\tb\";\n\ty", 2), "Strings should be left alone")
+	a.Equal("x = \"a\\\"\n\tb\\\\\";\n  y", expandIndentation("x = \"a\\\"\n\tb\\\\\";\n\ty", 2), "Escaped quotes should not end strings")
+	a.Equal("// \"a\n  b", expandIndentation("// \"a\n\tb", 2), "Quotes within comments should not start strings")
+	a.Equal("\t\tb\n\t  c", tabifyIndentation("\t\tb\n      c", 4))
+}
+
+func TestTabIndentedComments(t *testing.T) {
+	a := require.New(t)
+	source := "model A\n\t/* a\n\t\t b\n\t*/\n\tReal x;\n\tannotation (__Vendor(\n\t\tx=1));\nend A;\n"
+	opts := DefaultOptions()
+	opts.PreserveVendorAnnotations = true
+
+	out, err := FormatString(source, opts)
+	a.NoError(err)
+	a.Equal("model A\n  /* a\n       b\n  */ Real x;\n\n  annotation (\n    __Vendor(\n        x=1));\nend A;\n", out)
+
+	var b bytes.Buffer
+	err = FormatStream(context.Background(), strings.NewReader(source), &b, opts)
+	a.NoError(err)
+	a.Equal(out, b.String())
+
+	opts.UseTabs = true
+	out, err = FormatString(source, opts)
+	a.NoError(err)
+	a.Equal("model A\n\t/* a\n\t\t b\n\t*/ Real x;\n\n\tannotation (\n\t\t__Vendor(\n\t\tx=1));\nend A;\n", out)
+	a.NoError(Verify(context.Background(), []byte(out), opts))
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/antlr/antlr4/runtime/Go/antlr"
+	"github.com/urbanopt/modelica-fmt/thirdparty/parser"
+)
+
+// Parse parses the Modelica source read from r and returns its parse tree
+// along with the syntax errors found. The tree is still returned when there
+// are syntax errors, in which case it covers the parts the parser recovered.
+// If parsing fails because of an internal error no tree is returned and the
+// error is reported as the last diagnostic.
+func Parse(r io.Reader) (tree *StoredDefinition, diagnostics []Diagnostic) {
+	content, err := ioutil.ReadAll(newInputDecoder(r))
+	if err != nil {
+		return nil, []Diagnostic{{Message: err.Error()}}
+	}
+
+	collector := newDiagnosticCollector()
+	defer func() {
+		if err != nil {
+			tree = nil
+			diagnostics = append(collector.sorted(), Diagnostic{Message: err.Error()})
+		}
+	}()
+	defer recoverPanic(&err, nil)
+
+	p, _ := newParser(context.Background(), string(content), collector, nil)
+	sd := p.Stored_definition().(*parser.Stored_definitionContext)
+
+	return &StoredDefinition{sd}, collector.sorted()
+}
+
+// StoredDefinition is the root of the parse tree of a Modelica file
+type StoredDefinition struct {
+	node *parser.Stored_definitionContext
+}
+
+// Within returns the name of the package given by the within clause, which is
+// empty if there is none or if it refers to the top level
+func (d *StoredDefinition) Within() string {
+	for _, name := range d.node.AllName() {
+		return name.GetText()
+	}
+	return ""
+}
+
+// Classes returns the classes defined at the top level of the file
+func (d *StoredDefinition) Classes() []*Class {
+	var classes []*Class
+	for _, definition := range d.node.AllClass_definition() {
+		classes = append(classes, newClass(definition))
+	}
+	return classes
+}
+
+// Class is a class definition, such as a model, package or function
+type Class struct {
+	node      *parser.Class_definitionContext
+	specifier antlr.ParserRuleContext // long, short or der class specifier
+}
+
+func newClass(definition parser.IClass_definitionContext) *Class {
+	node := definition.(*parser.Class_definitionContext)
+	specifier := node.Class_specifier().GetChild(0).(antlr.ParserRuleContext)
+	return &Class{node, specifier}
+}
+
+// Name returns the name of the class
+func (c *Class) Name() string {
+	switch specifier := c.specifier.(type) {
+	case *parser.Long_class_specifierContext:
+		return specifier.IDENT(0).GetText()
+	case *parser.Short_class_specifierContext:
+		return specifier.IDENT().GetText()
+	case *parser.Der_class_specifierContext:
+		return specifier.IDENT(0).GetText()
+	}
+	return ""
+}
+
+// Kind returns the keywords of the class prefixes, for example "model",
+// "partial model" or "expandable connector"
+func (c *Class) Kind() string {
+	return terminalsText(c.node.Class_prefixes())
+}
+
+// Position returns the position of the start of the class definition
+func (c *Class) Position() Position {
+	return startPosition(c.node)
+}
+
+// Description returns the description string of the class
+func (c *Class) Description() string {
+	switch specifier := c.specifier.(type) {
+	case *parser.Long_class_specifierContext:
+		return description(specifier.String_comment())
+	case *parser.Short_class_specifierContext:
+		return description(specifier.String_comment())
+	case *parser.Der_class_specifierContext:
+		return description(specifier.String_comment())
+	}
+	return ""
+}
+
+// Annotation returns the annotation of the class, or nil if it has none
+func (c *Class) Annotation() *Annotation {
+	switch specifier := c.specifier.(type) {
+	case *parser.Long_class_specifierContext:
+		composition := specifier.Composition().(*parser.CompositionContext)
+		if composition.Model_annotation() != nil {
+			return newAnnotation(composition.Model_annotation().(*parser.Model_annotationContext).Annotation())
+		}
+	case *parser.Short_class_specifierContext:
+		return newAnnotation(specifier.Annotation())
+	case *parser.Der_class_specifierContext:
+		return newAnnotation(specifier.Annotation())
+	}
+	return nil
+}
+
+// Classes returns the classes defined within the class
+func (c *Class) Classes() []*Class {
+	var classes []*Class
+	c.visitElements(func(element *parser.ElementContext, protected bool) {
+		if element.Class_definition() != nil {
+			classes = append(classes, newClass(element.Class_definition()))
+		}
+	})
+	return classes
+}
+
+// Components returns the components declared within the class
+func (c *Class) Components() []*Component {
+	var components []*Component
+	c.visitElements(func(element *parser.ElementContext, protected bool) {
+		if element.Component_clause() == nil {
+			return
+		}
+		clause := element.Component_clause().(*parser.Component_clauseContext)
+		list := clause.Component_list().(*parser.Component_listContext)
+		for _, declaration := range list.AllComponent_declaration() {
+			components = append(components, &Component{
+				element:     element,
+				clause:      clause,
+				declaration: declaration.(*parser.Component_declarationContext),
+				protected:   protected,
+			})
+		}
+	})
+	return components
+}
+
+// visitElements calls fn for each element of the class composition, telling
+// whether the element is in a protected section
+func (c *Class) visitElements(fn func(element *parser.ElementContext, protected bool)) {
+	specifier, ok := c.specifier.(*parser.Long_class_specifierContext)
+	if !ok {
+		return
+	}
+
+	protected := false
+	for _, child := range specifier.Composition().GetChildren() {
+		switch child := child.(type) {
+		case antlr.TerminalNode:
+			if text := child.GetText(); text == "public" || text == "protected" {
+				protected = text == "protected"
+			}
+		case *parser.Element_listContext:
+			for _, element := range child.AllElement() {
+				fn(element.(*parser.ElementContext), protected)
+			}
+		}
+	}
+}
+
+// Component is a single component declared in a class. A component clause
+// declaring several components, e.g. `Real a, b;`, yields one Component each.
+type Component struct {
+	element     *parser.ElementContext
+	clause      *parser.Component_clauseContext
+	declaration *parser.Component_declarationContext
+	protected   bool
+}
+
+// Name returns the name of the component
+func (c *Component) Name() string {
+	return c.declaration.Declaration().(*parser.DeclarationContext).IDENT().GetText()
+}
+
+// TypeName returns the name of the type of the component
+func (c *Component) TypeName() string {
+	return c.clause.Type_specifier().GetText()
+}
+
+// Prefixes returns the prefixes of the component, such as "parameter",
+// "replaceable" or "input", in the order they appear in
+func (c *Component) Prefixes() []string {
+	var prefixes []string
+	for _, child := range c.element.GetChildren() {
+		if terminal, ok := child.(antlr.TerminalNode); ok {
+			prefixes = append(prefixes, terminal.GetText())
+		}
+	}
+	if text := terminalsText(c.clause.Type_prefix()); text != "" {
+		prefixes = append(prefixes, strings.Fields(text)...)
+	}
+	return prefixes
+}
+
+// Protected returns true if the component is declared in a protected section
+func (c *Component) Protected() bool {
+	return c.protected
+}
+
+// Position returns the position of the name of the component
+func (c *Component) Position() Position {
+	return startPosition(c.declaration)
+}
+
+// Description returns the description string of the component
+func (c *Component) Description() string {
+	return description(c.declaration.String_comment())
+}
+
+// Annotation returns the annotation of the component, or nil if it has none
+func (c *Component) Annotation() *Annotation {
+	return newAnnotation(c.declaration.Annotation())
+}
+
+// Annotation is an annotation of a class or a component
+type Annotation struct {
+	node *parser.AnnotationContext
+}
+
+func newAnnotation(annotation parser.IAnnotationContext) *Annotation {
+	if annotation == nil {
+		return nil
+	}
+	return &Annotation{annotation.(*parser.AnnotationContext)}
+}
+
+// Text returns the source text of the annotation's modification, including
+// the parentheses
+func (a *Annotation) Text() string {
+	return sourceText(a.node.Class_modification())
+}
+
+// Names returns the names of the top level arguments of the annotation, for
+// example "Placement" and "Dialog"
+func (a *Annotation) Names() []string {
+	var names []string
+	for _, modification := range a.modifications() {
+		names = append(names, modification.Name().GetText())
+	}
+	return names
+}
+
+// Argument returns the source text of the modification of the top level
+// argument called name, for example `(group="Fan")` for `Dialog` in
+// `annotation (Dialog(group="Fan"))` or `=true` for `choicesAllMatching` in
+// `annotation (choicesAllMatching=true)`. It returns false if there is no such
+// argument.
+func (a *Annotation) Argument(name string) (string, bool) {
+	for _, modification := range a.modifications() {
+		if modification.Name().GetText() != name {
+			continue
+		}
+		if modification.Modification() == nil {
+			return "", true
+		}
+		return sourceText(modification.Modification()), true
+	}
+	return "", false
+}
+
+// modifications returns the top level element modifications of the annotation
+func (a *Annotation) modifications() []*parser.Element_modificationContext {
+	list := a.node.Class_modification().(*parser.Class_modificationContext).Argument_list()
+	if list == nil {
+		return nil
+	}
+
+	var modifications []*parser.Element_modificationContext
+	for _, argument := range list.(*parser.Argument_listContext).AllArgument() {
+		wrapper := argument.(*parser.ArgumentContext).Element_modification_or_replaceable()
+		if wrapper == nil {
+			continue
+		}
+		if modification, ok := wrapper.(*parser.Element_modification_or_replaceableContext).Element_modification().(*parser.Element_modificationContext); ok {
+			modifications = append(modifications, modification)
+		}
+	}
+	return modifications
+}
+
+// terminalsText returns the text of the terminals of a rule separated by spaces
+func terminalsText(rule antlr.ParserRuleContext) string {
+	var texts []string
+	for _, child := range rule.GetChildren() {
+		if terminal, ok := child.(antlr.TerminalNode); ok {
+			texts = append(texts, terminal.GetText())
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// description returns the concatenated strings of a string comment without
+// their quotes. Escape sequences are kept as they are.
+func description(comment parser.IString_commentContext) string {
+	if comment == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, s := range comment.(*parser.String_commentContext).AllSTRING() {
+		text := s.GetText()
+		b.WriteString(text[1 : len(text)-1])
+	}
+	return b.String()
+}
+
+// sourceText returns the text a rule was parsed from, including whitespace
+// and comments
+func sourceText(rule antlr.ParserRuleContext) string {
+	start, stop := rule.GetStart(), rule.GetStop()
+	if stop == nil || stop.GetStop() < start.GetStart() {
+		return ""
+	}
+	return start.GetInputStream().GetText(start.GetStart(), stop.GetStop())
+}
+
+// startPosition returns the position of the first token of a rule
+func startPosition(rule antlr.ParserRuleContext) Position {
+	return Position{Line: rule.GetStart().GetLine(), Column: rule.GetStart().GetColumn() + 1}
+}
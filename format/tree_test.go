@@ -0,0 +1,65 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	a := require.New(t)
+	source := `within Buildings.Examples;
+partial model A "Model A"
+  parameter Real k = 1 "Gain" annotation (Dialog(group = "Control"), Evaluate=true);
+  replaceable package Medium = Buildings.Media.Water;
+  Modelica.Blocks.Sources.Constant c1, c2(k=2);
+protected
+  Real x;
+  annotation (experiment(StopTime=3600));
+end A;
+`
+
+	sd, diagnostics := Parse(strings.NewReader(source))
+
+	a.Empty(diagnostics)
+	a.Equal("Buildings.Examples", sd.Within())
+	classes := sd.Classes()
+	a.Len(classes, 1)
+	class := classes[0]
+	a.Equal("A", class.Name())
+	a.Equal("partial model", class.Kind())
+	a.Equal("Model A", class.Description())
+	a.Equal(Position{2, 1}, class.Position())
+	a.Equal([]string{"experiment"}, class.Annotation().Names())
+
+	nested := class.Classes()
+	a.Len(nested, 1)
+	a.Equal("Medium", nested[0].Name())
+	a.Equal("package", nested[0].Kind())
+	a.Nil(nested[0].Annotation())
+
+	components := class.Components()
+	a.Len(components, 4)
+	k := components[0]
+	a.Equal("k", k.Name())
+	a.Equal("Real", k.TypeName())
+	a.Equal([]string{"parameter"}, k.Prefixes())
+	a.Equal("Gain", k.Description())
+	a.Equal(Position{3, 18}, k.Position())
+	a.Equal(`(Dialog(group = "Control"), Evaluate=true)`, k.Annotation().Text())
+	a.Equal([]string{"Dialog", "Evaluate"}, k.Annotation().Names())
+	dialog, ok := k.Annotation().Argument("Dialog")
+	a.True(ok)
+	a.Equal(`(group = "Control")`, dialog)
+	_, ok = k.Annotation().Argument("Placement")
+	a.False(ok)
+
+	a.Equal("c1", components[1].Name())
+	a.Equal("c2", components[2].Name())
+	a.Equal("Modelica.Blocks.Sources.Constant", components[2].TypeName())
+	a.Nil(components[2].Annotation())
+	a.False(components[2].Protected())
+	a.Equal("x", components[3].Name())
+	a.True(components[3].Protected())
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+package format
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// IdempotenceError is returned by Verify when formatting the output of the
+// formatter changes it, which means the rules disagree with themselves
+type IdempotenceError struct {
+	Line   int    // 1-based line of the output on which the first change is
+	Output string // the line of the output, including its newline
+	Again  string // the line once the output is formatted again
+}
+
+func (e *IdempotenceError) Error() string {
+	return fmt.Sprintf("formatting the output again changes line %d from %q to %q", e.Line, e.Output, e.Again)
+}
+
+// Verify formats the output of the formatter again with the same options and
+// returns an *IdempotenceError if the result differs from it
+func Verify(ctx context.Context, output []byte, opts Options) error {
+	var again bytes.Buffer
+	if err := Format(ctx, bytes.NewReader(output), &again, opts); err != nil {
+		return fmt.Errorf("formatting the output again: %v", err)
+	}
+	if bytes.Equal(output, again.Bytes()) {
+		return nil
+	}
+
+	outputLines := strings.SplitAfter(string(output), "\n")
+	againLines := strings.SplitAfter(again.String(), "\n")
+	// the lines differ from the one holding the first difference
+	e := &IdempotenceError{}
+	for e.Output == e.Again {
+		e.Output, e.Again = "", ""
+		if e.Line < len(outputLines) {
+			e.Output = outputLines[e.Line]
+		}
+		if e.Line < len(againLines) {
+			e.Again = againLines[e.Line]
+		}
+		e.Line++
+	}
+	return e
+}
+
+// TokenError is returned by VerifyTokens when the tokens of the output differ
+// from those of the source
+type TokenError struct {
+	Position Position // position in the source of the first token which differs, or of its end
+	Source   string   // the token of the source, empty if the output adds a token
+	Output   string   // the token of the output, empty if the output drops a token
+}
+
+func (e *TokenError) Error() string {
+	switch {
+	case e.Source == "":
+		return fmt.Sprintf("the output adds %q at %d:%d", e.Output, e.Position.Line, e.Position.Column)
+	case e.Output == "":
+		return fmt.Sprintf("the output drops %q at %d:%d", e.Source, e.Position.Line, e.Position.Column)
+	}
+	return fmt.Sprintf("the output changes %q at %d:%d to %q", e.Source, e.Position.Line, e.Position.Column, e.Output)
+}
+
+// VerifyTokens returns a *TokenError if the tokens of the output, leaving out
+// whitespace and comments, are not those of the source, which guarantees that
+// the meaning of the source is kept. The options rewriting the source change
+// its tokens: Options.SplitDeclarations, Options.SplitDescriptions,
+// Options.SortImports, Options.DropEmptyModifications and
+// Options.DropEmptyAnnotations. The source and the output may have different
+// encodings and line endings, which Options.LineEndings also converts within
+// strings, hence strings are compared with their line endings normalized.
+func VerifyTokens(source, output []byte) error {
+	sourceText, err := Decode(source)
+	if err != nil {
+		return err
+	}
+	outputText, err := Decode(output)
+	if err != nil {
+		return err
+	}
+	sourceTokens := lexTokens(sourceText)
+	outputTokens := lexTokens(outputText)
+	for i, token := range sourceTokens {
+		e := &TokenError{
+			Position: Position{Line: token.GetLine(), Column: token.GetColumn() + 1},
+			Source:   token.GetText(),
+		}
+		if i >= len(outputTokens) {
+			return e
+		}
+		if e.Output = outputTokens[i].GetText(); normalizeNewlines(e.Output) != normalizeNewlines(e.Source) {
+			return e
+		}
+	}
+	if len(outputTokens) > len(sourceTokens) {
+		end := Position{Line: 1, Column: 1}
+		if n := len(sourceTokens); n > 0 {
+			last := sourceTokens[n-1]
+			end = advancePosition(Position{Line: last.GetLine(), Column: last.GetColumn() + 1}, last.GetText())
+		}
+		return &TokenError{Position: end, Output: outputTokens[len(sourceTokens)].GetText()}
+	}
+	return nil
+}
+
+// normalizeNewlines replaces the CRLF line endings of text with LF
+func normalizeNewlines(text string) string {
+	return strings.Replace(text, "\r\n", "\n", -1)
+}
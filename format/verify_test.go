@@ -0,0 +1,59 @@
+package format
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	a := require.New(t)
+	ctx := context.Background()
+	output, err := FormatBytes([]byte("model A\nReal x;\nequation\nx=1;\nend A;\n"), DefaultOptions())
+	a.NoError(err)
+	a.NoError(Verify(ctx, output, DefaultOptions()))
+
+	// output which formatting changes stands for that of a rule which
+	// disagrees with itself
+	err = Verify(ctx, []byte("model A\n  Real x;\n\nequation\n  x =1;\nend A;\n"), DefaultOptions())
+	a.Equal(&IdempotenceError{Line: 5, Output: "  x =1;\n", Again: "  x=1;\n"}, err)
+	a.EqualError(err, `formatting the output again changes line 5 from "  x =1;\n" to "  x=1;\n"`)
+
+	err = Verify(ctx, []byte("model A\nend A;"), DefaultOptions())
+	a.Equal(&IdempotenceError{Line: 2, Output: "end A;", Again: "end A;\n"}, err)
+}
+
+func TestVerifyTokens(t *testing.T) {
+	a := require.New(t)
+	source := []byte("model A // comment\nReal x=1;\nend A;\n")
+	output, err := FormatBytes(source, DefaultOptions())
+	a.NoError(err)
+	a.NoError(VerifyTokens(source, output))
+
+	err = VerifyTokens(source, []byte("model A\n  Real x=2;\nend A;\n"))
+	a.Equal(&TokenError{Position: Position{2, 8}, Source: "1", Output: "2"}, err)
+	a.EqualError(err, `the output changes "1" at 2:8 to "2"`)
+
+	err = VerifyTokens(source, []byte("model A\n  Real x=1;\nend A;"))
+	a.NoError(err)
+	err = VerifyTokens(source, []byte("model A\n  Real x=1;\nend A"))
+	a.EqualError(err, `the output drops ";" at 3:6`)
+	err = VerifyTokens(source, []byte("model A\n  Real x=1;\nend A;;"))
+	a.EqualError(err, `the output adds ";" at 3:7`)
+
+	opts := DefaultOptions()
+	opts.SplitDeclarations = true
+	source = []byte("model A\n  Real x, y;\nend A;\n")
+	output, err = FormatBytes(source, opts)
+	a.NoError(err)
+	a.Error(VerifyTokens(source, output), "Splitting declarations should change the tokens")
+
+	opts = DefaultOptions()
+	opts.LineEndings = "crlf"
+	source = []byte("model A\n  annotation (Documentation(info=\"<html>\na\n</html>\"));\nend A;\n")
+	output, err = FormatBytes(source, opts)
+	a.NoError(err)
+	a.Contains(string(output), "<html>\r\na\r\n</html>")
+	a.NoError(VerifyTokens(source, output), "Converting the line endings within strings should keep the tokens")
+}
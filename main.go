@@ -1,23 +1,42 @@
 // Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
 // All rights reserved.
 
+//go:build !js
+// +build !js
+
 // Package main runs the formatter
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/urbanopt/modelica-fmt/format"
 )
 
 var (
 	write       = flag.Bool("w", false, "overwrite the file(s)")
 	versionFlag = flag.Bool("v", false, "display tool version")
+	timeout     = flag.Duration("timeout", 0, "abort formatting a file after this duration (0 for no limit)")
+	configFile  = flag.String("config", "", "configuration file (default "+format.DefaultConfigFile+" if it exists)")
+	style       = flag.String("style", "", "style preset which the configured options override, default, msl, buildings, compact or expanded (default as configured)")
+	diagnostics = flag.String("diagnostics-format", diagnosticsText, "format of the reported syntax errors and skipped files, "+diagnosticsText+" or "+diagnosticsJSON+" records, one per line")
+	safe        = flag.Bool("safe", false, "fail, keeping the file as it is, if the formatted tokens differ from those of the file, leaving out whitespace and comments")
+	verify      = flag.Bool("verify", false, "format the output again and fail, writing nothing, if it changes")
+	stream      = flag.Bool("stream", false, "format one statement at a time to bound memory use on large files")
+	encoding    = flag.String("encoding", "", "encoding of the output, utf-8, windows-1252, iso-8859-1 or auto to keep that of the input (default auto, or as configured)")
+	indentWidth = flag.Int("indent", 0, "number of spaces per indentation level (default 2, or as configured)")
+	useTabs     = flag.Bool("use-tabs", false, "indent with tabs instead of spaces")
+	newline     = flag.String("line-endings", "", "line endings of the output, lf, crlf or auto to keep those of the input (default lf, or as configured)")
+	maxLength   = flag.Int("max-line-length", 0, "wrap lists of arguments longer than this, 0 for no limit (default 100, or as configured)")
 	// build information added by goreleaser
 	version = "dev"
 	commit  = "none"
@@ -26,7 +45,11 @@ var (
 )
 
 func usage() {
-	fmt.Fprintln(os.Stderr, "usage: modelicafmt [path ...]")
+	fmt.Fprintln(os.Stderr, "usage: modelicafmt [flags] [path ...]")
+	fmt.Fprintln(os.Stderr, "       modelicafmt parse --check-syntax [--diagnostics-format text|json] [path ...]")
+	fmt.Fprintln(os.Stderr, "       modelicafmt parse --tree [path ...]")
+	fmt.Fprintln(os.Stderr, "       modelicafmt ast --json file")
+	fmt.Fprintln(os.Stderr, "       modelicafmt config init|validate|schema")
 	flag.PrintDefaults()
 }
 
@@ -35,40 +58,495 @@ func isModelicaFile(f os.FileInfo) bool {
 	return !f.IsDir() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".mo")
 }
 
-func processAndWriteFile(filename string) {
+// processAndWriteFile formats a file, returning false if it has syntax errors
+// or cannot be formatted, such as because of an invalid directive, which are
+// reported
+func processAndWriteFile(filename string, cfg *format.Config) bool {
+	opts, err := cfg.OptionsFor(filename)
+	if err != nil {
+		reportError(filename, err)
+		return false
+	}
+	if isFlagSet("line-endings") {
+		opts.LineEndings = *newline
+	}
+	if isFlagSet("encoding") {
+		opts.Encoding = *encoding
+	}
+	if isFlagSet("indent") {
+		opts.Indent = *indentWidth
+	}
+	if isFlagSet("use-tabs") {
+		opts.UseTabs = *useTabs
+	}
+	if isFlagSet("max-line-length") {
+		opts.MaxLineLength = *maxLength
+	}
+	if keys := tokenChangingOptions(opts); *safe && len(keys) > 0 {
+		reportError(filename, fmt.Errorf("-safe cannot be used with %s, which change the tokens", strings.Join(keys, ", ")))
+		return false
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *stream {
+		err := streamFile(ctx, filename, opts)
+		if err == format.ErrSkipped {
+			return reportSkipped(filename)
+		}
+		if syntaxErr, ok := err.(*format.SyntaxError); ok {
+			reportSyntaxError(filename, syntaxErr, format.SyntaxErrorsAbort)
+			return false
+		}
+		if err != nil {
+			reportError(filename, err)
+			return false
+		}
+		return true
+	}
+
 	var b bytes.Buffer
-	err := processFile(filename, bufio.NewWriter(&b))
+	err = processFile(ctx, filename, &b, opts)
+	if err == format.ErrSkipped {
+		return reportSkipped(filename)
+	}
+	if syntaxErr, ok := err.(*format.SyntaxError); ok {
+		reportSyntaxError(filename, syntaxErr, opts.SyntaxErrors)
+		switch opts.SyntaxErrors {
+		case format.SyntaxErrorsAbort:
+			return false
+		case format.SyntaxErrorsPassthrough:
+			// the source is written unchanged, there is nothing to overwrite
+			if !*write {
+				b.WriteTo(os.Stdout)
+			}
+			return true
+		}
+		err = nil
+	}
 	if err != nil {
-		panic(err)
+		reportError(filename, err)
+		return false
+	}
+	if *safe {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			reportError(filename, err)
+			return false
+		}
+		if err := format.VerifyTokens(source, b.Bytes()); err != nil {
+			reportError(filename, err)
+			if !*write {
+				os.Stdout.Write(source)
+			}
+			return false
+		}
+	}
+	if *verify {
+		if err := format.Verify(ctx, b.Bytes(), opts); err != nil {
+			reportError(filename, err)
+			return false
+		}
 	}
 	if *write {
 		err := ioutil.WriteFile(filename, b.Bytes(), 777)
 		if err != nil {
-			panic(err)
+			reportError(filename, err)
+			return false
 		}
 	} else {
 		b.WriteTo(os.Stdout)
 	}
+	return true
+}
+
+// processFile formats a file
+func processFile(ctx context.Context, filename string, out io.Writer, opts format.Options) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return format.Format(ctx, f, out, opts)
+}
+
+// tokenChangingOptions returns the keys of the options which are set and
+// rewrite the code, changing its tokens, see VerifyTokens
+func tokenChangingOptions(opts format.Options) []string {
+	var keys []string
+	if opts.SplitDeclarations {
+		keys = append(keys, "split-declarations")
+	}
+	if opts.SplitDescriptions {
+		keys = append(keys, "split-descriptions")
+	}
+	if opts.SortImports {
+		keys = append(keys, "sort-imports")
+	}
+	if opts.DropEmptyModifications {
+		keys = append(keys, "drop-empty-modifications")
+	}
+	if opts.DropEmptyAnnotations {
+		keys = append(keys, "drop-empty-annotations")
+	}
+	return keys
 }
 
-func visitFile(filename string, f os.FileInfo, err error) error {
-	if err != nil && !os.IsNotExist(err) {
-		fmt.Fprintln(os.Stderr, err.Error())
+// reportSkipped reports a file left as it is because of a skip-file
+// directive. Unless overwriting, the file is written unchanged, and whether
+// it could be is returned.
+func reportSkipped(filename string) bool {
+	if *diagnostics == diagnosticsJSON {
+		writeDiagnostics(os.Stderr, *diagnostics, filename, severityWarning, []format.Diagnostic{{Message: format.ErrSkipped.Error()}}, "")
+	} else {
+		fmt.Fprintln(os.Stderr, filename+": skipped")
+	}
+	if *write {
+		return true
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		reportError(filename, err)
+		return false
+	}
+	os.Stdout.Write(content)
+	return true
+}
+
+// reportError reports an error about a file which is not at a position of it
+func reportError(filename string, err error) {
+	if *diagnostics == diagnosticsJSON {
+		writeDiagnostics(os.Stderr, *diagnostics, filename, severityError, []format.Diagnostic{{Message: err.Error()}}, "")
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+	}
+}
+
+// reportSyntaxError reports the syntax errors which prevented formatting a
+// file, each followed by the offending line of the file. They are warnings
+// when the file was written anyway, unchanged or partially formatted.
+func reportSyntaxError(filename string, err *format.SyntaxError, handling string) {
+	severity := severityError
+	if handling != format.SyntaxErrorsAbort {
+		severity = severityWarning
+	}
+	content, _ := ioutil.ReadFile(filename)
+	source, _ := format.Decode(content)
+	writeDiagnostics(os.Stderr, *diagnostics, filename, severity, err.Diagnostics, source)
+}
+
+// isFlagSet returns true if the flag called name was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// streamFile formats a file with FormatStream. When overwriting, the output is
+// written to a temporary file which then replaces the original.
+func streamFile(ctx context.Context, filename string, opts format.Options) error {
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if !*write {
+		// the statements preceding an error have already been written
+		return format.FormatStream(ctx, in, os.Stdout, opts)
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := ioutil.TempFile(filepath.Dir(filename), ".modelicafmt-")
+	if err != nil {
+		return err
+	}
+	err = format.FormatStream(ctx, in, out, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(out.Name(), info.Mode())
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Rename(out.Name(), filename)
+}
+
+// visitSources calls fn for each path which is a file and for every Modelica
+// file found when walking the paths which are directories
+func visitSources(paths []string, fn func(filename string)) {
+	for _, path := range paths {
+		switch dir, err := os.Stat(path); {
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			os.Exit(2)
+		case dir.IsDir():
+			walkDir(path, fn)
+		default:
+			fn(path)
+		}
+	}
+}
+
+func walkDir(path string, fn func(filename string)) {
+	filepath.Walk(path, func(filename string, f os.FileInfo, err error) error {
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return nil
+		}
+
+		if isModelicaFile(f) {
+			fn(filename)
+		}
+
 		return nil
+	})
+}
+
+// runParse implements the parse subcommand
+func runParse(args []string) {
+	flags := flag.NewFlagSet("parse", flag.ExitOnError)
+	checkSyntax := flags.Bool("check-syntax", false, "report syntax errors without formatting")
+	tree := flags.Bool("tree", false, "print the parse tree with token indexes")
+	style := flags.String("style", format.TreeIndented, "style of the parse tree, "+format.TreeIndented+" or "+format.TreeSExpression)
+	diagnosticsFormat := flags.String("diagnostics-format", diagnosticsText, "format of the reported syntax errors, "+diagnosticsText+" or "+diagnosticsJSON+" records, one per line")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: modelicafmt parse --check-syntax [--diagnostics-format "+diagnosticsText+"|"+diagnosticsJSON+"] [path ...]")
+		fmt.Fprintln(os.Stderr, "       modelicafmt parse --tree [--style "+format.TreeIndented+"|"+format.TreeSExpression+"] [path ...]")
+		flags.PrintDefaults()
+	}
+	args = parseInterspersed(flags, args)
+	if *checkSyntax == *tree {
+		fmt.Fprintln(os.Stderr, "error: must provide one parse mode")
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *style != format.TreeIndented && *style != format.TreeSExpression {
+		fmt.Fprintf(os.Stderr, "error: unknown tree style %q\n", *style)
+		os.Exit(2)
+	}
+	if *diagnosticsFormat != diagnosticsText && *diagnosticsFormat != diagnosticsJSON {
+		fmt.Fprintf(os.Stderr, "error: unknown diagnostics format %q\n", *diagnosticsFormat)
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "error: must provide at least one file or directory")
+		os.Exit(2)
+	}
+
+	failed := false
+	visitSources(args, func(filename string) {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			failed = true
+			return
+		}
+
+		sd, diagnostics := format.Parse(bytes.NewReader(content))
+		if len(diagnostics) > 0 {
+			// the source is decoded as Parse does, for the excerpts
+			source, _ := format.Decode(content)
+			writeDiagnostics(os.Stderr, *diagnosticsFormat, filename, severityError, diagnostics, source)
+			failed = true
+		}
+		if *tree && sd != nil {
+			sd.WriteTree(os.Stdout, *style)
+		}
+	})
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runAST implements the ast subcommand
+func runAST(args []string) {
+	flags := flag.NewFlagSet("ast", flag.ExitOnError)
+	jsonFlag := flags.Bool("json", false, "print the parse tree as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: modelicafmt ast --json file")
+		flags.PrintDefaults()
+	}
+	args = parseInterspersed(flags, args)
+	if !*jsonFlag {
+		fmt.Fprintln(os.Stderr, "error: must provide an output format")
+		flags.Usage()
+		os.Exit(2)
+	}
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "error: must provide exactly one file")
+		os.Exit(2)
 	}
 
-	if isModelicaFile(f) {
-		processAndWriteFile(filename)
+	filename := args[0]
+	f, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
 	}
+	defer f.Close()
 
-	return nil
+	tree, diagnostics := format.Parse(f)
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", filename, diagnostic)
+	}
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(tree); err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(1)
+	}
 }
 
-func walkDir(path string) {
-	filepath.Walk(path, visitFile)
+// runConfig implements the config subcommand
+func runConfig(args []string) {
+	configUsage := func() {
+		fmt.Fprintln(os.Stderr, "usage: modelicafmt config init [--style name] [--force] [file]")
+		fmt.Fprintln(os.Stderr, "       modelicafmt config validate [file ...]")
+		fmt.Fprintln(os.Stderr, "       modelicafmt config schema")
+	}
+	if len(args) == 0 {
+		configUsage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		configUsage()
+		flags.PrintDefaults()
+	}
+	switch args[0] {
+	case "init":
+		style := flags.String("style", format.StyleDefault, "style preset of the written options, default, msl, buildings, compact or expanded")
+		force := flags.Bool("force", false, "overwrite an existing file")
+		args = parseInterspersed(flags, args[1:])
+		if len(args) > 1 {
+			fmt.Fprintln(os.Stderr, "error: must provide at most one file")
+			os.Exit(2)
+		}
+		filename := format.DefaultConfigFile
+		if len(args) == 1 {
+			filename = args[0]
+		}
+
+		var b bytes.Buffer
+		if err := format.WriteDefaultConfig(&b, *style); err != nil {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			os.Exit(2)
+		}
+		if filename == "-" {
+			os.Stdout.Write(b.Bytes())
+			return
+		}
+		if _, err := os.Stat(filename); err == nil && !*force {
+			fmt.Fprintf(os.Stderr, "error: %s already exists, use --force to overwrite it\n", filename)
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(filename, b.Bytes(), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			os.Exit(1)
+		}
+	case "validate":
+		args = parseInterspersed(flags, args[1:])
+		if len(args) == 0 {
+			args = []string{format.DefaultConfigFile}
+		}
+		schema := format.ConfigSchema()
+		failed := false
+		for _, filename := range args {
+			content, err := ioutil.ReadFile(filename)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error: "+err.Error())
+				failed = true
+				continue
+			}
+			diagnostics, err := format.ValidateConfig(content, schema)
+			if err != nil {
+				err = fmt.Errorf("%s: %v", filename, err)
+			} else if len(diagnostics) == 0 {
+				// the schema cannot express every check, such as the
+				// combinations of options
+				_, err = format.LoadConfig(filename, "")
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "error: "+err.Error())
+				failed = true
+			}
+			for _, diagnostic := range diagnostics {
+				fmt.Fprintf(os.Stderr, "%s:%s\n", filename, diagnostic)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	case "schema":
+		if args = parseInterspersed(flags, args[1:]); len(args) > 0 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(format.ConfigSchema()); err != nil {
+			fmt.Fprintln(os.Stderr, "error: "+err.Error())
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown config command %q\n", args[0])
+		configUsage()
+		os.Exit(2)
+	}
+}
+
+// parseInterspersed parses flags which may appear before or after the
+// positional arguments, and returns the positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "parse" {
+		runParse(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "ast" {
+		runAST(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfig(os.Args[2:])
+		return
+	}
+
 	flag.Usage = usage
 	flag.Parse()
 	if *versionFlag {
@@ -79,17 +557,45 @@ func main() {
 		fmt.Fprintln(os.Stderr, "error: must provide at least one file or directory")
 		os.Exit(2)
 	}
+	if (*verify || *safe) && *stream {
+		fmt.Fprintln(os.Stderr, "error: cannot verify the output of -stream, which is not kept")
+		os.Exit(2)
+	}
+	if *diagnostics != diagnosticsText && *diagnostics != diagnosticsJSON {
+		fmt.Fprintf(os.Stderr, "error: unknown diagnostics format %q\n", *diagnostics)
+		os.Exit(2)
+	}
 
-	for i := 0; i < flag.NArg(); i++ {
-		path := flag.Arg(i)
-		switch dir, err := os.Stat(path); {
-		case err != nil:
-			fmt.Fprintln(os.Stderr, "error: "+err.Error())
-			os.Exit(2)
-		case dir.IsDir():
-			walkDir(path)
-		default:
-			processAndWriteFile(path)
+	cfg, err := readConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		os.Exit(2)
+	}
+
+	failed := false
+	visitSources(flag.Args(), func(filename string) {
+		if !processAndWriteFile(filename, cfg) {
+			failed = true
 		}
+	})
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readConfig loads the configuration file given by the -config flag, or the
+// default configuration file if it exists, over the style preset given by the
+// -style flag
+func readConfig() (*format.Config, error) {
+	if *configFile != "" {
+		return format.LoadConfig(*configFile, *style)
+	}
+	if _, err := os.Stat(format.DefaultConfigFile); err == nil {
+		return format.LoadConfig(format.DefaultConfigFile, *style)
+	}
+	opts, err := format.StyleOptions(*style)
+	if err != nil {
+		return nil, err
 	}
+	return format.NewConfig(opts), nil
 }
@@ -0,0 +1,117 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urbanopt/modelica-fmt/format"
+)
+
+func TestProcessAndWriteFileInvalidDirective(t *testing.T) {
+	a := require.New(t)
+	dir, err := ioutil.TempDir("", "modelicafmt")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	invalid := filepath.Join(dir, "Invalid.mo")
+	valid := filepath.Join(dir, "Valid.mo")
+	a.NoError(ioutil.WriteFile(invalid, []byte("// modelica-fmt: indnt=4\nmodel A Real x; end A;\n"), 0644))
+	a.NoError(ioutil.WriteFile(valid, []byte("model B Real y; end B;\n"), 0644))
+
+	*write = true
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	a.NoError(err)
+	os.Stderr = w
+	defer func() {
+		*write = false
+		os.Stderr = stderr
+	}()
+
+	cfg := format.NewConfig(format.DefaultOptions())
+	a.False(processAndWriteFile(invalid, cfg), "The invalid directive should fail the file")
+	a.True(processAndWriteFile(valid, cfg), "The following files should still be formatted")
+	w.Close()
+	reported, err := ioutil.ReadAll(r)
+	a.NoError(err)
+
+	a.Contains(string(reported), invalid+": line 1: invalid directive: ")
+	content, err := ioutil.ReadFile(invalid)
+	a.NoError(err)
+	a.Equal("// modelica-fmt: indnt=4\nmodel A Real x; end A;\n", string(content))
+	content, err = ioutil.ReadFile(valid)
+	a.NoError(err)
+	a.Equal("model B\n  Real y;\nend B;\n", string(content))
+}
+
+func TestProcessAndWriteFileStreamUnsupported(t *testing.T) {
+	a := require.New(t)
+	dir, err := ioutil.TempDir("", "modelicafmt")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "A.mo")
+	a.NoError(ioutil.WriteFile(filename, []byte("model A Real x; end A;\n"), 0644))
+
+	*write, *stream = true, true
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	a.NoError(err)
+	os.Stderr = w
+	defer func() {
+		*write, *stream = false, false
+		os.Stderr = stderr
+	}()
+
+	opts := format.DefaultOptions()
+	opts.AlignDeclarations = true
+	opts.EndBlankLineAfter = 10
+	a.False(processAndWriteFile(filename, format.NewConfig(opts)), "Options -stream cannot honor should fail the file")
+	w.Close()
+	reported, err := ioutil.ReadAll(r)
+	a.NoError(err)
+
+	a.Contains(string(reported), filename+": cannot format one statement at a time with end-blank-line-after, align-declarations")
+	content, err := ioutil.ReadFile(filename)
+	a.NoError(err)
+	a.Equal("model A Real x; end A;\n", string(content))
+	files, err := ioutil.ReadDir(dir)
+	a.NoError(err)
+	a.Len(files, 1, "The temporary output should be removed")
+}
+
+func TestProcessAndWriteFileSafeTokenChanging(t *testing.T) {
+	a := require.New(t)
+	dir, err := ioutil.TempDir("", "modelicafmt")
+	a.NoError(err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "A.mo")
+	a.NoError(ioutil.WriteFile(filename, []byte("model A Real x, y; end A;\n"), 0644))
+
+	*write, *safe = true, true
+	stderr := os.Stderr
+	r, w, err := os.Pipe()
+	a.NoError(err)
+	os.Stderr = w
+	defer func() {
+		*write, *safe = false, false
+		os.Stderr = stderr
+	}()
+
+	opts := format.DefaultOptions()
+	opts.SplitDeclarations = true
+	opts.DropEmptyAnnotations = true
+	a.False(processAndWriteFile(filename, format.NewConfig(opts)), "Options changing the tokens should fail the file")
+	w.Close()
+	reported, err := ioutil.ReadAll(r)
+	a.NoError(err)
+
+	a.Contains(string(reported), filename+": -safe cannot be used with split-declarations, drop-empty-annotations, which change the tokens")
+	content, err := ioutil.ReadFile(filename)
+	a.NoError(err)
+	a.Equal("model A Real x, y; end A;\n", string(content))
+}
@@ -0,0 +1,17 @@
+package parser
+
+import "github.com/antlr/antlr4/runtime/Go/antlr"
+
+// NewLexerATN returns a copy of the ATN of the lexer. The ATN caches what it
+// computes while lexing, hence lexers which run concurrently need copies of
+// their own.
+func NewLexerATN() *antlr.ATN {
+	return antlr.NewATNDeserializer(nil).DeserializeFromUInt16(serializedLexerAtn)
+}
+
+// NewParserATN returns a copy of the ATN of the parser. The ATN caches the
+// tokens which may follow its states, e.g. when the parser recovers from
+// syntax errors, hence parsers which run concurrently need copies of their own.
+func NewParserATN() *antlr.ATN {
+	return antlr.NewATNDeserializer(nil).DeserializeFromUInt16(parserATN)
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2020, Alliance for Sustainable Energy, LLC.
+// All rights reserved.
+
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/urbanopt/modelica-fmt/format"
+)
+
+// main registers the JavaScript API and keeps the program running so it can
+// be called, see formatJS
+func main() {
+	js.Global().Set("modelicafmt", js.ValueOf(map[string]interface{}{
+		"format": js.FuncOf(formatJS),
+	}))
+	select {}
+}
+
+// formatJS implements modelicafmt.format(source, options) for JavaScript. The
+// options are an optional object with the keys of the configuration file, e.g.
+// {"line-endings": "crlf"}. It returns an object whose output property holds
+// the formatted source, or whose error property holds a message if formatting
+// failed.
+func formatJS(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 || args[0].Type() != js.TypeString {
+		return jsResult("", "format expects the source as a string")
+	}
+
+	opts := format.DefaultOptions()
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		encoded := js.Global().Get("JSON").Call("stringify", args[1]).String()
+		// JSON is a subset of YAML, hence options are decoded like a
+		// configuration file
+		var err error
+		if opts, err = format.DecodeOptions([]byte(encoded)); err != nil {
+			return jsResult("", err.Error())
+		}
+	}
+
+	output, err := format.FormatString(args[0].String(), opts)
+	if err == format.ErrSkipped {
+		// the source is left as it is
+		return jsResult(args[0].String(), "")
+	}
+	if err != nil {
+		return jsResult("", err.Error())
+	}
+	return jsResult(output, "")
+}
+
+// jsResult returns the value returned by modelicafmt.format
+func jsResult(output, message string) interface{} {
+	result := map[string]interface{}{"output": output, "error": nil}
+	if message != "" {
+		result["error"] = message
+	}
+	return js.ValueOf(result)
+}